@@ -1,17 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"path/filepath"
 
 	"github.com/go-chi/chi/v5"
 	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/joho/godotenv"
 	"github.com/money-management/backend/internal/handlers"
 	"github.com/money-management/backend/internal/repository"
+	"github.com/money-management/backend/internal/scheduler"
 	"github.com/money-management/backend/pkg/config"
 	"github.com/money-management/backend/pkg/middleware"
+	"github.com/money-management/backend/pkg/snapshot"
 )
 
 func main() {
@@ -36,9 +40,29 @@ func main() {
 	categoryRepo := repository.NewCategoryRepository(db)
 	transactionRepo := repository.NewTransactionRepository(db)
 	budgetRepo := repository.NewBudgetRepository(db)
+	envelopeRepo := repository.NewEnvelopeRepository(db)
 	goalRepo := repository.NewGoalRepository(db)
 	goalItemRepo := repository.NewGoalItemRepository(db)
 	recurringRepo := repository.NewRecurringRepository(db)
+	importProfileRepo := repository.NewImportProfileRepository(db)
+	backupRepo := repository.NewBackupRepository(db)
+
+	// Imports that were running when the server stopped can be resumed
+	if err := backupRepo.FailInterruptedImportJobs(); err != nil {
+		log.Printf("Failed to mark interrupted imports: %v", err)
+	}
+
+	// Back up every user's data on the configured schedule
+	snapshots := snapshot.NewStore(cfg.BackupDir)
+	backupScheduler, err := scheduler.NewBackupScheduler(userRepo, backupRepo, snapshots, cfg)
+	if err != nil {
+		log.Fatalf("Invalid backup configuration: %v", err)
+	}
+	backupScheduler.Start(context.Background())
+
+	// Post due recurring transactions for every user, independent of page loads
+	recurringScheduler := scheduler.NewRecurringScheduler(recurringRepo, cfg)
+	recurringScheduler.Start(context.Background())
 
 	// Initialize handlers
 	gamificationHandler := handlers.NewGamificationHandler(db) // Init early for injection
@@ -48,15 +72,17 @@ func main() {
 	categoryHandler := handlers.NewCategoryHandler(categoryRepo)
 	transactionHandler := handlers.NewTransactionHandler(transactionRepo, walletRepo, categoryRepo, gamificationHandler)
 	budgetHandler := handlers.NewBudgetHandler(budgetRepo)
+	envelopeHandler := handlers.NewEnvelopeHandler(envelopeRepo, budgetRepo)
 	goalHandler := handlers.NewGoalHandler(goalRepo, goalItemRepo, userRepo)
-	recurringHandler := handlers.NewRecurringHandler(recurringRepo, transactionRepo, walletRepo)
+	recurringHandler := handlers.NewRecurringHandler(recurringRepo, transactionRepo, walletRepo, categoryRepo, recurringScheduler)
 	dashboardHandler := handlers.NewDashboardHandler(transactionRepo, budgetRepo, categoryRepo, walletRepo, recurringRepo)
-	dataHandler := handlers.NewDataHandler(transactionRepo, categoryRepo, walletRepo, budgetRepo, goalRepo)
-	reportHandler := handlers.NewReportHandler(transactionRepo, categoryRepo)
+	dataHandler := handlers.NewDataHandler(transactionRepo, categoryRepo, walletRepo, budgetRepo, goalRepo, backupRepo, snapshots, filepath.Join(cfg.BackupDir, "imports"))
+	reportHandler := handlers.NewReportHandler(transactionRepo, categoryRepo, budgetRepo)
 	uploadHandler := handlers.NewUploadHandler()
 	debtHandler := handlers.NewDebtHandler(db)
 	calendarHandler := handlers.NewCalendarHandler(db)
 	currencyHandler := handlers.NewCurrencyHandler(db)
+	importHandler := handlers.NewImportHandler(transactionRepo, walletRepo, categoryRepo, importProfileRepo, currencyHandler)
 
 	// Setup router
 	r := chi.NewRouter()
@@ -79,6 +105,9 @@ func main() {
 		r.Post("/auth/login", authHandler.Login)
 		r.Post("/auth/google", authHandler.GoogleAuth)
 
+		// Calendar feed, authenticated by the token in the URL
+		r.Get("/calendar/feed/{token}", calendarHandler.Feed)
+
 		// Protected routes
 		r.Group(func(r chi.Router) {
 			r.Use(middleware.JWTAuth(cfg.JWTSecret))
@@ -104,6 +133,7 @@ func main() {
 
 			// Transactions
 			r.Get("/transactions", transactionHandler.List)
+			r.Get("/transactions/export", transactionHandler.Export)
 			r.Get("/transactions/{id}", transactionHandler.Get)
 			r.Post("/transactions", transactionHandler.Create)
 			r.Put("/transactions/{id}", transactionHandler.Update)
@@ -113,10 +143,18 @@ func main() {
 			// Budgets
 			r.Get("/budgets", budgetHandler.List)
 			r.Get("/budgets/{id}", budgetHandler.Get)
+			r.Get("/budgets/{id}/periods", budgetHandler.Periods)
 			r.Post("/budgets", budgetHandler.Create)
 			r.Put("/budgets/{id}", budgetHandler.Update)
 			r.Delete("/budgets/{id}", budgetHandler.Delete)
 
+			// Envelope budgeting
+			r.Get("/envelopes", envelopeHandler.GetMonth)
+			r.Post("/envelopes/assign", envelopeHandler.Assign)
+			r.Post("/envelopes/move", envelopeHandler.Move)
+			r.Get("/envelopes/moves", envelopeHandler.ListMoves)
+			r.Post("/envelopes/rollover", envelopeHandler.Rollover)
+
 			// Dashboard
 			r.Get("/dashboard/summary", dashboardHandler.GetSummary)
 
@@ -138,11 +176,47 @@ func main() {
 			// Recurring Transactions
 			r.Get("/recurring", recurringHandler.List)
 			r.Post("/recurring", recurringHandler.Create)
+			r.Get("/recurring/{id}", recurringHandler.Get)
+			r.Put("/recurring/{id}", recurringHandler.Update)
 			r.Delete("/recurring/{id}", recurringHandler.Delete)
+			r.Post("/recurring/{id}/pause", recurringHandler.Pause)
+			r.Post("/recurring/{id}/resume", recurringHandler.Resume)
+			r.Post("/recurring/{id}/skip", recurringHandler.Skip)
+			r.Post("/recurring/{id}/post-now", recurringHandler.PostNow)
+			r.Get("/recurring/{id}/history", recurringHandler.History)
+			r.Get("/recurring/review", recurringHandler.ListReview)
+			r.Get("/recurring/suggestions", recurringHandler.Suggestions)
+			r.Get("/recurring/pending", recurringHandler.ListPending)
+			r.Post("/recurring/occurrences/{id}/confirm", recurringHandler.ConfirmOccurrence)
+			r.Post("/recurring/occurrences/{id}/skip", recurringHandler.SkipOccurrence)
+			r.Post("/recurring/occurrences/{id}/snooze", recurringHandler.SnoozeOccurrence)
+			r.Put("/recurring/occurrences/{id}/amount", recurringHandler.SetOccurrenceAmount)
 
 			// Data Management
 			r.Get("/data/export", dataHandler.Export)
+			r.Get("/data/export/qif", dataHandler.ExportQIF)
+			r.Get("/data/export/ledger", dataHandler.ExportLedger)
 			r.Post("/data/import", dataHandler.Import)
+			r.Get("/data/export/stream", dataHandler.ExportStream)
+			r.Post("/data/import/stream", dataHandler.ImportStream)
+			r.Get("/data/import/jobs/{id}", dataHandler.ImportJobStatus)
+			r.Post("/data/import/jobs/{id}/resume", dataHandler.ResumeImportJob)
+			r.Get("/data/snapshots", dataHandler.ListSnapshots)
+			r.Post("/data/snapshots/{name}/restore", dataHandler.RestoreSnapshot)
+
+			// Statement Import
+			r.Post("/import/csv/preview", importHandler.PreviewCSV)
+			r.Post("/import/csv/commit", importHandler.CommitCSV)
+			r.Post("/import/ofx/preview", importHandler.PreviewOFX)
+			r.Post("/import/ofx/commit", importHandler.CommitOFX)
+			r.Post("/import/qif/preview", importHandler.PreviewQIF)
+			r.Post("/import/qif/commit", importHandler.CommitQIF)
+			r.Post("/import/apps/preview", importHandler.PreviewApp)
+			r.Post("/import/apps/commit", importHandler.CommitApp)
+			r.Get("/import/profiles", importHandler.ListProfiles)
+			r.Post("/import/profiles", importHandler.CreateProfile)
+			r.Put("/import/profiles/{id}", importHandler.UpdateProfile)
+			r.Delete("/import/profiles/{id}", importHandler.DeleteProfile)
 
 			// Gamification
 			r.Get("/gamification/status", gamificationHandler.GetStatus)
@@ -152,6 +226,7 @@ func main() {
 
 			// Reports
 			r.Get("/reports/monthly", reportHandler.GetMonthlyReport)
+			r.Get("/reports/monthly.pdf", reportHandler.GetMonthlyReportPDF)
 
 			// Upload
 			r.Post("/upload", uploadHandler.Upload)
@@ -164,6 +239,10 @@ func main() {
 
 			// Calendar
 			r.Get("/calendar/events", calendarHandler.GetEvents)
+			r.Get("/calendar/feed", calendarHandler.GetFeed)
+			r.Post("/calendar/feed", calendarHandler.CreateFeed)
+			r.Put("/calendar/feed", calendarHandler.UpdateFeed)
+			r.Delete("/calendar/feed", calendarHandler.DeleteFeed)
 
 			// Currencies
 			r.Get("/currencies", currencyHandler.GetRates)
@@ -53,12 +53,17 @@ func GetFinancialScore(db *gorm.DB) http.HandlerFunc {
 		for _, t := range transactions {
 			if t.Type == "income" {
 				totalIncome += t.Amount
-			} else if t.Type == "expense" {
-				totalExpense += t.Amount
+			} else if t.Type == "expense" || t.Type == "refund" {
+				// Refunds reduce spending rather than adding income
+				amount := t.Amount
+				if t.Type == "refund" {
+					amount = -amount
+				}
+				totalExpense += amount
 				if t.Category.IsEssential {
-					essentialData += t.Amount
+					essentialData += amount
 				} else {
-					nonEssentialData += t.Amount
+					nonEssentialData += amount
 				}
 			}
 		}
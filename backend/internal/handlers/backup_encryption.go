@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/money-management/backend/pkg/encryption"
+)
+
+// backupPassphraseHeader carries the passphrase for encrypted backups. A
+// header keeps it out of URLs and access logs.
+const backupPassphraseHeader = "X-Backup-Passphrase"
+
+var errBackupPassphraseRequired = errors.New("Backup is encrypted, a passphrase is required")
+
+// openBackupFile returns the plaintext of an uploaded backup, decrypting it
+// when it is encrypted. The passphrase is checked right away; tampering is
+// only detected while reading, so callers read everything before restoring.
+func openBackupFile(file io.Reader, passphrase string) (io.Reader, error) {
+	buffered := bufio.NewReader(file)
+	prefix, _ := buffered.Peek(encryption.MagicSize)
+	if !encryption.IsEncrypted(prefix) {
+		return buffered, nil
+	}
+	if passphrase == "" {
+		return nil, errBackupPassphraseRequired
+	}
+	return encryption.NewReader(buffered, passphrase)
+}
+
+// backupFileError answers a failure to open or decrypt a backup
+func backupFileError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errBackupPassphraseRequired):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, encryption.ErrWrongPassphrase):
+		http.Error(w, "Wrong passphrase", http.StatusBadRequest)
+	case errors.Is(err, encryption.ErrCorrupted):
+		http.Error(w, "Backup file is corrupted or has been tampered with", http.StatusBadRequest)
+	default:
+		http.Error(w, "Invalid backup: "+err.Error(), http.StatusBadRequest)
+	}
+}
+
+// encryptedExport wraps w in an encrypting writer when the request carries a
+// passphrase. The returned finish writes the last chunk.
+func encryptedExport(w io.Writer, r *http.Request) (io.Writer, func() error, error) {
+	passphrase := r.Header.Get(backupPassphraseHeader)
+	if passphrase == "" {
+		return w, func() error { return nil }, nil
+	}
+	enc, err := encryption.NewWriter(w, passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, enc.Close, nil
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/money-management/backend/internal/models"
+)
+
+// backupUpgrades converts a backup of version N (the key) to version N+1.
+// They work on the raw JSON document so old formats need no Go types.
+var backupUpgrades = map[int]func(doc map[string]interface{}) error{
+	1: upgradeBackupV1,
+	2: upgradeBackupV2,
+}
+
+// decodeBackup reads a backup of any supported version and returns it in the
+// current format. Backups from a newer version of the app are rejected.
+func decodeBackup(r io.Reader) (*models.Backup, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.New("Error reading file")
+	}
+
+	var doc map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errors.New("Invalid JSON format")
+	}
+
+	version := 1 // Backups without a version field predate versioning
+	if v, ok := doc["version"]; ok {
+		number, _ := v.(json.Number)
+		n, err := number.Int64()
+		if err != nil || n < 1 {
+			return nil, errors.New("Invalid backup version")
+		}
+		version = int(n)
+	}
+	if version > models.BackupVersion {
+		return nil, fmt.Errorf("Backup version %d is newer than supported version %d, please update the app", version, models.BackupVersion)
+	}
+
+	for ; version < models.BackupVersion; version++ {
+		upgrade, ok := backupUpgrades[version]
+		if !ok {
+			return nil, fmt.Errorf("Backup version %d can no longer be restored", version)
+		}
+		if err := upgrade(doc); err != nil {
+			return nil, fmt.Errorf("Error upgrading backup from version %d: %v", version, err)
+		}
+		doc["version"] = version + 1
+	}
+
+	upgraded, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var backup models.Backup
+	if err := json.Unmarshal(upgraded, &backup); err != nil {
+		return nil, errors.New("Invalid backup format")
+	}
+	return &backup, nil
+}
+
+// upgradeBackupV1 converts the legacy export (raw models, goals with nested
+// members) to version 2. The exporting user is recognised by the user_id on
+// the wallets and categories, and goals shared by other users are dropped.
+// Records pointing to wallets or categories missing from the file get
+// deleted placeholders so they can still be restored.
+func upgradeBackupV1(doc map[string]interface{}) error {
+	var exporter json.Number
+	for _, key := range []string{"wallets", "categories"} {
+		for _, item := range jsonList(doc, key) {
+			if id, ok := item["user_id"].(json.Number); ok && exporter == "" {
+				exporter = id
+			}
+		}
+	}
+
+	var goals, members, contributions []interface{}
+	for _, goal := range jsonList(doc, "goals") {
+		if exporter != "" && goal["user_id"] != exporter {
+			continue
+		}
+		for _, m := range jsonListOf(goal["members"]) {
+			members = append(members, legacyGoalUser(m, exporter, map[string]interface{}{
+				"goal_id":   goal["id"],
+				"role":      m["role"],
+				"joined_at": m["joined_at"],
+			}))
+		}
+		for _, c := range jsonListOf(goal["transactions"]) {
+			contributions = append(contributions, legacyGoalUser(c, exporter, map[string]interface{}{
+				"id":         c["id"],
+				"created_at": c["created_at"],
+				"goal_id":    goal["id"],
+				"amount":     c["amount"],
+				"date":       c["date"],
+				"notes":      c["notes"],
+			}))
+		}
+		delete(goal, "members")
+		delete(goal, "transactions")
+		goals = append(goals, goal)
+	}
+	doc["goals"] = goals
+	doc["goal_members"] = members
+	doc["goal_contributions"] = contributions
+
+	wallets := make(map[string]bool)
+	for _, w := range jsonList(doc, "wallets") {
+		wallets[fmt.Sprint(w["id"])] = true
+	}
+	categories := make(map[string]bool)
+	for _, c := range jsonList(doc, "categories") {
+		categories[fmt.Sprint(c["id"])] = true
+	}
+	for _, key := range []string{"transactions", "budgets"} {
+		for _, item := range jsonList(doc, key) {
+			if id, ok := item["wallet_id"]; ok && !wallets[fmt.Sprint(id)] {
+				wallets[fmt.Sprint(id)] = true
+				doc["wallets"] = append(doc["wallets"].([]interface{}), map[string]interface{}{
+					"id": id, "name": "Restored wallet", "deleted": true,
+				})
+			}
+			if id, ok := item["category_id"]; ok && !categories[fmt.Sprint(id)] {
+				categories[fmt.Sprint(id)] = true
+				categoryType := "expense"
+				if item["type"] == "income" {
+					categoryType = "income"
+				}
+				doc["categories"] = append(doc["categories"].([]interface{}), map[string]interface{}{
+					"id": id, "name": "Restored category", "type": categoryType, "deleted": true,
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// upgradeBackupV2 needs no changes: version 3 only added fields and record
+// lists, and their zero values mean what version 2 backups had
+func upgradeBackupV2(doc map[string]interface{}) error {
+	return nil
+}
+
+// legacyGoalUser attributes a v1 goal member or contribution to the
+// exporter (self) or to another user by the email of the preloaded user
+func legacyGoalUser(item map[string]interface{}, exporter json.Number, out map[string]interface{}) map[string]interface{} {
+	if item["user_id"] == exporter {
+		out["self"] = true
+	} else if user, ok := item["user"].(map[string]interface{}); ok {
+		out["email"] = user["email"]
+	}
+	return out
+}
+
+// jsonList returns doc[key] as a list of objects, creating an empty list if
+// the key is missing
+func jsonList(doc map[string]interface{}, key string) []map[string]interface{} {
+	if _, ok := doc[key].([]interface{}); !ok {
+		doc[key] = []interface{}{}
+	}
+	return jsonListOf(doc[key])
+}
+
+func jsonListOf(v interface{}) []map[string]interface{} {
+	list, _ := v.([]interface{})
+	out := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		if m, ok := item.(map[string]interface{}); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
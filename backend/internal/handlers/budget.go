@@ -24,35 +24,46 @@ type CreateBudgetRequest struct {
 	CategoryID uint    `json:"category_id"`
 	Amount     float64 `json:"amount"`
 	Period     string  `json:"period"`
+	// YYYY-MM-DD the windows start from, e.g. a 25th for months running
+	// from payday; empty follows the calendar
+	StartDate string `json:"start_date"`
+	// none (default), unspent, overspent or both, with an optional cap on
+	// what carries over
+	RolloverPolicy string   `json:"rollover_policy"`
+	RolloverCap    *float64 `json:"rollover_cap"`
+	// cap (default) or envelope for a monthly envelope funded from the
+	// to-be-budgeted pool
+	Mode string `json:"mode"`
 }
 
 type UpdateBudgetRequest struct {
-	Amount float64 `json:"amount"`
-	Period string  `json:"period"`
+	Amount         float64  `json:"amount"`
+	Period         string   `json:"period"`
+	StartDate      *string  `json:"start_date"` // Empty string goes back to the calendar
+	RolloverPolicy *string  `json:"rollover_policy"`
+	RolloverCap    *float64 `json:"rollover_cap"` // Negative removes the cap
 }
 
 func (h *BudgetHandler) List(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 
-	// Parse month and year query params
+	// Each budget covers its own window containing this date: today, or the
+	// last day of the month picked with month and year
+	now := time.Now()
+	at := now
 	month, _ := strconv.Atoi(r.URL.Query().Get("month"))
 	year, _ := strconv.Atoi(r.URL.Query().Get("year"))
-
-	now := time.Now()
-	if month == 0 {
-		month = int(now.Month())
+	if month != 0 || year != 0 {
+		if month == 0 {
+			month = int(now.Month())
+		}
+		if year == 0 {
+			year = now.Year()
+		}
+		at = budgetReferenceDate(time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local), now)
 	}
-	if year == 0 {
-		year = now.Year()
-	}
-
-	// Calculate start and end of month
-	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local)
-	// End of month is start of next month minus 1 second (or 1 nanosecond)
-	// Using AddDate(0, 1, 0) gives start of next month. Add(-1 * time.Second) gives 23:59:59 of current month.
-	endDate := startDate.AddDate(0, 1, 0).Add(-1 * time.Second)
 
-	budgets, err := h.budgetRepo.GetBudgetsWithSpending(userID, startDate, endDate)
+	budgets, err := h.budgetRepo.GetBudgetsWithSpending(userID, at)
 	if err != nil {
 		http.Error(w, "Error fetching budgets", http.StatusInternalServerError)
 		return
@@ -106,12 +117,52 @@ func (h *BudgetHandler) Create(w http.ResponseWriter, r *http.Request) {
 	if period == "" {
 		period = "monthly"
 	}
+	if !validBudgetPeriod(period) {
+		http.Error(w, "Invalid period, use monthly, weekly or yearly", http.StatusBadRequest)
+		return
+	}
+	startDate, err := parseBudgetStartDate(req.StartDate)
+	if err != nil {
+		http.Error(w, "Invalid start date, use YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = "cap"
+	}
+	if req.Mode != "cap" && req.Mode != "envelope" {
+		http.Error(w, "Invalid mode, use cap or envelope", http.StatusBadRequest)
+		return
+	}
+	if req.Mode == "envelope" && period != "monthly" {
+		http.Error(w, "Envelopes are monthly", http.StatusBadRequest)
+		return
+	}
+	// Envelopes follow calendar months and carry over what is available
+	if req.Mode == "envelope" && (req.StartDate != "" || (req.RolloverPolicy != "" && req.RolloverPolicy != "none") || req.RolloverCap != nil) {
+		http.Error(w, "Envelopes follow calendar months and have no start date or rollover policy", http.StatusBadRequest)
+		return
+	}
+	if req.RolloverPolicy == "" {
+		req.RolloverPolicy = "none"
+	}
+	if !validRolloverPolicy(req.RolloverPolicy) {
+		http.Error(w, "Invalid rollover policy, use none, unspent, overspent or both", http.StatusBadRequest)
+		return
+	}
+	if req.RolloverCap != nil && *req.RolloverCap < 0 {
+		http.Error(w, "Rollover cap can't be negative", http.StatusBadRequest)
+		return
+	}
 
 	budget := &models.Budget{
-		UserID:     userID,
-		CategoryID: req.CategoryID,
-		Amount:     req.Amount,
-		Period:     period,
+		UserID:         userID,
+		CategoryID:     req.CategoryID,
+		Amount:         req.Amount,
+		Period:         period,
+		StartDate:      startDate,
+		RolloverPolicy: req.RolloverPolicy,
+		RolloverCap:    req.RolloverCap,
+		Mode:           req.Mode,
 	}
 
 	if err := h.budgetRepo.Create(budget); err != nil {
@@ -153,16 +204,61 @@ func (h *BudgetHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	period, startDate := budget.Period, budget.StartDate
+
+	if budget.Mode == "envelope" && ((req.StartDate != nil && *req.StartDate != "") ||
+		(req.RolloverPolicy != nil && *req.RolloverPolicy != "none") || (req.RolloverCap != nil && *req.RolloverCap >= 0)) {
+		http.Error(w, "Envelopes follow calendar months and have no start date or rollover policy", http.StatusBadRequest)
+		return
+	}
+
 	budget.Amount = req.Amount
 	if req.Period != "" {
+		if !validBudgetPeriod(req.Period) {
+			http.Error(w, "Invalid period, use monthly, weekly or yearly", http.StatusBadRequest)
+			return
+		}
+		if budget.Mode == "envelope" && req.Period != "monthly" {
+			http.Error(w, "Envelopes are monthly", http.StatusBadRequest)
+			return
+		}
 		budget.Period = req.Period
 	}
+	if req.StartDate != nil {
+		startDate, err := parseBudgetStartDate(*req.StartDate)
+		if err != nil {
+			http.Error(w, "Invalid start date, use YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		budget.StartDate = startDate
+	}
+	if req.RolloverPolicy != nil {
+		if !validRolloverPolicy(*req.RolloverPolicy) {
+			http.Error(w, "Invalid rollover policy, use none, unspent, overspent or both", http.StatusBadRequest)
+			return
+		}
+		budget.RolloverPolicy = *req.RolloverPolicy
+	}
+	if req.RolloverCap != nil {
+		budget.RolloverCap = req.RolloverCap
+		if *req.RolloverCap < 0 {
+			budget.RolloverCap = nil
+		}
+	}
 
 	if err := h.budgetRepo.Update(budget); err != nil {
 		http.Error(w, "Error updating budget", http.StatusInternalServerError)
 		return
 	}
 
+	// The stored windows no longer line up, rollover starts over
+	if budget.Period != period || !budget.StartDate.Equal(startDate) {
+		if err := h.budgetRepo.ResetPeriods(budget.ID); err != nil {
+			http.Error(w, "Error resetting budget periods", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Fetch with category
 	budget, _ = h.budgetRepo.FindByID(budget.ID)
 
@@ -197,3 +293,79 @@ func (h *BudgetHandler) Delete(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// Periods returns the past windows of a budget with what was spent and
+// rolled over in each, the current one first. count limits them, 12 by
+// default.
+func (h *BudgetHandler) Periods(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid budget ID", http.StatusBadRequest)
+		return
+	}
+
+	budget, err := h.budgetRepo.FindByID(uint(id))
+	if err != nil {
+		http.Error(w, "Budget not found", http.StatusNotFound)
+		return
+	}
+
+	userID := middleware.GetUserID(r)
+	if budget.UserID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if budget.Mode == "envelope" {
+		http.Error(w, "Envelopes have months instead of periods, see /envelopes", http.StatusBadRequest)
+		return
+	}
+
+	count := 12
+	if c, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && c > 0 && c <= 120 {
+		count = c
+	}
+
+	periods, err := h.budgetRepo.GetPeriods(budget, time.Now(), count)
+	if err != nil {
+		http.Error(w, "Error fetching budget periods", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(periods)
+}
+
+func validBudgetPeriod(period string) bool {
+	switch period {
+	case "monthly", "weekly", "yearly":
+		return true
+	}
+	return false
+}
+
+func validRolloverPolicy(policy string) bool {
+	switch policy {
+	case "none", "unspent", "overspent", "both":
+		return true
+	}
+	return false
+}
+
+func parseBudgetStartDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// budgetReferenceDate picks the day whose budget windows a month shows: now
+// in the current month, otherwise the month's last day
+func budgetReferenceDate(month, now time.Time) time.Time {
+	end := month.AddDate(0, 1, 0).Add(-time.Second)
+	if !now.Before(month) && !now.After(end) {
+		return now
+	}
+	return end
+}
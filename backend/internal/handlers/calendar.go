@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/internal/repository"
 	"github.com/money-management/backend/pkg/middleware"
 	"gorm.io/gorm"
 )
@@ -28,6 +29,8 @@ type CalendarEvent struct {
 	Source       string  `json:"source"` // recurring, debt
 	SourceID     uint    `json:"source_id"`
 	CategoryIcon string  `json:"category_icon,omitempty"`
+	Estimated    bool    `json:"estimated,omitempty"`   // Amount is an estimate from past occurrences
+	AmountType   string  `json:"amount_type,omitempty"` // Recurring only; with percent_balance Amount is a percentage of the wallet balance
 }
 
 type CalendarResponse struct {
@@ -65,6 +68,12 @@ func (h *CalendarHandler) GetEvents(w http.ResponseWriter, r *http.Request) {
 	h.db.Preload("Category").Where("user_id = ? AND is_active = ?", userID, true).Find(&recurring)
 
 	for _, rec := range recurring {
+		amount, err := h.recurringAmount(rec)
+		if err != nil {
+			http.Error(w, "Error estimating amounts", http.StatusInternalServerError)
+			return
+		}
+
 		// Generate occurrences for this month
 		occurrences := h.generateOccurrences(rec, startOfMonth, endOfMonth)
 		for _, date := range occurrences {
@@ -76,11 +85,13 @@ func (h *CalendarHandler) GetEvents(w http.ResponseWriter, r *http.Request) {
 				ID:           rec.ID,
 				Date:         date.Format("2006-01-02"),
 				Title:        rec.Description,
-				Amount:       rec.Amount,
+				Amount:       amount,
 				Type:         rec.Type,
 				Source:       "recurring",
 				SourceID:     rec.ID,
 				CategoryIcon: icon,
+				Estimated:    rec.AmountType == "estimated",
+				AmountType:   rec.AmountType,
 			})
 		}
 	}
@@ -114,37 +125,15 @@ func (h *CalendarHandler) GetEvents(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (h *CalendarHandler) generateOccurrences(rec models.RecurringTransaction, start, end time.Time) []time.Time {
-	var dates []time.Time
-	current := rec.NextRunDate
-
-	// If next_run is before our range, calculate forward
-	for current.Before(start) {
-		current = h.addFrequency(current, rec.Frequency)
-	}
-
-	// Generate all occurrences within the range
-	for !current.After(end) {
-		if !current.Before(start) {
-			dates = append(dates, current)
-		}
-		current = h.addFrequency(current, rec.Frequency)
+// recurringAmount is what each occurrence of rec is expected to come to,
+// the estimate for estimated amounts
+func (h *CalendarHandler) recurringAmount(rec models.RecurringTransaction) (float64, error) {
+	if rec.AmountType != "estimated" {
+		return rec.Amount, nil
 	}
-
-	return dates
+	return repository.EstimateAmount(h.db, &rec)
 }
 
-func (h *CalendarHandler) addFrequency(t time.Time, freq string) time.Time {
-	switch freq {
-	case "daily":
-		return t.AddDate(0, 0, 1)
-	case "weekly":
-		return t.AddDate(0, 0, 7)
-	case "monthly":
-		return t.AddDate(0, 1, 0)
-	case "yearly":
-		return t.AddDate(1, 0, 0)
-	default:
-		return t.AddDate(0, 1, 0)
-	}
+func (h *CalendarHandler) generateOccurrences(rec models.RecurringTransaction, start, end time.Time) []time.Time {
+	return repository.Occurrences(&rec, start, end)
 }
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/pkg/ical"
+	"github.com/money-management/backend/pkg/middleware"
+	"gorm.io/gorm"
+)
+
+// Limits of the feed settings
+const (
+	maxFeedMonths       = 24
+	maxFeedAlarmMinutes = 7 * 24 * 60
+)
+
+type CalendarFeedRequest struct {
+	Months       *int `json:"months"`
+	AlarmMinutes *int `json:"alarm_minutes"`
+}
+
+type CalendarFeedResponse struct {
+	*models.CalendarFeed
+	// Only returned when the token is created, it can't be looked up later
+	Token     string `json:"token,omitempty"`
+	URL       string `json:"url,omitempty"`
+	WebcalURL string `json:"webcal_url,omitempty"`
+}
+
+// GetFeed returns the settings of the user's calendar feed
+func (h *CalendarHandler) GetFeed(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var feed models.CalendarFeed
+	if err := h.db.Where("user_id = ?", userID).First(&feed).Error; err != nil {
+		http.Error(w, "Calendar feed not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CalendarFeedResponse{CalendarFeed: &feed})
+}
+
+// CreateFeed creates the user's calendar feed, or rotates its token when it
+// exists, which revokes the previous URL. The response holds the new URL.
+func (h *CalendarHandler) CreateFeed(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req CalendarFeedRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	feed := models.CalendarFeed{UserID: userID, Months: 3}
+	if err := h.db.Where("user_id = ?", userID).First(&feed).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Error creating calendar feed", http.StatusInternalServerError)
+		return
+	}
+	if err := applyFeedSettings(&feed, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, err := newFeedToken()
+	if err != nil {
+		http.Error(w, "Error creating calendar feed", http.StatusInternalServerError)
+		return
+	}
+	feed.TokenHash = hashFeedToken(token)
+
+	if err := h.db.Save(&feed).Error; err != nil {
+		http.Error(w, "Error creating calendar feed", http.StatusInternalServerError)
+		return
+	}
+
+	url := feedURL(r, token)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CalendarFeedResponse{
+		CalendarFeed: &feed,
+		Token:        token,
+		URL:          url,
+		WebcalURL:    "webcal://" + url[strings.Index(url, "://")+3:],
+	})
+}
+
+// UpdateFeed changes the window and reminder of the feed, keeping its URL
+func (h *CalendarHandler) UpdateFeed(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req CalendarFeedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var feed models.CalendarFeed
+	if err := h.db.Where("user_id = ?", userID).First(&feed).Error; err != nil {
+		http.Error(w, "Calendar feed not found", http.StatusNotFound)
+		return
+	}
+	if err := applyFeedSettings(&feed, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Update the columns explicitly, Save would skip an alarm set back to 0
+	if err := h.db.Model(&feed).Updates(map[string]interface{}{"months": feed.Months, "alarm_minutes": feed.AlarmMinutes}).Error; err != nil {
+		http.Error(w, "Error updating calendar feed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CalendarFeedResponse{CalendarFeed: &feed})
+}
+
+// DeleteFeed revokes the feed URL
+func (h *CalendarHandler) DeleteFeed(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	result := h.db.Where("user_id = ?", userID).Delete(&models.CalendarFeed{})
+	if result.Error != nil {
+		http.Error(w, "Error deleting calendar feed", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Calendar feed not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Feed serves the iCalendar feed for the token in the URL. It is public, the
+// token is the credential: upcoming recurring transactions and unpaid debt
+// due dates from a month back to the feed's window ahead.
+func (h *CalendarHandler) Feed(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimSuffix(chi.URLParam(r, "token"), ".ics")
+
+	var feed models.CalendarFeed
+	if token == "" || h.db.Where("token_hash = ?", hashFeedToken(token)).First(&feed).Error != nil {
+		http.Error(w, "Calendar feed not found", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	start := today.AddDate(0, -1, 0)
+	end := today.AddDate(0, feed.Months, 0)
+
+	calendar := &ical.Calendar{Name: "Money Management", Refresh: time.Hour}
+	alarm := time.Duration(feed.AlarmMinutes) * time.Minute
+
+	var recurring []models.RecurringTransaction
+	if err := h.db.Preload("Category").Preload("Wallet").Where("user_id = ? AND is_active = ?", feed.UserID, true).Find(&recurring).Error; err != nil {
+		http.Error(w, "Error fetching recurring transactions", http.StatusInternalServerError)
+		return
+	}
+	for _, rec := range recurring {
+		amount, err := h.recurringAmount(rec)
+		if err != nil {
+			http.Error(w, "Error estimating amounts", http.StatusInternalServerError)
+			return
+		}
+		for _, date := range h.generateOccurrences(rec, start, end) {
+			calendar.Events = append(calendar.Events, ical.Event{
+				// One UID per occurrence, keyed by its date, stays the same across fetches
+				UID:          fmt.Sprintf("recurring-%d-%s@money-management", rec.ID, date.Format("20060102")),
+				Date:         date,
+				Summary:      fmt.Sprintf("%s (%s)", rec.Description, feedAmount(rec, amount)),
+				Description:  strings.TrimSpace(fmt.Sprintf("%s\n%s", rec.Category.Name, rec.Wallet.Name)),
+				Categories:   []string{"Recurring", rec.Type},
+				LastModified: rec.UpdatedAt,
+				AlarmBefore:  alarm,
+			})
+		}
+	}
+
+	var debts []models.Debt
+	if err := h.db.Where("user_id = ? AND status = ? AND due_date >= ? AND due_date < ?", feed.UserID, "unpaid", start, end).Find(&debts).Error; err != nil {
+		http.Error(w, "Error fetching debts", http.StatusInternalServerError)
+		return
+	}
+	for _, debt := range debts {
+		category, sign := "Debt payable", "-"
+		if debt.Type == "receivable" {
+			category, sign = "Debt receivable", "+"
+		}
+		calendar.Events = append(calendar.Events, ical.Event{
+			UID:          fmt.Sprintf("debt-%d@money-management", debt.ID),
+			Date:         *debt.DueDate,
+			Summary:      fmt.Sprintf("%s - %s (%sRp %s)", debt.PersonName, debt.Description, sign, formatThousands(debt.Amount, ".")),
+			Description:  debt.Description,
+			Categories:   []string{category},
+			LastModified: debt.UpdatedAt,
+			AlarmBefore:  alarm,
+		})
+	}
+
+	h.db.Model(&feed).UpdateColumn("last_accessed_at", now)
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", "inline; filename=money-management.ics")
+	w.Header().Set("Cache-Control", "private, max-age=900")
+	calendar.Write(w)
+}
+
+func applyFeedSettings(feed *models.CalendarFeed, req CalendarFeedRequest) error {
+	if req.Months != nil {
+		if *req.Months < 1 || *req.Months > maxFeedMonths {
+			return fmt.Errorf("months must be between 1 and %d", maxFeedMonths)
+		}
+		feed.Months = *req.Months
+	}
+	if req.AlarmMinutes != nil {
+		if *req.AlarmMinutes < 0 || *req.AlarmMinutes > maxFeedAlarmMinutes {
+			return fmt.Errorf("alarm_minutes must be between 0 and %d", maxFeedAlarmMinutes)
+		}
+		feed.AlarmMinutes = *req.AlarmMinutes
+	}
+	return nil
+}
+
+// newFeedToken returns 256 random bits, URL-safe
+func newFeedToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// feedURL builds the public feed URL from the request, honouring a reverse
+// proxy's X-Forwarded-Proto and X-Forwarded-Host
+func feedURL(r *http.Request, token string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+	}
+	host := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+		host = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	return fmt.Sprintf("%s://%s/api/calendar/feed/%s.ics", scheme, host, token)
+}
+
+// feedAmount formats a recurring amount with its direction, e.g. "-Rp 150.000",
+// "-20% saldo" for a percentage of the balance or "-Rp 352.500, perkiraan"
+// for an estimate; transfers have no sign
+func feedAmount(rec models.RecurringTransaction, amount float64) string {
+	sign := "-"
+	switch rec.Type {
+	case "income":
+		sign = "+"
+	case "transfer":
+		sign = ""
+	}
+	if rec.AmountType == "percent_balance" {
+		return fmt.Sprintf("%s%g%% saldo", sign, rec.Amount)
+	}
+	if rec.AmountType == "estimated" {
+		return sign + "Rp " + formatThousands(amount, ".") + ", perkiraan"
+	}
+	return sign + "Rp " + formatThousands(amount, ".")
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/money-management/backend/internal/models"
+)
+
+// categorySuggester proposes categories for imported statement lines based on
+// the user's own history: an identical description first, then the same
+// merchant keyword, then a category whose name appears in the description.
+type categorySuggester struct {
+	byDescription map[string]uint // type + normalized description
+	byKeyword     map[string]uint // type + first significant word
+	categories    []models.Category
+}
+
+// Words that banks put in front of almost every line and say nothing about the merchant
+var suggestionStopWords = map[string]bool{
+	"trsf": true, "transfer": true, "ebanking": true, "banking": true, "debit": true, "kredit": true,
+	"credit": true, "pembayaran": true, "pembelian": true, "payment": true, "purchase": true,
+	"otomatis": true, "via": true, "dari": true, "qris": true, "edc": true, "pos": true, "atm": true,
+}
+
+func (h *ImportHandler) newCategorySuggester(userID uint, categories []models.Category) *categorySuggester {
+	s := &categorySuggester{
+		byDescription: make(map[string]uint),
+		byKeyword:     make(map[string]uint),
+		categories:    categories,
+	}
+
+	history, _ := h.transactionRepo.GetDescriptionCategories(userID, time.Now().AddDate(-2, 0, 0))
+	// History is ordered by use count, so the first category seen for a key wins
+	for _, entry := range history {
+		words := suggestionWords(entry.Description)
+		if len(words) == 0 {
+			continue
+		}
+		descKey := entry.Type + "|" + strings.Join(words, " ")
+		if _, ok := s.byDescription[descKey]; !ok {
+			s.byDescription[descKey] = entry.CategoryID
+		}
+		wordKey := entry.Type + "|" + words[0]
+		if _, ok := s.byKeyword[wordKey]; !ok {
+			s.byKeyword[wordKey] = entry.CategoryID
+		}
+	}
+	return s
+}
+
+// suggest returns a category ID for the description, or 0 when nothing fits
+func (s *categorySuggester) suggest(description, transactionType string) uint {
+	words := suggestionWords(description)
+	if len(words) == 0 {
+		return 0
+	}
+
+	if id, ok := s.byDescription[transactionType+"|"+strings.Join(words, " ")]; ok {
+		return id
+	}
+	if id, ok := s.byKeyword[transactionType+"|"+words[0]]; ok {
+		return id
+	}
+
+	text := " " + strings.Join(words, " ") + " "
+	for _, c := range s.categories {
+		name := strings.ToLower(strings.TrimSpace(c.Name))
+		if c.Type == transactionType && len(name) >= 3 && strings.Contains(text, " "+name+" ") {
+			return c.ID
+		}
+	}
+	return 0
+}
+
+// suggestionWords lowercases a description and keeps its significant words,
+// dropping numbers, punctuation, very short words and bank boilerplate.
+func suggestionWords(description string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(description), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+
+	var words []string
+	for _, f := range fields {
+		if len(f) < 3 || suggestionStopWords[f] {
+			continue
+		}
+		words = append(words, f)
+	}
+	return words
+}
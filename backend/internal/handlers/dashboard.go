@@ -47,6 +47,8 @@ type DashboardSummary struct {
 	ExpenseChangePct   float64                 `json:"expense_change_pct"`
 	MonthlyIncome      float64                 `json:"monthly_income"`
 	MonthlyExpense     float64                 `json:"monthly_expense"`
+	// Recurring occurrences waiting for confirmation, not snoozed
+	PendingRecurring []models.RecurringOccurrence `json:"pending_recurring"`
 }
 
 type CategorySpending struct {
@@ -63,18 +65,22 @@ type BudgetProgress struct {
 	CategoryID   uint    `json:"category_id"`
 	CategoryName string  `json:"category_name"`
 	BudgetAmount float64 `json:"budget_amount"`
-	SpentAmount  float64 `json:"spent_amount"`
-	Remaining    float64 `json:"remaining"`
-	Percentage   float64 `json:"percentage"`
+	// Rolled over from the budget's previous window; EffectiveAmount is
+	// BudgetAmount plus it, what Remaining and Percentage are measured against
+	CarriedIn       float64   `json:"carried_in"`
+	EffectiveAmount float64   `json:"effective_amount"`
+	SpentAmount     float64   `json:"spent_amount"`
+	Remaining       float64   `json:"remaining"`
+	Percentage      float64   `json:"percentage"`
+	Period          string    `json:"period"`
+	PeriodStart     time.Time `json:"period_start"`
+	PeriodEnd       time.Time `json:"period_end"`
 }
 
 func (h *DashboardHandler) GetSummary(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 	period := r.URL.Query().Get("period") // daily, weekly, monthly, yearly
 
-	// Check and process recurring transactions
-	h.processRecurringTransactions(userID)
-
 	now := time.Now()
 	var start, end time.Time
 
@@ -186,23 +192,35 @@ func (h *DashboardHandler) GetSummary(w http.ResponseWriter, r *http.Request) {
 	// Get total balance (Sum of all wallets)
 	totalBalance, _ := h.walletRepo.GetTotalBalance(userID)
 
-	// Get budget progress
-	budgets, _ := h.budgetRepo.GetBudgetsWithSpending(userID, start, end)
+	// Get budget progress, each budget over its own current window rather
+	// than the selected period
+	budgets, _ := h.budgetRepo.GetBudgetsWithSpending(userID, now)
 	var budgetProgress []BudgetProgress
 	for _, b := range budgets {
 		budgetProgress = append(budgetProgress, BudgetProgress{
-			CategoryID:   b.CategoryID,
-			CategoryName: b.Category.Name,
-			BudgetAmount: b.Amount,
-			SpentAmount:  b.Spent,
-			Remaining:    b.Remaining,
-			Percentage:   b.Percentage,
+			CategoryID:      b.CategoryID,
+			CategoryName:    b.Category.Name,
+			BudgetAmount:    b.Amount,
+			CarriedIn:       b.CarriedIn,
+			EffectiveAmount: b.EffectiveAmount,
+			SpentAmount:     b.Spent,
+			Remaining:       b.Remaining,
+			Percentage:      b.Percentage,
+			Period:          b.Period,
+			PeriodStart:     b.PeriodStart,
+			PeriodEnd:       b.PeriodEnd,
 		})
 	}
 
 	// Get Daily Trends
 	trends, _ := h.transactionRepo.GetDailyTrends(userID, start, end)
 
+	// Recurring items waiting for the user to confirm them
+	pendingRecurring, _ := h.recurringRepo.FindQueuedOccurrences(userID, "pending", false, now)
+	if pendingRecurring == nil {
+		pendingRecurring = []models.RecurringOccurrence{}
+	}
+
 	// Convert transactions to interface{}
 	var recentTx []interface{}
 	for _, tx := range transactions {
@@ -222,54 +240,9 @@ func (h *DashboardHandler) GetSummary(w http.ResponseWriter, r *http.Request) {
 		ExpenseChangePct:   expensePct,
 		MonthlyIncome:      monthlySummary.TotalIncome,
 		MonthlyExpense:     monthlySummary.TotalExpense,
+		PendingRecurring:   pendingRecurring,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(dashboardSummary)
 }
-
-func (h *DashboardHandler) processRecurringTransactions(userID uint) {
-	now := time.Now()
-	pending, err := h.recurringRepo.FindPending(userID, now)
-	if err != nil {
-		return
-	}
-
-	for _, item := range pending {
-		// Create real transaction
-		tx := &models.Transaction{
-			UserID:      item.UserID,
-			WalletID:    item.WalletID,
-			CategoryID:  item.CategoryID,
-			Amount:      item.Amount,
-			Type:        item.Type,
-			Description: item.Description + " (Otomatis)",
-			Date:        time.Now(),
-		}
-
-		if err := h.transactionRepo.Create(tx); err != nil {
-			continue
-		}
-
-		// Update Wallet Balance
-		h.walletRepo.UpdateBalance(item.WalletID, item.Amount, item.Type == "income")
-
-		// Update Next Run Date
-		nextRun := item.NextRunDate
-		switch item.Frequency {
-		case "daily":
-			nextRun = nextRun.AddDate(0, 0, 1)
-		case "weekly":
-			nextRun = nextRun.AddDate(0, 0, 7)
-		case "monthly":
-			nextRun = nextRun.AddDate(0, 1, 0)
-		case "yearly":
-			nextRun = nextRun.AddDate(1, 0, 0)
-		}
-
-		// Update item
-		item.LastRunDate = &now
-		item.NextRunDate = nextRun
-		h.recurringRepo.Update(&item)
-	}
-}
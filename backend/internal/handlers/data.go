@@ -1,13 +1,15 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
-	"time"
 
 	"github.com/money-management/backend/internal/models"
 	"github.com/money-management/backend/internal/repository"
 	"github.com/money-management/backend/pkg/middleware"
+	"github.com/money-management/backend/pkg/snapshot"
 )
 
 type DataHandler struct {
@@ -16,6 +18,9 @@ type DataHandler struct {
 	walletRepo      *repository.WalletRepository
 	budgetRepo      *repository.BudgetRepository
 	goalRepo        *repository.GoalRepository
+	backupRepo      *repository.BackupRepository
+	snapshots       *snapshot.Store
+	importDir       string // Uploads of streamed imports
 }
 
 func NewDataHandler(
@@ -24,6 +29,9 @@ func NewDataHandler(
 	walletRepo *repository.WalletRepository,
 	budgetRepo *repository.BudgetRepository,
 	goalRepo *repository.GoalRepository,
+	backupRepo *repository.BackupRepository,
+	snapshots *snapshot.Store,
+	importDir string,
 ) *DataHandler {
 	return &DataHandler{
 		transactionRepo: transactionRepo,
@@ -31,70 +39,50 @@ func NewDataHandler(
 		walletRepo:      walletRepo,
 		budgetRepo:      budgetRepo,
 		goalRepo:        goalRepo,
+		backupRepo:      backupRepo,
+		snapshots:       snapshots,
+		importDir:       importDir,
 	}
 }
 
-type BackupData struct {
-	ExportDate   time.Time            `json:"export_date"`
-	Wallets      []models.Wallet      `json:"wallets"`
-	Categories   []models.Category    `json:"categories"`
-	Transactions []models.Transaction `json:"transactions"`
-	Goals        []models.Goal        `json:"goals"`
-	Budgets      []models.Budget      `json:"budgets"`
-}
-
+// Export downloads a complete backup of the user's data in the current
+// backup format (see models.BackupVersion). With an X-Backup-Passphrase
+// header the file is encrypted with that passphrase.
 func (h *DataHandler) Export(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 
-	// Fetch all data
-	// Using list methods with large limits or simple finds
-	// Repos might need "FindAllByUserID" without limits
-
-	// Wallets
-	wallets, err := h.walletRepo.FindByUserID(userID)
-	if err != nil {
-		wallets = []models.Wallet{}
-	}
-
-	// Categories
-	categories, err := h.categoryRepo.FindByUserID(userID)
+	backup, err := h.backupRepo.Export(userID)
 	if err != nil {
-		categories = []models.Category{}
-	}
-
-	// Transactions (Fetch all? might be heavy. Let's do recent 10000 or implement FindAll)
-	// Reusing FindByUserID with large limit
-	transactions, _, err := h.transactionRepo.FindByUserID(userID, 10000, 0)
-	if err != nil {
-		transactions = []models.Transaction{}
+		http.Error(w, "Error exporting data", http.StatusInternalServerError)
+		return
 	}
 
-	// Goals
-	goals, err := h.goalRepo.FindByUserID(userID)
-	if err != nil {
-		goals = []models.Goal{}
+	filename := "money-management-backup.json"
+	if r.Header.Get(backupPassphraseHeader) != "" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		filename += ".enc"
+	} else {
+		w.Header().Set("Content-Type", "application/json")
 	}
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
 
-	// Budgets
-	budgets, err := h.budgetRepo.FindByUserID(userID)
+	out, finish, err := encryptedExport(w, r)
 	if err != nil {
-		budgets = []models.Budget{}
-	}
-
-	data := BackupData{
-		ExportDate:   time.Now(),
-		Wallets:      wallets,
-		Categories:   categories,
-		Transactions: transactions,
-		Goals:        goals,
-		Budgets:      budgets,
+		http.Error(w, "Error encrypting backup", http.StatusInternalServerError)
+		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Content-Disposition", "attachment; filename=money-management-backup.json")
-	json.NewEncoder(w).Encode(data)
+	json.NewEncoder(out).Encode(backup)
+	finish()
 }
 
+// Import restores an uploaded backup. Encrypted backups need the passphrase
+// in the X-Backup-Passphrase header. The file is decrypted and verified, its
+// version checked and older backups upgraded before anything is touched.
+//
+// The default mode=merge adds the backup to the existing data, matching
+// records by natural keys. Without confirm=true it only reports what would be
+// created, updated or skipped. mode=replace deletes all data first; it needs
+// confirm_replace=true and saves a snapshot of the current data beforehand.
 func (h *DataHandler) Import(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 
@@ -106,108 +94,87 @@ func (h *DataHandler) Import(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	var data BackupData
-	if err := json.NewDecoder(file).Decode(&data); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+	mode, ok := importMode(w, r)
+	if !ok {
 		return
 	}
 
-	db := repository.GetDB()
-	tx := db.Begin()
-
-	// Generic delete function
-	deleteForUser := func(model interface{}) error {
-		return tx.Where("user_id = ?", userID).Delete(model).Error
-	}
-
-	// 1. Delete existing data (Order matters for FK)
-	// Dependent tables first
-	if err := deleteForUser(&models.GoalItem{}); err != nil {
-		tx.Rollback()
-		http.Error(w, "Error clearing old data", http.StatusInternalServerError)
+	plain, err := openBackupFile(file, r.Header.Get(backupPassphraseHeader))
+	if err != nil {
+		backupFileError(w, err)
 		return
 	}
-	if err := deleteForUser(&models.RecurringTransaction{}); err != nil {
-		tx.Rollback()
-		http.Error(w, "Error clearing old data", http.StatusInternalServerError)
+	// Read everything first, an encrypted file is only known to be intact at its end
+	data, err := io.ReadAll(plain)
+	if err != nil {
+		backupFileError(w, err)
 		return
 	}
-	if err := deleteForUser(&models.Transaction{}); err != nil {
-		tx.Rollback()
-		http.Error(w, "Error clearing old data", http.StatusInternalServerError)
+
+	backup, err := decodeBackup(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := deleteForUser(&models.Budget{}); err != nil {
-		tx.Rollback()
-		http.Error(w, "Error clearing old data", http.StatusInternalServerError)
-		return
+	h.restoreBackup(w, r, userID, backup, mode)
+}
+
+// importMode reads the mode of an import: merge (default) or replace, which
+// must be confirmed with confirm_replace=true
+func importMode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	mode := r.FormValue("mode")
+	if mode == "" {
+		mode = "merge"
 	}
-	if err := deleteForUser(&models.Goal{}); err != nil {
-		tx.Rollback()
-		http.Error(w, "Error clearing old data", http.StatusInternalServerError)
-		return
+	if mode != "merge" && mode != "replace" {
+		http.Error(w, "Invalid mode, use merge or replace", http.StatusBadRequest)
+		return "", false
 	}
-	// Independent tables
-	if err := deleteForUser(&models.Wallet{}); err != nil {
-		tx.Rollback()
-		http.Error(w, "Error clearing old data", http.StatusInternalServerError)
-		return
+	if mode == "replace" && r.FormValue("confirm_replace") != "true" {
+		http.Error(w, "Replace deletes all existing data, set confirm_replace=true to continue", http.StatusBadRequest)
+		return "", false
 	}
-	if err := deleteForUser(&models.Category{}); err != nil {
-		tx.Rollback()
-		http.Error(w, "Error clearing old data", http.StatusInternalServerError)
+	return mode, true
+}
+
+// restoreBackup validates a decoded backup and merges it or replaces the
+// user's data with it, taking a snapshot first when replacing
+func (h *DataHandler) restoreBackup(w http.ResponseWriter, r *http.Request, userID uint, backup *models.Backup, mode string) {
+	if err := repository.ValidateBackup(backup); err != nil {
+		http.Error(w, "Invalid backup: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// 2. Insert new data (Order matters for FK)
-	// Independent tables first
-	for _, walletItem := range data.Wallets {
-		walletItem.UserID = userID // Force UserID security
-		if err := tx.Create(&walletItem).Error; err != nil {
-			tx.Rollback()
-			http.Error(w, "Error restoring wallets", http.StatusInternalServerError)
-			return
-		}
-	}
-	for _, c := range data.Categories {
-		c.UserID = userID
-		if err := tx.Create(&c).Error; err != nil {
-			tx.Rollback()
-			http.Error(w, "Error restoring categories", http.StatusInternalServerError)
-			return
-		}
-	}
-	for _, b := range data.Budgets {
-		b.UserID = userID
-		if err := tx.Create(&b).Error; err != nil {
-			tx.Rollback()
-			http.Error(w, "Error restoring budgets", http.StatusInternalServerError)
+	if mode == "merge" {
+		report, err := h.backupRepo.Merge(userID, backup, r.FormValue("confirm") == "true")
+		if err != nil {
+			http.Error(w, "Error merging data", http.StatusInternalServerError)
 			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+		return
 	}
-	for _, g := range data.Goals {
-		g.UserID = userID
-		if err := tx.Create(&g).Error; err != nil {
-			tx.Rollback()
-			http.Error(w, "Error restoring goals", http.StatusInternalServerError)
-			return
-		}
+
+	current, err := h.backupRepo.Export(userID)
+	if err != nil {
+		http.Error(w, "Error creating pre-import snapshot", http.StatusInternalServerError)
+		return
 	}
-	// Transactions must come after Wallets and Categories because of FK
-	for _, t := range data.Transactions {
-		t.UserID = userID
-		if err := tx.Create(&t).Error; err != nil {
-			tx.Rollback()
-			http.Error(w, "Error restoring transactions", http.StatusInternalServerError)
-			return
-		}
+	snap, err := h.snapshots.Save(userID, "pre-import", current)
+	if err != nil {
+		http.Error(w, "Error creating pre-import snapshot", http.StatusInternalServerError)
+		return
 	}
 
-	if err := tx.Commit().Error; err != nil {
-		http.Error(w, "Error committing transaction", http.StatusInternalServerError)
+	if err := h.backupRepo.Replace(userID, backup); err != nil {
+		http.Error(w, "Error restoring data", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Data restored successfully"})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Data restored successfully",
+		"snapshot": snap,
+	})
 }
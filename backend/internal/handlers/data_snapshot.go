@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/internal/repository"
+	"github.com/money-management/backend/pkg/middleware"
+)
+
+// ListSnapshots lists the user's snapshots, scheduled backups as well as the
+// ones taken before imports, newest first
+func (h *DataHandler) ListSnapshots(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	snapshots, err := h.snapshots.List(userID)
+	if err != nil {
+		http.Error(w, "Error fetching snapshots", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshots)
+}
+
+// RestoreSnapshot restores one of the user's snapshots like an uploaded
+// backup: mode=merge (default) reports the changes and applies them with
+// confirm=true, mode=replace needs confirm_replace=true and snapshots the
+// current data first.
+func (h *DataHandler) RestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	name := chi.URLParam(r, "name")
+
+	mode, ok := importMode(w, r)
+	if !ok {
+		return
+	}
+
+	file, err := h.snapshots.Open(userID, name)
+	if os.IsNotExist(err) {
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error reading snapshot", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	plain, err := openBackupFile(file, r.Header.Get(backupPassphraseHeader))
+	if err != nil {
+		backupFileError(w, err)
+		return
+	}
+
+	var backup *models.Backup
+	if strings.HasSuffix(name, ".ndjson") {
+		backup, err = repository.DecodeBackupStream(plain)
+	} else {
+		var data []byte
+		if data, err = io.ReadAll(plain); err == nil {
+			backup, err = decodeBackup(bytes.NewReader(data))
+		}
+	}
+	if err != nil {
+		backupFileError(w, err)
+		return
+	}
+	h.restoreBackup(w, r, userID, backup, mode)
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/internal/repository"
+	"github.com/money-management/backend/pkg/middleware"
+)
+
+// importBatchSize is how many records a streamed import commits at once
+const importBatchSize = 500
+
+// ExportStream downloads the backup as NDJSON, one record per line. Unlike
+// Export it is written while the data is read, for histories too large to
+// hold in memory. A failure halfway leaves the file without its end record,
+// which the importer rejects. It is encrypted like Export when a passphrase
+// is given.
+func (h *DataHandler) ExportStream(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	filename := "money-management-backup.ndjson"
+	if r.Header.Get(backupPassphraseHeader) != "" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		filename += ".enc"
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+	buf := bufio.NewWriter(w)
+	out, finish, err := encryptedExport(buf, r)
+	if err != nil {
+		http.Error(w, "Error encrypting backup", http.StatusInternalServerError)
+		return
+	}
+	if err := h.backupRepo.ExportStream(userID, out); err != nil {
+		// Without the final chunk or end record the file won't import
+		log.Printf("Streamed export for user %d failed: %v", userID, err)
+		buf.Flush()
+		return
+	}
+	finish()
+	buf.Flush()
+}
+
+// ImportStream replaces the user's data with a streamed (NDJSON) backup.
+// The whole file is validated before anything is touched, then restored in
+// the background in batches. Like the replace mode of Import it needs
+// confirm_replace=true and snapshots the current data first. The response is
+// the import job, whose progress is polled with ImportJobStatus.
+func (h *DataHandler) ImportStream(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	if r.FormValue("confirm_replace") != "true" {
+		http.Error(w, "Import replaces all existing data, set confirm_replace=true to continue", http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Invalid file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	active, err := h.backupRepo.HasActiveImportJob(userID)
+	if err != nil {
+		http.Error(w, "Error checking import jobs", http.StatusInternalServerError)
+		return
+	}
+	if active {
+		http.Error(w, "Another import is still running", http.StatusConflict)
+		return
+	}
+
+	// Keep the upload on disk, a resumed job reads it again
+	dir := filepath.Join(h.importDir, fmt.Sprint(userID))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		http.Error(w, "Error saving file", http.StatusInternalServerError)
+		return
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%d.ndjson", time.Now().UnixNano()))
+	dst, err := os.Create(path)
+	if err != nil {
+		http.Error(w, "Error saving file", http.StatusInternalServerError)
+		return
+	}
+	_, err = io.Copy(dst, file)
+	dst.Close()
+	if err != nil {
+		os.Remove(path)
+		http.Error(w, "Error saving file", http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := os.Open(path)
+	if err != nil {
+		os.Remove(path)
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	// Encrypted uploads stay encrypted on disk, the job decrypts while reading
+	passphrase := r.Header.Get(backupPassphraseHeader)
+	total := 0
+	plain, err := openBackupFile(saved, passphrase)
+	if err == nil {
+		total, err = repository.ValidateBackupStream(plain)
+	}
+	saved.Close()
+	if err != nil {
+		os.Remove(path)
+		backupFileError(w, err)
+		return
+	}
+
+	snap, err := h.snapshots.SaveFunc(userID, "pre-import", "ndjson", func(w io.Writer) error {
+		return h.backupRepo.ExportStream(userID, w)
+	})
+	if err != nil {
+		os.Remove(path)
+		http.Error(w, "Error creating pre-import snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	job := &models.ImportJob{
+		UserID:       userID,
+		Status:       "pending",
+		FileName:     header.Filename,
+		FilePath:     path,
+		TotalRecords: total,
+		Snapshot:     snap.Name,
+	}
+	if err := h.backupRepo.CreateImportJob(job); err != nil {
+		os.Remove(path)
+		http.Error(w, "Error creating import job", http.StatusInternalServerError)
+		return
+	}
+
+	response := importJobResponse(job)
+	go h.runImportJob(job, passphrase)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+// ImportJobStatus reports the progress of a streamed import
+func (h *DataHandler) ImportJobStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.findImportJob(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(importJobResponse(job))
+}
+
+// ResumeImportJob continues a failed streamed import after its last
+// committed batch. Encrypted uploads need the passphrase again.
+func (h *DataHandler) ResumeImportJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.findImportJob(w, r)
+	if !ok {
+		return
+	}
+	file, err := os.Open(job.FilePath)
+	if err != nil {
+		http.Error(w, "Import file is no longer available", http.StatusGone)
+		return
+	}
+	// Check the passphrase of encrypted uploads before claiming the job
+	passphrase := r.Header.Get(backupPassphraseHeader)
+	_, err = openBackupFile(file, passphrase)
+	file.Close()
+	if err != nil {
+		backupFileError(w, err)
+		return
+	}
+
+	// Claiming the job makes sure only one request resumes it
+	claimed, err := h.backupRepo.ClaimFailedImportJob(job)
+	if err != nil {
+		http.Error(w, "Error resuming import", http.StatusInternalServerError)
+		return
+	}
+	if !claimed {
+		http.Error(w, "Only failed imports can be resumed", http.StatusBadRequest)
+		return
+	}
+	response := importJobResponse(job)
+	go h.runImportJob(job, passphrase)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *DataHandler) findImportJob(w http.ResponseWriter, r *http.Request) (*models.ImportJob, bool) {
+	userID := middleware.GetUserID(r)
+
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid import job ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	job, err := h.backupRepo.FindImportJob(uint(id))
+	if err != nil {
+		http.Error(w, "Import job not found", http.StatusNotFound)
+		return nil, false
+	}
+	if job.UserID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return job, true
+}
+
+func (h *DataHandler) runImportJob(job *models.ImportJob, passphrase string) {
+	file, err := os.Open(job.FilePath)
+	if err != nil {
+		log.Printf("Import job %d: %v", job.ID, err)
+		h.backupRepo.FailImportJob(job, err)
+		return
+	}
+	defer file.Close()
+
+	plain, err := openBackupFile(file, passphrase)
+	if err != nil {
+		log.Printf("Import job %d: %v", job.ID, err)
+		h.backupRepo.FailImportJob(job, err)
+		return
+	}
+
+	if err := h.backupRepo.RunImportJob(job, plain, importBatchSize); err != nil {
+		log.Printf("Import job %d failed after %d records: %v", job.ID, job.ProcessedRecords, err)
+		return
+	}
+	os.Remove(job.FilePath)
+}
+
+type ImportJobResponse struct {
+	*models.ImportJob
+	Progress float64 `json:"progress"` // Percent of records committed
+}
+
+// importJobResponse copies the job, the running import keeps changing it
+func importJobResponse(job *models.ImportJob) ImportJobResponse {
+	copied := *job
+	job = &copied
+	progress := 100.0
+	if job.TotalRecords > 0 {
+		progress = float64(job.ProcessedRecords) / float64(job.TotalRecords) * 100
+	}
+	return ImportJobResponse{ImportJob: job, Progress: progress}
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/money-management/backend/internal/repository"
+	"github.com/money-management/backend/pkg/middleware"
+)
+
+type EnvelopeHandler struct {
+	envelopeRepo *repository.EnvelopeRepository
+	budgetRepo   *repository.BudgetRepository
+}
+
+func NewEnvelopeHandler(envelopeRepo *repository.EnvelopeRepository, budgetRepo *repository.BudgetRepository) *EnvelopeHandler {
+	return &EnvelopeHandler{envelopeRepo: envelopeRepo, budgetRepo: budgetRepo}
+}
+
+type AssignEnvelopeRequest struct {
+	Month    string  `json:"month"` // YYYY-MM, defaults to the current month
+	BudgetID uint    `json:"budget_id"`
+	Amount   float64 `json:"amount"` // Negative gives money back to the pool
+}
+
+type MoveEnvelopeRequest struct {
+	Month        string  `json:"month"`          // YYYY-MM, defaults to the current month
+	FromBudgetID *uint   `json:"from_budget_id"` // Empty takes from the pool
+	ToBudgetID   *uint   `json:"to_budget_id"`   // Empty gives back to the pool
+	Amount       float64 `json:"amount"`
+	Note         string  `json:"note"`
+}
+
+type RolloverEnvelopeRequest struct {
+	Month string `json:"month"` // YYYY-MM, defaults to last month
+}
+
+// GetMonth returns the to-be-budgeted pool and the envelopes of a month,
+// ?month=YYYY-MM, the current one by default
+func (h *EnvelopeHandler) GetMonth(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	month, err := parseEnvelopeMonth(r.URL.Query().Get("month"), time.Now())
+	if err != nil {
+		http.Error(w, "Invalid month, use YYYY-MM", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.envelopeRepo.GetMonth(userID, month)
+	if err != nil {
+		http.Error(w, "Error fetching envelopes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// Assign puts money from the to-be-budgeted pool into an envelope, or takes
+// it back with a negative amount
+func (h *EnvelopeHandler) Assign(w http.ResponseWriter, r *http.Request) {
+	var req AssignEnvelopeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Amount == 0 {
+		http.Error(w, "Amount can't be zero", http.StatusBadRequest)
+		return
+	}
+
+	move := MoveEnvelopeRequest{Month: req.Month, ToBudgetID: &req.BudgetID, Amount: req.Amount}
+	if req.Amount < 0 {
+		move = MoveEnvelopeRequest{Month: req.Month, FromBudgetID: &req.BudgetID, Amount: -req.Amount}
+	}
+	h.move(w, r, move)
+}
+
+// Move moves money between envelopes, or between an envelope and the pool,
+// and records it
+func (h *EnvelopeHandler) Move(w http.ResponseWriter, r *http.Request) {
+	var req MoveEnvelopeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Amount <= 0 {
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.FromBudgetID == nil && req.ToBudgetID == nil {
+		http.Error(w, "Move needs an envelope to take from or give to", http.StatusBadRequest)
+		return
+	}
+	if req.FromBudgetID != nil && req.ToBudgetID != nil && *req.FromBudgetID == *req.ToBudgetID {
+		http.Error(w, "Source and target envelopes must be different", http.StatusBadRequest)
+		return
+	}
+	h.move(w, r, req)
+}
+
+func (h *EnvelopeHandler) move(w http.ResponseWriter, r *http.Request, req MoveEnvelopeRequest) {
+	userID := middleware.GetUserID(r)
+
+	month, err := parseEnvelopeMonth(req.Month, time.Now())
+	if err != nil {
+		http.Error(w, "Invalid month, use YYYY-MM", http.StatusBadRequest)
+		return
+	}
+	for _, id := range []*uint{req.FromBudgetID, req.ToBudgetID} {
+		if id == nil {
+			continue
+		}
+		budget, err := h.budgetRepo.FindByID(*id)
+		if err != nil || budget.UserID != userID || budget.Mode != "envelope" {
+			http.Error(w, "Envelope not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	move, err := h.envelopeRepo.Move(userID, month, req.FromBudgetID, req.ToBudgetID, req.Amount, req.Note)
+	switch {
+	case errors.Is(err, repository.ErrEnvelopeMonthClosed):
+		http.Error(w, "Month is already rolled over", http.StatusConflict)
+		return
+	case errors.Is(err, repository.ErrEnvelopeInsufficient):
+		http.Error(w, "Envelope doesn't have that much available", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Error moving money", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(move)
+}
+
+// ListMoves returns the money assigned and moved in a month, newest first
+func (h *EnvelopeHandler) ListMoves(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	month, err := parseEnvelopeMonth(r.URL.Query().Get("month"), time.Now())
+	if err != nil {
+		http.Error(w, "Invalid month, use YYYY-MM", http.StatusBadRequest)
+		return
+	}
+
+	moves, err := h.envelopeRepo.FindMoves(userID, month)
+	if err != nil {
+		http.Error(w, "Error fetching moves", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(moves)
+}
+
+// Rollover closes a month that has ended, last month by default, fixing
+// what its envelopes and pool carry into the next one
+func (h *EnvelopeHandler) Rollover(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	var req RolloverEnvelopeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now()
+	month, err := parseEnvelopeMonth(req.Month, repository.MonthStart(now).AddDate(0, -1, 0))
+	if err != nil {
+		http.Error(w, "Invalid month, use YYYY-MM", http.StatusBadRequest)
+		return
+	}
+	if !month.Before(repository.MonthStart(now)) {
+		http.Error(w, "Only months that have ended can be rolled over", http.StatusBadRequest)
+		return
+	}
+
+	closed, err := h.envelopeRepo.Rollover(userID, month)
+	if errors.Is(err, repository.ErrEnvelopeMonthClosed) {
+		http.Error(w, "Month is already rolled over", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error rolling over month", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(closed)
+}
+
+// parseEnvelopeMonth reads a YYYY-MM month, fallback when empty
+func parseEnvelopeMonth(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return repository.MonthStart(fallback), nil
+	}
+	return time.ParseInLocation("2006-01", value, time.Local)
+}
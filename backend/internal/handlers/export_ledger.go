@@ -0,0 +1,472 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/pkg/middleware"
+)
+
+// ledgerCurrency is the currency wallets are kept in
+const ledgerCurrency = "IDR"
+
+// liabilityWalletWords mark wallets that hold debt rather than money
+var liabilityWalletWords = []string{"kartu kredit", "credit card", "kredit", "credit", "paylater", "pay later", "pinjaman", "loan"}
+
+type ledgerPosting struct {
+	Account  string
+	Cents    int64  // In Currency
+	Currency string // Empty for IDR
+
+	// Price annotation for foreign currency postings: Rate per unit (@) when
+	// it reproduces the IDR amount, otherwise TotalCents in IDR (@@)
+	Rate       float64
+	TotalCents int64
+}
+
+type ledgerEntry struct {
+	Date      time.Time
+	Narration string
+	Notes     string
+	Postings  []ledgerPosting
+}
+
+// ExportLedger writes the user's transactions as a plain-text accounting
+// journal. Query: format (beancount, hledger or ledger), start_date,
+// end_date (YYYY-MM-DD) and liabilities, a comma-separated list of wallet IDs
+// to book as liabilities in addition to credit card and paylater wallets,
+// which are recognised by name.
+//
+// Wallets become Assets or Liabilities accounts, categories Income or
+// Expenses accounts. Both halves of a transfer are joined into one entry.
+// Every wallet starts with an opening balance from Equity so the closing
+// balances match the app.
+func (h *DataHandler) ExportLedger(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "beancount"
+	}
+	if format != "beancount" && format != "hledger" && format != "ledger" {
+		http.Error(w, "Invalid format, use beancount, hledger or ledger", http.StatusBadRequest)
+		return
+	}
+
+	var startDate, endDate *time.Time
+	if s := r.URL.Query().Get("start_date"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			http.Error(w, "Invalid start_date", http.StatusBadRequest)
+			return
+		}
+		startDate = &t
+	}
+	if e := r.URL.Query().Get("end_date"); e != "" {
+		t, err := time.Parse("2006-01-02", e)
+		if err != nil {
+			http.Error(w, "Invalid end_date", http.StatusBadRequest)
+			return
+		}
+		// Set to end of day
+		t = t.Add(23*time.Hour + 59*time.Minute + 59*time.Second)
+		endDate = &t
+	}
+
+	liabilities := make(map[uint]bool)
+	if l := r.URL.Query().Get("liabilities"); l != "" {
+		for _, part := range strings.Split(l, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 32)
+			if err != nil {
+				http.Error(w, "Invalid liabilities", http.StatusBadRequest)
+				return
+			}
+			liabilities[uint(id)] = true
+		}
+	}
+
+	wallets, err := h.walletRepo.FindByUserIDWithDeleted(userID)
+	if err != nil {
+		http.Error(w, "Error fetching wallets", http.StatusInternalServerError)
+		return
+	}
+	// Transactions after end_date are needed too, to work back from the
+	// current wallet balances to the opening balances
+	transactions, err := h.transactionRepo.FindByUserIDSince(userID, startDate)
+	if err != nil {
+		http.Error(w, "Error fetching transactions", http.StatusInternalServerError)
+		return
+	}
+
+	accounts := newLedgerAccounts()
+	walletAccounts := make(map[uint]string)
+	walletsByName := make(map[string]uint)
+	for _, wallet := range wallets {
+		root := "Assets"
+		if liabilities[wallet.ID] || isLiabilityWallet(wallet.Name) {
+			root = "Liabilities"
+		}
+		walletAccounts[wallet.ID] = accounts.name(root+":Wallet", wallet.Name, wallet.ID)
+		walletsByName[strings.ToLower(wallet.Name)] = wallet.ID
+	}
+
+	// Opening balance = current balance minus everything since the start
+	opening := make(map[uint]int64)
+	for _, wallet := range wallets {
+		opening[wallet.ID] = toCents(wallet.Balance)
+	}
+	var exported []models.Transaction
+	for _, t := range transactions {
+		if _, ok := walletAccounts[t.WalletID]; !ok {
+			walletAccounts[t.WalletID] = accounts.name("Assets:Wallet", "", t.WalletID)
+		}
+		opening[t.WalletID] -= signedCents(t)
+		if endDate == nil || !t.Date.After(*endDate) {
+			exported = append(exported, t)
+		}
+	}
+
+	openDate := time.Now()
+	if startDate != nil {
+		openDate = *startDate
+	} else if len(exported) > 0 {
+		openDate = exported[0].Date
+	}
+	openDate = ledgerDay(openDate)
+
+	var entries []ledgerEntry
+	openingEntry := ledgerEntry{Date: openDate, Narration: "Opening balances"}
+	var openingTotal int64
+	for _, wallet := range wallets {
+		if opening[wallet.ID] == 0 {
+			continue
+		}
+		openingEntry.Postings = append(openingEntry.Postings, ledgerPosting{Account: walletAccounts[wallet.ID], Cents: opening[wallet.ID]})
+		openingTotal += opening[wallet.ID]
+	}
+	if len(openingEntry.Postings) > 0 {
+		openingEntry.Postings = append(openingEntry.Postings, ledgerPosting{Account: accounts.add("Equity:Opening-Balances"), Cents: -openingTotal})
+		entries = append(entries, openingEntry)
+	}
+
+	paired := pairLedgerTransfers(exported, walletsByName)
+	for i, t := range exported {
+		if other, ok := paired[i]; ok {
+			if other < i {
+				continue // Written with its other half
+			}
+			from, to := t, exported[other]
+			if from.Type != "expense" {
+				from, to = to, from
+			}
+			entries = append(entries, ledgerEntry{
+				Date:      ledgerDay(from.Date),
+				Narration: from.Description,
+				Notes:     from.Notes,
+				Postings: []ledgerPosting{
+					{Account: walletAccounts[to.WalletID], Cents: toCents(to.Amount)},
+					{Account: walletAccounts[from.WalletID], Cents: -toCents(from.Amount)},
+				},
+			})
+			continue
+		}
+		entries = append(entries, ledgerTransactionEntry(t, walletAccounts[t.WalletID], accounts))
+	}
+
+	// Closing balances for beancount's balance assertions
+	closing := make(map[uint]int64)
+	for id, cents := range opening {
+		closing[id] = cents
+	}
+	for _, t := range exported {
+		closing[t.WalletID] += signedCents(t)
+	}
+	closeDate := openDate
+	if len(exported) > 0 {
+		closeDate = ledgerDay(exported[len(exported)-1].Date)
+	}
+	if endDate != nil {
+		closeDate = ledgerDay(*endDate)
+	}
+	closeDate = closeDate.AddDate(0, 0, 1) // Balances are checked at the start of the day
+
+	ext := "beancount"
+	if format != "beancount" {
+		ext = "journal"
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=money-management."+ext)
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	if format == "beancount" {
+		out.WriteString("option \"title\" \"Money Management\"\n")
+		out.WriteString("option \"operating_currency\" \"" + ledgerCurrency + "\"\n\n")
+		for _, account := range accounts.sorted() {
+			fmt.Fprintf(out, "%s open %s\n", openDate.Format("2006-01-02"), account)
+		}
+		out.WriteString("\n")
+		for _, e := range entries {
+			writeBeancountEntry(out, e)
+		}
+		for _, wallet := range wallets {
+			fmt.Fprintf(out, "%s balance %s %s %s\n", closeDate.Format("2006-01-02"), walletAccounts[wallet.ID], formatCents(closing[wallet.ID]), ledgerCurrency)
+		}
+		return
+	}
+
+	for _, account := range accounts.sorted() {
+		fmt.Fprintf(out, "account %s\n", account)
+	}
+	out.WriteString("\n")
+	for _, e := range entries {
+		writeHledgerEntry(out, e)
+	}
+}
+
+// ledgerTransactionEntry books a transaction between its wallet and its
+// category account. Transfers without their other half go to Equity.
+func ledgerTransactionEntry(t models.Transaction, walletAccount string, accounts *ledgerAccounts) ledgerEntry {
+	var account string
+	switch {
+	case isTransferCategory(t.Category.Name):
+		account = accounts.add("Equity:Transfers")
+	case t.Category.Type == "income":
+		account = accounts.name("Income", t.Category.Name, t.CategoryID)
+	default:
+		account = accounts.name("Expenses", t.Category.Name, t.CategoryID)
+	}
+
+	narration := t.Description
+	if narration == "" {
+		narration = t.Category.Name
+	}
+
+	cents := signedCents(t)
+	category := ledgerPosting{Account: account, Cents: -cents}
+	if currency := ledgerForeignCurrency(t); currency != "" {
+		foreign := toCents(t.OriginalAmount)
+		if cents > 0 {
+			foreign = -foreign
+		}
+		category = ledgerPosting{Account: account, Cents: foreign, Currency: currency, TotalCents: toCents(t.Amount)}
+		// A unit price is nicer to read, but only when it adds up exactly
+		if t.ExchangeRate > 0 && math.Abs(float64(toCents(t.OriginalAmount))/100*t.ExchangeRate-float64(toCents(t.Amount))/100) < 0.005 {
+			category.Rate = t.ExchangeRate
+		}
+	}
+
+	return ledgerEntry{
+		Date:      ledgerDay(t.Date),
+		Narration: narration,
+		Notes:     t.Notes,
+		Postings:  []ledgerPosting{category, {Account: walletAccount, Cents: cents}},
+	}
+}
+
+// pairLedgerTransfers finds the two halves of transfers: a Transfer expense
+// and a Transfer income of the same amount on the same day in another
+// wallet. Halves whose description names the other wallet ("Transfer ke X")
+// are preferred. It returns the index of each paired transaction's partner.
+func pairLedgerTransfers(transactions []models.Transaction, walletsByName map[string]uint) map[int]int {
+	type transferKey struct {
+		day   string
+		cents int64
+	}
+	incomes := make(map[transferKey][]int)
+	for i, t := range transactions {
+		if isTransferCategory(t.Category.Name) && t.Type == "income" {
+			key := transferKey{t.Date.Format("2006-01-02"), toCents(t.Amount)}
+			incomes[key] = append(incomes[key], i)
+		}
+	}
+
+	paired := make(map[int]int)
+	for i, t := range transactions {
+		if !isTransferCategory(t.Category.Name) || t.Type != "expense" {
+			continue
+		}
+		key := transferKey{t.Date.Format("2006-01-02"), toCents(t.Amount)}
+		target, named := walletsByName[strings.ToLower(strings.TrimPrefix(t.Description, "Transfer ke "))]
+
+		match := -1
+		for _, j := range incomes[key] {
+			if _, taken := paired[j]; taken || transactions[j].WalletID == t.WalletID {
+				continue
+			}
+			if match < 0 {
+				match = j
+			}
+			if named && transactions[j].WalletID == target {
+				match = j
+				break
+			}
+		}
+		if match >= 0 {
+			paired[i] = match
+			paired[match] = i
+		}
+	}
+	return paired
+}
+
+// ledgerAccounts hands out valid, unique account names and remembers them
+// for the open directives
+type ledgerAccounts struct {
+	byKey map[string]string
+	used  map[string]bool
+}
+
+func newLedgerAccounts() *ledgerAccounts {
+	return &ledgerAccounts{byKey: make(map[string]string), used: make(map[string]bool)}
+}
+
+// name returns the account for a wallet or category below root. Names that
+// clash after cleaning up get the record ID appended.
+func (a *ledgerAccounts) name(root, name string, id uint) string {
+	key := fmt.Sprintf("%s|%d", root, id)
+	if account, ok := a.byKey[key]; ok {
+		return account
+	}
+	component := ledgerComponent(name)
+	if component == "" {
+		component = fmt.Sprintf("Unnamed-%d", id)
+	}
+	account := root + ":" + component
+	if a.used[account] {
+		account = fmt.Sprintf("%s-%d", account, id)
+	}
+	a.byKey[key] = account
+	return a.add(account)
+}
+
+func (a *ledgerAccounts) add(account string) string {
+	a.used[account] = true
+	return account
+}
+
+func (a *ledgerAccounts) sorted() []string {
+	accounts := make([]string, 0, len(a.used))
+	for account := range a.used {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+// ledgerComponent turns a name into an account name component: ASCII
+// letters and digits, words capitalised and joined with dashes, as beancount
+// requires
+func ledgerComponent(name string) string {
+	var words []string
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool {
+		return r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r))
+	}) {
+		words = append(words, strings.ToUpper(word[:1])+word[1:])
+	}
+	return strings.Join(words, "-")
+}
+
+func isLiabilityWallet(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range liabilityWalletWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// ledgerForeignCurrency returns the original currency of a converted
+// transaction, or "" for IDR
+func ledgerForeignCurrency(t models.Transaction) string {
+	currency := strings.ToUpper(strings.TrimSpace(t.Currency))
+	if currency == "" || currency == ledgerCurrency || t.OriginalAmount == 0 {
+		return ""
+	}
+	for _, r := range currency {
+		if r < 'A' || r > 'Z' {
+			return ""
+		}
+	}
+	return currency
+}
+
+// signedCents is what a transaction adds to its wallet
+func signedCents(t models.Transaction) int64 {
+	if t.Type == "expense" {
+		return -toCents(t.Amount)
+	}
+	return toCents(t.Amount)
+}
+
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func formatCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+func ledgerDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func (p ledgerPosting) amount() string {
+	currency := p.Currency
+	if currency == "" {
+		currency = ledgerCurrency
+	}
+	s := formatCents(p.Cents) + " " + currency
+	if p.Currency != "" {
+		if p.Rate > 0 {
+			s += " @ " + strconv.FormatFloat(p.Rate, 'f', -1, 64) + " " + ledgerCurrency
+		} else {
+			s += " @@ " + formatCents(p.TotalCents) + " " + ledgerCurrency
+		}
+	}
+	return s
+}
+
+func writeBeancountEntry(out *bufio.Writer, e ledgerEntry) {
+	fmt.Fprintf(out, "%s * %s\n", e.Date.Format("2006-01-02"), beancountString(e.Narration))
+	if notes := qifText(e.Notes); notes != "" {
+		fmt.Fprintf(out, "  notes: %s\n", beancountString(notes))
+	}
+	for _, p := range e.Postings {
+		fmt.Fprintf(out, "  %-40s %s\n", p.Account, p.amount())
+	}
+	out.WriteString("\n")
+}
+
+func writeHledgerEntry(out *bufio.Writer, e ledgerEntry) {
+	// A semicolon would start a comment
+	narration := strings.ReplaceAll(qifText(e.Narration), ";", ",")
+	fmt.Fprintf(out, "%s * %s\n", e.Date.Format("2006-01-02"), narration)
+	if notes := qifText(e.Notes); notes != "" {
+		fmt.Fprintf(out, "    ; %s\n", notes)
+	}
+	for _, p := range e.Postings {
+		fmt.Fprintf(out, "    %-40s  %s\n", p.Account, p.amount())
+	}
+	out.WriteString("\n")
+}
+
+func beancountString(s string) string {
+	s = strings.ReplaceAll(qifText(s), `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/pkg/middleware"
+)
+
+// qifAccountTypes maps the account_type query value to the QIF register type
+var qifAccountTypes = map[string]string{"bank": "Bank", "cash": "Cash", "ccard": "CCard"}
+
+// ExportQIF writes one wallet's transactions as a QIF register, preceded by
+// the category list, for desktop finance tools. Query: wallet_id (required),
+// start_date, end_date (YYYY-MM-DD) and account_type (bank, cash, ccard).
+func (h *DataHandler) ExportQIF(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	walletID, err := strconv.ParseUint(r.URL.Query().Get("wallet_id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid wallet ID", http.StatusBadRequest)
+		return
+	}
+	wallet, err := h.walletRepo.FindByID(uint(walletID))
+	if err != nil {
+		http.Error(w, "Wallet not found", http.StatusNotFound)
+		return
+	}
+	if wallet.UserID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	accountType := qifAccountTypes[strings.ToLower(r.URL.Query().Get("account_type"))]
+	if accountType == "" {
+		accountType = "Bank"
+	}
+
+	var startDate, endDate *time.Time
+	if s := r.URL.Query().Get("start_date"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			http.Error(w, "Invalid start_date", http.StatusBadRequest)
+			return
+		}
+		startDate = &t
+	}
+	if e := r.URL.Query().Get("end_date"); e != "" {
+		t, err := time.Parse("2006-01-02", e)
+		if err != nil {
+			http.Error(w, "Invalid end_date", http.StatusBadRequest)
+			return
+		}
+		// Set to end of day
+		t = t.Add(23*time.Hour + 59*time.Minute + 59*time.Second)
+		endDate = &t
+	}
+
+	transactions, err := h.transactionRepo.FindByWalletAndDateRange(wallet.ID, startDate, endDate)
+	if err != nil {
+		http.Error(w, "Error fetching transactions", http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("%s.qif", qifFilename.ReplaceAllString(strings.ToLower(wallet.Name), "-"))
+	w.Header().Set("Content-Type", "application/qif")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+	writeQIF(out, wallet.Name, accountType, transactions)
+}
+
+var qifFilename = regexp.MustCompile(`[^a-z0-9]+`)
+
+func writeQIF(out *bufio.Writer, accountName, accountType string, transactions []models.Transaction) {
+	// Category list first so importers know income from expense categories
+	seen := make(map[uint]bool)
+	wroteHeader := false
+	for _, t := range transactions {
+		if t.CategoryID == 0 || seen[t.CategoryID] || isTransferCategory(t.Category.Name) {
+			continue
+		}
+		seen[t.CategoryID] = true
+		if !wroteHeader {
+			out.WriteString("!Type:Cat\n")
+			wroteHeader = true
+		}
+		out.WriteString("N" + qifText(t.Category.Name) + "\n")
+		if t.Category.Type == "income" {
+			out.WriteString("I\n")
+		} else {
+			out.WriteString("E\n")
+		}
+		out.WriteString("^\n")
+	}
+
+	out.WriteString("!Account\n")
+	out.WriteString("N" + qifText(accountName) + "\n")
+	out.WriteString("T" + accountType + "\n")
+	out.WriteString("^\n")
+	out.WriteString("!Type:" + accountType + "\n")
+
+	for _, t := range transactions {
+		amount := t.Amount
+		if t.Type == "expense" {
+			amount = -amount
+		}
+
+		out.WriteString("D" + t.Date.Format("01/02/2006") + "\n")
+		out.WriteString("T" + strconv.FormatFloat(amount, 'f', 2, 64) + "\n")
+		if t.Description != "" {
+			out.WriteString("P" + qifText(t.Description) + "\n")
+		}
+		if t.Notes != "" {
+			out.WriteString("M" + qifText(t.Notes) + "\n")
+		}
+		if category := qifCategoryField(t); category != "" {
+			out.WriteString("L" + category + "\n")
+		}
+		out.WriteString("^\n")
+	}
+}
+
+// qifCategoryField writes transfers as "[Wallet]" when the other wallet is
+// known, either from the description the transfer endpoint generates or from
+// the "[Account]" note a QIF import leaves
+func qifCategoryField(t models.Transaction) string {
+	if isTransferCategory(t.Category.Name) {
+		for _, prefix := range []string{"Transfer ke ", "Transfer dari "} {
+			if strings.HasPrefix(t.Description, prefix) {
+				return "[" + qifText(strings.TrimPrefix(t.Description, prefix)) + "]"
+			}
+		}
+		if strings.HasPrefix(t.Notes, "[") {
+			if end := strings.Index(t.Notes, "]"); end > 0 {
+				return qifText(t.Notes[:end+1])
+			}
+		}
+	}
+	return qifText(t.Category.Name)
+}
+
+func isTransferCategory(name string) bool {
+	switch strings.ToLower(name) {
+	case "transfer", "transfer out", "transfer in":
+		return true
+	}
+	return false
+}
+
+// qifText keeps values on one line; QIF has no escaping
+func qifText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/internal/repository"
+)
+
+// maxImportSize limits uploaded statement files
+const maxImportSize = 10 << 20
+
+// transferCategory is used for imported transfers, matching the category
+// created by the wallet transfer endpoint.
+const transferCategory = "Transfer"
+
+type ImportHandler struct {
+	transactionRepo *repository.TransactionRepository
+	walletRepo      *repository.WalletRepository
+	categoryRepo    *repository.CategoryRepository
+	profileRepo     *repository.ImportProfileRepository
+	currencyHandler *CurrencyHandler
+}
+
+func NewImportHandler(
+	transactionRepo *repository.TransactionRepository,
+	walletRepo *repository.WalletRepository,
+	categoryRepo *repository.CategoryRepository,
+	profileRepo *repository.ImportProfileRepository,
+	currencyHandler *CurrencyHandler,
+) *ImportHandler {
+	return &ImportHandler{
+		transactionRepo: transactionRepo,
+		walletRepo:      walletRepo,
+		categoryRepo:    categoryRepo,
+		profileRepo:     profileRepo,
+		currencyHandler: currencyHandler,
+	}
+}
+
+// ImportRow is a single parsed statement line. All import formats produce
+// these so preview and commit work the same way regardless of the source.
+type ImportRow struct {
+	Line         int      `json:"line"`
+	Date         string   `json:"date"` // YYYY-MM-DD
+	Description  string   `json:"description"`
+	Amount       float64  `json:"amount"` // Always positive, direction is in Type
+	Type         string   `json:"type"`   // income, expense
+	CategoryName string   `json:"category_name,omitempty"`
+	CategoryID   uint     `json:"category_id,omitempty"`
+	Suggested    bool     `json:"category_suggested,omitempty"` // CategoryID came from the user's history
+	Notes        string   `json:"notes,omitempty"`
+	Currency     string   `json:"currency"`
+	ExternalID   string   `json:"external_id,omitempty"` // Bank transaction ID (OFX FITID)
+	Account      string   `json:"account,omitempty"`     // Source account, for files covering several wallets
+	Duplicate    bool     `json:"duplicate,omitempty"`   // Already imported, will be skipped
+	Errors       []string `json:"errors,omitempty"`
+
+	date time.Time
+}
+
+func (row *ImportRow) addError(msg string) {
+	row.Errors = append(row.Errors, msg)
+}
+
+func (row *ImportRow) setDate(t time.Time) {
+	row.date = t
+	row.Date = t.Format("2006-01-02")
+}
+
+// ImportCommitResponse reports the outcome of committing an import
+type ImportCommitResponse struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// readImportFile reads the "file" field of a multipart upload
+func readImportFile(r *http.Request) ([]byte, error) {
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		return nil, errors.New("File too large or invalid")
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, errors.New("No file provided")
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxImportSize+1))
+	if err != nil {
+		return nil, errors.New("Error reading file")
+	}
+	if len(data) > maxImportSize {
+		return nil, errors.New("File too large or invalid")
+	}
+	return data, nil
+}
+
+func formUint(r *http.Request, key string) uint {
+	v, _ := strconv.ParseUint(r.FormValue(key), 10, 32)
+	return uint(v)
+}
+
+// resolveWallet returns the target wallet for an import, checking ownership
+func (h *ImportHandler) resolveWallet(userID, walletID uint) (*models.Wallet, error) {
+	if walletID == 0 {
+		return nil, errors.New("wallet_id is required")
+	}
+	wallet, err := h.walletRepo.FindByID(walletID)
+	if err != nil || wallet.UserID != userID {
+		return nil, errors.New("Wallet not found or access denied")
+	}
+	return wallet, nil
+}
+
+// resolveCategories assigns a category to every row: first by matching the
+// imported category name against the user's categories (case-insensitive,
+// same type preferred), then by suggesting one from the user's history, then
+// by falling back to the per-type defaults given in the request, and finally
+// to the first category of the matching type.
+func (h *ImportHandler) resolveCategories(userID uint, rows []ImportRow, defaultExpenseID, defaultIncomeID uint) {
+	categories, _ := h.categoryRepo.FindByUserID(userID)
+	suggester := h.newCategorySuggester(userID, categories)
+
+	byName := make(map[string][]models.Category)
+	firstOfType := make(map[string]uint)
+	owned := make(map[uint]string)
+	for _, c := range categories {
+		key := strings.ToLower(strings.TrimSpace(c.Name))
+		byName[key] = append(byName[key], c)
+		if _, ok := firstOfType[c.Type]; !ok {
+			firstOfType[c.Type] = c.ID
+		}
+		owned[c.ID] = c.Type
+	}
+
+	defaults := map[string]uint{"expense": firstOfType["expense"], "income": firstOfType["income"]}
+	if _, ok := owned[defaultExpenseID]; ok {
+		defaults["expense"] = defaultExpenseID
+	}
+	if _, ok := owned[defaultIncomeID]; ok {
+		defaults["income"] = defaultIncomeID
+	}
+
+	for i := range rows {
+		row := &rows[i]
+		if row.CategoryID != 0 {
+			if _, ok := owned[row.CategoryID]; ok {
+				continue
+			}
+			row.CategoryID = 0
+		}
+
+		if row.CategoryName != "" {
+			matches := byName[strings.ToLower(strings.TrimSpace(row.CategoryName))]
+			for _, c := range matches {
+				if c.Type == row.Type {
+					row.CategoryID = c.ID
+					break
+				}
+			}
+			if row.CategoryID == 0 && len(matches) > 0 {
+				row.CategoryID = matches[0].ID
+			}
+		}
+
+		if row.CategoryID == 0 {
+			if id := suggester.suggest(row.Description, row.Type); id != 0 {
+				row.CategoryID = id
+				row.Suggested = true
+			}
+		}
+
+		if row.CategoryID == 0 {
+			row.CategoryID = defaults[row.Type]
+		}
+		if row.CategoryID == 0 {
+			row.addError("No category available for type " + row.Type)
+		}
+	}
+}
+
+// buildTransactions turns valid rows into transactions for the given wallet,
+// converting foreign-currency amounts to IDR with the current rate.
+func (h *ImportHandler) buildTransactions(userID, walletID uint, rows []ImportRow) ([]models.Transaction, int) {
+	var transactions []models.Transaction
+	skipped := 0
+	for _, row := range rows {
+		if len(row.Errors) > 0 || row.Duplicate {
+			skipped++
+			continue
+		}
+
+		currency := strings.ToUpper(row.Currency)
+		if currency == "" {
+			currency = "IDR"
+		}
+		rate := h.currencyHandler.GetRate(currency)
+
+		transactions = append(transactions, models.Transaction{
+			UserID:         userID,
+			CategoryID:     row.CategoryID,
+			WalletID:       walletID,
+			Amount:         row.Amount * rate,
+			OriginalAmount: row.Amount,
+			Currency:       currency,
+			ExchangeRate:   rate,
+			Type:           row.Type,
+			Description:    row.Description,
+			Date:           row.date,
+			Notes:          row.Notes,
+			ExternalID:     row.ExternalID,
+		})
+	}
+	return transactions, skipped
+}
+
+func countInvalid(rows []ImportRow) int {
+	n := 0
+	for _, row := range rows {
+		if len(row.Errors) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// CategoryMapping describes how an imported category will be imported. The
+// client may change CategoryID and send the result back as category_map.
+type CategoryMapping struct {
+	Name         string `json:"name"`          // As written in the imported file
+	Type         string `json:"type"`          // Dominant transaction type
+	Count        int    `json:"count"`         // Transactions and splits using it
+	CategoryID   uint   `json:"category_id"`   // Existing category, 0 = create on commit
+	CategoryName string `json:"category_name"` // Name of the existing or new category
+	Action       string `json:"action"`        // map, create
+}
+
+// categoryMappings lists every category used by the rows with the user
+// category it maps to: the override if given, otherwise an existing category
+// with the same name (or the same last "Parent:Child" segment), otherwise a
+// new one. knownTypes overrides the type guessed from the rows.
+func categoryMappings(rows []ImportRow, knownTypes map[string]string, categories []models.Category, overrides map[string]uint) []CategoryMapping {
+	type usage struct{ income, expense int }
+	used := make(map[string]*usage)
+	for _, row := range rows {
+		if row.CategoryName == "" {
+			continue
+		}
+		u, ok := used[row.CategoryName]
+		if !ok {
+			u = &usage{}
+			used[row.CategoryName] = u
+		}
+		if row.Type == "income" {
+			u.income++
+		} else {
+			u.expense++
+		}
+	}
+
+	owned := make(map[uint]models.Category)
+	for _, c := range categories {
+		owned[c.ID] = c
+	}
+	find := func(name, categoryType string) *models.Category {
+		var match *models.Category
+		for i, c := range categories {
+			if strings.EqualFold(strings.TrimSpace(c.Name), name) {
+				if c.Type == categoryType {
+					return &categories[i]
+				}
+				if match == nil {
+					match = &categories[i]
+				}
+			}
+		}
+		return match
+	}
+
+	mappings := make([]CategoryMapping, 0, len(used))
+	for name, u := range used {
+		m := CategoryMapping{Name: name, Type: "expense", Count: u.income + u.expense}
+		if u.income > u.expense {
+			m.Type = "income"
+		}
+		if t, ok := knownTypes[name]; ok {
+			m.Type = t
+		}
+
+		if id, ok := overrides[name]; ok {
+			if c, ok := owned[id]; ok {
+				m.CategoryID, m.CategoryName, m.Action = c.ID, c.Name, "map"
+			} else {
+				m.CategoryName, m.Action = name, "create"
+			}
+			mappings = append(mappings, m)
+			continue
+		}
+
+		c := find(name, m.Type)
+		if c == nil {
+			if i := strings.LastIndex(name, ":"); i >= 0 {
+				c = find(strings.TrimSpace(name[i+1:]), m.Type)
+			}
+		}
+		if c != nil {
+			m.CategoryID, m.CategoryName, m.Action = c.ID, c.Name, "map"
+		} else {
+			m.CategoryName, m.Action = name, "create"
+		}
+		mappings = append(mappings, m)
+	}
+
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].Name < mappings[j].Name })
+	return mappings
+}
+
+// createMappedCategories creates the categories marked for creation and
+// returns the category ID for every imported name
+func (h *ImportHandler) createMappedCategories(userID uint, mappings []CategoryMapping) (map[string]uint, []models.Category, error) {
+	created := []models.Category{}
+	categoryIDs := make(map[string]uint)
+	for _, m := range mappings {
+		if m.Action == "create" {
+			// Imported categories start out as wants, the user decides what is essential
+			category := &models.Category{
+				UserID: userID,
+				Name:   m.CategoryName,
+				Type:   m.Type,
+			}
+			if m.Name == transferCategory {
+				category.Icon, category.Color = "🔄", "#808080"
+			}
+			if err := h.categoryRepo.CreateNonEssential(category); err != nil {
+				return nil, nil, errors.New("Error creating category " + m.CategoryName)
+			}
+			created = append(created, *category)
+			m.CategoryID = category.ID
+		}
+		categoryIDs[m.Name] = m.CategoryID
+	}
+	return categoryIDs, created, nil
+}
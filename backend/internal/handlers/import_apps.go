@@ -0,0 +1,729 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/pkg/middleware"
+)
+
+// Apps whose exports can be imported
+const (
+	appMoneyLover   = "moneylover"
+	appSpendee      = "spendee"
+	appBudgetBakers = "budgetbakers" // Wallet by BudgetBakers
+)
+
+// appStatement is a parsed export of another finance app. Every row carries
+// the app's wallet in Account; transfer halves are paired across wallets.
+type appStatement struct {
+	App              string
+	Rows             []ImportRow
+	Counterparts     map[int]string // Row index -> other wallet of a paired transfer
+	DateFormat       string
+	DecimalSeparator string
+}
+
+// appRecord holds the fields of one exported record, whatever the app
+type appRecord struct {
+	ID, Account, Date, Category, Amount, Type, Currency, Note, Payee string
+	TransferAmount                                                   string // Amount both transfer halves share, if the app has one
+	Transfer                                                         bool
+}
+
+// AppWalletMapping describes how a wallet of the other app will be imported.
+// The client may change WalletID and send the result back as account_map.
+type AppWalletMapping struct {
+	Name       string `json:"name"`        // As named in the other app
+	Currency   string `json:"currency"`    // Most used currency
+	Count      int    `json:"count"`       // Transactions in the wallet
+	WalletID   uint   `json:"wallet_id"`   // Existing wallet, 0 = create on commit
+	WalletName string `json:"wallet_name"` // Name of the existing or new wallet
+	Action     string `json:"action"`      // map, create
+}
+
+type AppPreviewResponse struct {
+	App               string             `json:"app"`
+	Wallets           []AppWalletMapping `json:"wallets"`
+	Categories        []CategoryMapping  `json:"categories"`
+	Rows              []ImportRow        `json:"rows"` // First rows of the file
+	TotalRows         int                `json:"total_rows"`
+	InvalidRows       int                `json:"invalid_rows"`
+	DuplicateRows     int                `json:"duplicate_rows"`
+	Transfers         int                `json:"transfers"`          // Paired transfers, two rows each
+	UnpairedTransfers int                `json:"unpaired_transfers"` // Transfer rows without a matching other half
+	DateFormat        string             `json:"date_format"`
+	DecimalSeparator  string             `json:"decimal_separator"`
+}
+
+type AppCommitResponse struct {
+	ImportCommitResponse
+	Duplicates        int               `json:"duplicates"`
+	WalletsCreated    []models.Wallet   `json:"wallets_created"`
+	CategoriesCreated []models.Category `json:"categories_created"`
+}
+
+// PreviewApp parses a CSV or JSON export of Money Lover, Spendee or Wallet
+// by BudgetBakers and proposes how its wallets and categories map onto the
+// user's. The app is detected from the columns unless app is given.
+// Nothing is written.
+func (h *ImportHandler) PreviewApp(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	data, err := readImportFile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stmt, err := parseAppUpload(data, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	accountMap, err := formJSONMap(r, "account_map")
+	if err != nil {
+		http.Error(w, "Invalid account_map", http.StatusBadRequest)
+		return
+	}
+	categoryMap, err := formJSONMap(r, "category_map")
+	if err != nil {
+		http.Error(w, "Invalid category_map", http.StatusBadRequest)
+		return
+	}
+
+	wallets, _ := h.walletRepo.FindByUserID(userID)
+	walletMappings := appWalletMappings(stmt, wallets, accountMap)
+	if err := h.markAppDuplicates(stmt, walletMappings); err != nil {
+		http.Error(w, "Error checking duplicates", http.StatusInternalServerError)
+		return
+	}
+	categories, _ := h.categoryRepo.FindByUserID(userID)
+
+	resp := AppPreviewResponse{
+		App:              stmt.App,
+		Wallets:          walletMappings,
+		Categories:       categoryMappings(stmt.Rows, nil, categories, categoryMap),
+		Rows:             []ImportRow{},
+		TotalRows:        len(stmt.Rows),
+		Transfers:        len(stmt.Counterparts) / 2,
+		DateFormat:       stmt.DateFormat,
+		DecimalSeparator: stmt.DecimalSeparator,
+	}
+	for i, row := range stmt.Rows {
+		if len(resp.Rows) < csvPreviewRows {
+			resp.Rows = append(resp.Rows, row)
+		}
+		switch {
+		case len(row.Errors) > 0:
+			resp.InvalidRows++
+		case row.Duplicate:
+			resp.DuplicateRows++
+		}
+		if _, paired := stmt.Counterparts[i]; row.CategoryName == transferCategory && !paired {
+			resp.UnpairedTransfers++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CommitApp imports an export of another app. account_map ({"app wallet":
+// wallet_id}) and category_map ({"app category": category_id}) override the
+// proposed mapping, 0 creates a new wallet or category. Paired transfers
+// become the same two transactions as a transfer between wallets here.
+func (h *ImportHandler) CommitApp(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	data, err := readImportFile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stmt, err := parseAppUpload(data, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	accountMap, err := formJSONMap(r, "account_map")
+	if err != nil {
+		http.Error(w, "Invalid account_map", http.StatusBadRequest)
+		return
+	}
+	categoryMap, err := formJSONMap(r, "category_map")
+	if err != nil {
+		http.Error(w, "Invalid category_map", http.StatusBadRequest)
+		return
+	}
+
+	// Check every wallet picked by the client before writing anything
+	wallets, _ := h.walletRepo.FindByUserID(userID)
+	walletMappings := appWalletMappings(stmt, wallets, accountMap)
+	for name, id := range accountMap {
+		if id == 0 {
+			continue
+		}
+		if _, err := h.resolveWallet(userID, id); err != nil {
+			http.Error(w, fmt.Sprintf("%s (account %q)", err.Error(), name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if invalid := countInvalid(stmt.Rows); invalid > 0 && r.FormValue("skip_invalid") != "true" {
+		http.Error(w, fmt.Sprintf("%d rows have validation errors", invalid), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := h.markAppDuplicates(stmt, walletMappings); err != nil {
+		http.Error(w, "Error checking duplicates", http.StatusInternalServerError)
+		return
+	}
+
+	walletsCreated := []models.Wallet{}
+	walletIDs := make(map[string]uint)
+	walletNames := make(map[string]string)
+	for _, m := range walletMappings {
+		if m.Action == "create" {
+			wallet := &models.Wallet{UserID: userID, Name: m.WalletName, Icon: "💳"}
+			if err := h.walletRepo.Create(wallet); err != nil {
+				http.Error(w, "Error creating wallet "+m.WalletName, http.StatusInternalServerError)
+				return
+			}
+			walletsCreated = append(walletsCreated, *wallet)
+			m.WalletID = wallet.ID
+		}
+		walletIDs[m.Name] = m.WalletID
+		walletNames[m.Name] = m.WalletName
+	}
+
+	// Name transfers after the wallets they end up in, like the transfer endpoint does
+	for i, other := range stmt.Counterparts {
+		row := &stmt.Rows[i]
+		if row.Type == "expense" {
+			row.Description = "Transfer ke " + walletNames[other]
+		} else {
+			row.Description = "Transfer dari " + walletNames[other]
+		}
+	}
+
+	categories, _ := h.categoryRepo.FindByUserID(userID)
+	categoryIDs, categoriesCreated, err := h.createMappedCategories(userID, categoryMappings(stmt.Rows, nil, categories, categoryMap))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	byAccount := make(map[string][]ImportRow)
+	var accounts []string
+	for _, row := range stmt.Rows {
+		if id, ok := categoryIDs[row.CategoryName]; ok {
+			row.CategoryID = id
+		}
+		if _, ok := byAccount[row.Account]; !ok {
+			accounts = append(accounts, row.Account)
+		}
+		byAccount[row.Account] = append(byAccount[row.Account], row)
+	}
+
+	var transactions []models.Transaction
+	skipped, duplicates := 0, 0
+	for _, account := range accounts {
+		rows := byAccount[account]
+		for _, row := range rows {
+			if row.Duplicate {
+				duplicates++
+			}
+		}
+		h.resolveCategories(userID, rows, formUint(r, "default_expense_category_id"), formUint(r, "default_income_category_id"))
+
+		built, n := h.buildTransactions(userID, walletIDs[account], rows)
+		transactions = append(transactions, built...)
+		skipped += n
+	}
+
+	if err := h.transactionRepo.CreateBatch(transactions); err != nil {
+		http.Error(w, "Error importing transactions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(AppCommitResponse{
+		ImportCommitResponse: ImportCommitResponse{Imported: len(transactions), Skipped: skipped},
+		Duplicates:           duplicates,
+		WalletsCreated:       walletsCreated,
+		CategoriesCreated:    categoriesCreated,
+	})
+}
+
+// appWalletMappings lists every wallet of the export with the user wallet
+// it maps to: the override if given, otherwise a wallet with the same name,
+// otherwise a new one.
+func appWalletMappings(stmt *appStatement, wallets []models.Wallet, overrides map[string]uint) []AppWalletMapping {
+	owned := make(map[uint]models.Wallet)
+	byName := make(map[string]models.Wallet)
+	for _, wallet := range wallets {
+		owned[wallet.ID] = wallet
+		byName[strings.ToLower(strings.TrimSpace(wallet.Name))] = wallet
+	}
+
+	counts := make(map[string]int)
+	currencies := make(map[string]map[string]int)
+	var names []string
+	for _, row := range stmt.Rows {
+		if _, ok := currencies[row.Account]; !ok {
+			names = append(names, row.Account)
+			currencies[row.Account] = make(map[string]int)
+		}
+		counts[row.Account]++
+		currencies[row.Account][row.Currency]++
+	}
+	sort.Strings(names)
+
+	mappings := make([]AppWalletMapping, 0, len(names))
+	for _, name := range names {
+		m := AppWalletMapping{Name: name, Count: counts[name]}
+		for currency, n := range currencies[name] {
+			if n > currencies[name][m.Currency] || (n == currencies[name][m.Currency] && currency < m.Currency) {
+				m.Currency = currency
+			}
+		}
+
+		wallet, found := byName[strings.ToLower(name)]
+		if id, ok := overrides[name]; ok {
+			wallet, found = owned[id]
+		}
+		if found {
+			m.WalletID, m.WalletName, m.Action = wallet.ID, wallet.Name, "map"
+		} else {
+			m.WalletName, m.Action = name, "create"
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings
+}
+
+// markAppDuplicates flags rows whose app record ID was already imported into
+// the wallet they map to. Only Money Lover exports carry record IDs.
+func (h *ImportHandler) markAppDuplicates(stmt *appStatement, mappings []AppWalletMapping) error {
+	walletIDs := make(map[string]uint)
+	for _, m := range mappings {
+		walletIDs[m.Name] = m.WalletID
+	}
+
+	ids := make(map[string][]string)
+	for _, row := range stmt.Rows {
+		if row.ExternalID != "" && walletIDs[row.Account] != 0 {
+			ids[row.Account] = append(ids[row.Account], row.ExternalID)
+		}
+	}
+
+	existing := make(map[string]map[string]bool)
+	for account, accountIDs := range ids {
+		found, err := h.transactionRepo.FindExistingExternalIDs(walletIDs[account], accountIDs)
+		if err != nil {
+			return err
+		}
+		existing[account] = found
+	}
+
+	seen := make(map[string]bool)
+	for i := range stmt.Rows {
+		row := &stmt.Rows[i]
+		if row.ExternalID == "" {
+			continue
+		}
+		key := row.Account + "\x00" + row.ExternalID
+		if existing[row.Account][row.ExternalID] || seen[key] {
+			row.Duplicate = true
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// parseAppUpload decodes an uploaded export. app (moneylover, spendee,
+// budgetbakers), date_format and decimal_separator form fields override
+// detection.
+func parseAppUpload(data []byte, r *http.Request) (*appStatement, error) {
+	text, _, err := decodeStatementText(data, r.FormValue("encoding"))
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := readAppRecords(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("No transactions found in file")
+	}
+
+	app := strings.ToLower(r.FormValue("app"))
+	if app == "" {
+		app = detectApp(records[0])
+	}
+
+	var fields func(map[string]string) appRecord
+	switch app {
+	case appMoneyLover:
+		fields = moneyLoverRecord
+	case appSpendee:
+		fields = spendeeRecord
+	case appBudgetBakers:
+		fields = budgetBakersRecord
+	case "":
+		return nil, errors.New("Unknown export format, set app to moneylover, spendee or budgetbakers")
+	default:
+		return nil, fmt.Errorf("Unsupported app: %s", app)
+	}
+
+	parsed := make([]appRecord, len(records))
+	var dates, amounts []string
+	for i, record := range records {
+		parsed[i] = fields(record)
+		dates = append(dates, parsed[i].Date)
+		amounts = append(amounts, parsed[i].Amount)
+	}
+
+	stmt := &appStatement{App: app, Counterparts: make(map[int]string)}
+	stmt.DateFormat = r.FormValue("date_format")
+	if stmt.DateFormat == "" {
+		stmt.DateFormat = detectDateLayout(dates)
+	}
+	stmt.DecimalSeparator = r.FormValue("decimal_separator")
+	if stmt.DecimalSeparator != "." && stmt.DecimalSeparator != "," {
+		stmt.DecimalSeparator = detectDecimalSeparator(amounts)
+	}
+
+	transferAmounts := make([]float64, len(parsed))
+	for i, rec := range parsed {
+		stmt.Rows = append(stmt.Rows, appRow(rec, i+1, stmt.DateFormat, stmt.DecimalSeparator))
+		transferAmounts[i] = stmt.Rows[i].Amount
+		if rec.TransferAmount != "" {
+			if v, _, err := parseStatementAmount(rec.TransferAmount, stmt.DecimalSeparator); err == nil {
+				transferAmounts[i] = absFloat(v)
+			}
+		}
+	}
+	pairAppTransfers(stmt, transferAmounts)
+	return stmt, nil
+}
+
+// readAppRecords reads a CSV file with a header row, or a JSON array of
+// objects, into records keyed by lower-cased column name
+func readAppRecords(text string) ([]map[string]string, error) {
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{") {
+		return readAppJSON(trimmed)
+	}
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = rune(detectDelimiter(text)[0])
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	lines, err := reader.ReadAll()
+	if err != nil {
+		return nil, errors.New("Invalid CSV: " + err.Error())
+	}
+
+	var headers []string
+	var records []map[string]string
+	for _, line := range lines {
+		if isBlankRecord(line) {
+			continue
+		}
+		if headers == nil {
+			for _, header := range uniqueHeaders(line) {
+				headers = append(headers, strings.ToLower(header))
+			}
+			continue
+		}
+		record := make(map[string]string, len(headers))
+		for i, value := range line {
+			if i < len(headers) {
+				record[headers[i]] = strings.TrimSpace(value)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// readAppJSON accepts an array of transaction objects, or an object holding
+// one under a key such as "transactions" or "records"
+func readAppJSON(text string) ([]map[string]string, error) {
+	var raw []map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	if strings.HasPrefix(text, "{") {
+		var doc map[string]json.RawMessage
+		if err := dec.Decode(&doc); err != nil {
+			return nil, errors.New("Invalid JSON format")
+		}
+		for _, key := range []string{"transactions", "records", "data", "items"} {
+			if list, ok := doc[key]; ok {
+				dec = json.NewDecoder(bytes.NewReader(list))
+				dec.UseNumber()
+				break
+			}
+		}
+	}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, errors.New("Invalid JSON format, expected a list of transactions")
+	}
+
+	records := make([]map[string]string, 0, len(raw))
+	for _, object := range raw {
+		record := make(map[string]string, len(object))
+		for key, value := range object {
+			key = strings.ToLower(strings.TrimSpace(key))
+			switch v := value.(type) {
+			case nil:
+			case string:
+				record[key] = strings.TrimSpace(v)
+			case map[string]interface{}:
+				// Nested objects such as {"category": {"name": "Food"}}
+				if name, ok := v["name"].(string); ok {
+					record[key] = strings.TrimSpace(name)
+				}
+			default:
+				record[key] = fmt.Sprint(v)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// detectApp recognizes an export by its columns
+func detectApp(record map[string]string) string {
+	has := func(keys ...string) bool {
+		for _, key := range keys {
+			if _, ok := record[key]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch {
+	case has("ref_currency_amount"), has("payment_type"), has("account", "transfer", "amount"):
+		return appBudgetBakers
+	case has("category name"), has("wallet", "type", "labels"):
+		return appSpendee
+	case has("wallet", "category", "amount"), has("exclude report"):
+		return appMoneyLover
+	}
+	return ""
+}
+
+// moneyLoverRecord reads a Money Lover export: Id, Date, Category, Amount,
+// Currency, Note, Wallet, With, Event, Exclude Report. Transfers between
+// wallets use the Outgoing/Incoming Transfer categories.
+func moneyLoverRecord(record map[string]string) appRecord {
+	rec := appRecord{
+		ID:       record["id"],
+		Account:  record["wallet"],
+		Date:     record["date"],
+		Category: record["category"],
+		Amount:   record["amount"],
+		Currency: record["currency"],
+		Note:     record["note"],
+		Payee:    record["with"],
+	}
+	switch strings.ToLower(rec.Category) {
+	case "outgoing transfer", "transfer keluar":
+		rec.Transfer, rec.Type = true, "expense"
+	case "incoming transfer", "transfer masuk":
+		rec.Transfer, rec.Type = true, "income"
+	case "transfer":
+		rec.Transfer = true
+	}
+	return rec
+}
+
+// spendeeRecord reads a Spendee export: Date, Wallet, Type, Category name,
+// Amount, Currency, Note, Labels, Author. Transfers have type Transfer, with
+// a negative amount in the sending wallet.
+func spendeeRecord(record map[string]string) appRecord {
+	rec := appRecord{
+		Account:  record["wallet"],
+		Date:     record["date"],
+		Category: record["category name"],
+		Amount:   record["amount"],
+		Type:     transactionTypeFromLabel(record["type"]),
+		Currency: record["currency"],
+		Note:     record["note"],
+	}
+	if rec.Category == "" {
+		rec.Category = record["category"]
+	}
+	rec.Transfer = strings.EqualFold(record["type"], "transfer")
+	return rec
+}
+
+// budgetBakersRecord reads a Wallet by BudgetBakers export: account,
+// category, currency, amount, ref_currency_amount, type (Expenses/Income),
+// note, date, transfer, payee, ... Both halves of a transfer share
+// ref_currency_amount, even between currencies.
+func budgetBakersRecord(record map[string]string) appRecord {
+	rec := appRecord{
+		Account:  record["account"],
+		Date:     record["date"],
+		Category: record["category"],
+		Amount:   record["amount"],
+		Currency: record["currency"],
+		Note:     record["note"],
+		Payee:    record["payee"],
+		Transfer: strings.EqualFold(record["transfer"], "true"),
+	}
+	switch strings.ToLower(record["type"]) {
+	case "expenses", "expense":
+		rec.Type = "expense"
+	case "income":
+		rec.Type = "income"
+	}
+	if rec.Transfer {
+		rec.TransferAmount = record["ref_currency_amount"]
+	}
+	return rec
+}
+
+// appRow turns an app record into an import row. A negative amount is an
+// expense; unsigned amounts take their direction from the record's type.
+func appRow(rec appRecord, line int, dateFormat, decimal string) ImportRow {
+	row := ImportRow{
+		Line:         line,
+		Account:      rec.Account,
+		Description:  rec.Payee,
+		Notes:        rec.Note,
+		CategoryName: rec.Category,
+		Currency:     strings.ToUpper(rec.Currency),
+	}
+	if row.Description == "" {
+		row.Description, row.Notes = rec.Note, ""
+	}
+	if row.Description == "" {
+		row.Description = rec.Category
+	}
+	if rec.ID != "" {
+		row.ExternalID = "moneylover:" + rec.ID
+	}
+	if row.Account == "" {
+		row.addError("Missing wallet")
+	}
+
+	if rec.Date == "" {
+		row.addError("Missing date")
+	} else if date, err := parseAppDate(rec.Date, dateFormat); err != nil {
+		row.addError("Invalid date: " + rec.Date)
+	} else {
+		row.setDate(date)
+	}
+
+	if rec.Amount == "" {
+		row.addError("Missing amount")
+	} else if value, _, err := parseStatementAmount(rec.Amount, decimal); err != nil {
+		row.addError("Invalid amount: " + rec.Amount)
+	} else if value == 0 {
+		row.addError("Amount is zero")
+	} else {
+		switch {
+		case value < 0:
+			row.Type = "expense"
+		case rec.Type != "":
+			row.Type = rec.Type
+		default:
+			row.Type = "income"
+		}
+		row.Amount = absFloat(value)
+	}
+
+	if rec.Transfer {
+		row.CategoryName = transferCategory
+		if row.Description == rec.Category {
+			row.Description = transferCategory
+		}
+	}
+	return row
+}
+
+// parseAppDate parses the date part of a timestamp such as
+// "2024-01-05T10:20:30+07:00" or "05/01/2024"
+func parseAppDate(value, layout string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+	}
+	t, err := parseStatementDate(value, layout)
+	if err != nil {
+		return t, err
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+// pairAppTransfers matches the outgoing half of every transfer with an
+// incoming half in another wallet on the same day, preferring one with the
+// same amount. amounts holds the amount both halves share.
+func pairAppTransfers(stmt *appStatement, amounts []float64) {
+	var outgoing, incoming []int
+	for i, row := range stmt.Rows {
+		if row.CategoryName != transferCategory || len(row.Errors) > 0 {
+			continue
+		}
+		if row.Type == "expense" {
+			outgoing = append(outgoing, i)
+		} else {
+			incoming = append(incoming, i)
+		}
+	}
+
+	paired := make(map[int]bool)
+	match := func(sameAmount bool) {
+		for _, out := range outgoing {
+			if paired[out] {
+				continue
+			}
+			from := stmt.Rows[out]
+			candidate := -1
+			for _, in := range incoming {
+				to := stmt.Rows[in]
+				if paired[in] || to.Date != from.Date || to.Account == from.Account {
+					continue
+				}
+				if sameAmount && absFloat(amounts[in]-amounts[out]) > 0.005 {
+					continue
+				}
+				if candidate >= 0 && !sameAmount {
+					// Several halves with different amounts, don't guess
+					candidate = -2
+					break
+				}
+				candidate = in
+				if sameAmount {
+					break
+				}
+			}
+			if candidate >= 0 {
+				paired[out], paired[candidate] = true, true
+				stmt.Counterparts[out] = stmt.Rows[candidate].Account
+				stmt.Counterparts[candidate] = from.Account
+			}
+		}
+	}
+	match(true)
+	// Transfers between currencies differ in amount, pair those that are alone on their day
+	match(false)
+}
@@ -0,0 +1,934 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/pkg/middleware"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// CSVImportOptions controls how a CSV statement is read. Empty fields are
+// auto-detected from the file.
+type CSVImportOptions struct {
+	Delimiter        string           `json:"delimiter"`
+	Encoding         string           `json:"encoding"`
+	DateFormat       string           `json:"date_format"`
+	DecimalSeparator string           `json:"decimal_separator"`
+	HasHeader        *bool            `json:"has_header"`
+	SkipRows         int              `json:"skip_rows"`
+	Mapping          CSVColumnMapping `json:"mapping"`
+}
+
+// CSVColumnMapping maps transaction fields to CSV header names
+type CSVColumnMapping struct {
+	Date        string `json:"date"`
+	Description string `json:"description"`
+	Amount      string `json:"amount"`
+	Debit       string `json:"debit"`
+	Credit      string `json:"credit"`
+	Type        string `json:"type"`
+	Category    string `json:"category"`
+	Notes       string `json:"notes"`
+	Currency    string `json:"currency"`
+}
+
+type CSVPreviewResponse struct {
+	Options     CSVImportOptions `json:"options"` // Resolved settings, can be saved as a profile
+	Headers     []string         `json:"headers"`
+	Rows        []ImportRow      `json:"rows"`   // First rows of the file
+	Errors      []ImportRow      `json:"errors"` // Rows that failed validation
+	TotalRows   int              `json:"total_rows"`
+	ValidRows   int              `json:"valid_rows"`
+	InvalidRows int              `json:"invalid_rows"`
+}
+
+type ImportProfileRequest struct {
+	Name    string           `json:"name"`
+	Bank    string           `json:"bank"`
+	Options CSVImportOptions `json:"options"`
+}
+
+const csvPreviewRows = 100
+
+// PreviewCSV parses an uploaded CSV statement and returns the detected format,
+// the column mapping and the parsed rows with their validation errors.
+// Nothing is written to the database.
+func (h *ImportHandler) PreviewCSV(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	data, err := readImportFile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts, err := h.csvOptionsFromRequest(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	headers, rows, resolved, err := parseCSVStatement(data, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.resolveCategories(userID, rows, formUint(r, "default_expense_category_id"), formUint(r, "default_income_category_id"))
+
+	resp := CSVPreviewResponse{
+		Options:   resolved,
+		Headers:   headers,
+		Rows:      []ImportRow{},
+		Errors:    []ImportRow{},
+		TotalRows: len(rows),
+	}
+	for i, row := range rows {
+		if i < csvPreviewRows {
+			resp.Rows = append(resp.Rows, row)
+		}
+		if len(row.Errors) > 0 {
+			if len(resp.Errors) < csvPreviewRows {
+				resp.Errors = append(resp.Errors, row)
+			}
+			resp.InvalidRows++
+		}
+	}
+	resp.ValidRows = resp.TotalRows - resp.InvalidRows
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CommitCSV imports the rows of an uploaded CSV statement into a wallet.
+// Rows with validation errors abort the import unless skip_invalid is set.
+func (h *ImportHandler) CommitCSV(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	data, err := readImportFile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	wallet, err := h.resolveWallet(userID, formUint(r, "wallet_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts, err := h.csvOptionsFromRequest(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, rows, _, err := parseCSVStatement(data, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.resolveCategories(userID, rows, formUint(r, "default_expense_category_id"), formUint(r, "default_income_category_id"))
+
+	if invalid := countInvalid(rows); invalid > 0 && r.FormValue("skip_invalid") != "true" {
+		http.Error(w, fmt.Sprintf("%d rows have validation errors", invalid), http.StatusUnprocessableEntity)
+		return
+	}
+
+	transactions, skipped := h.buildTransactions(userID, wallet.ID, rows)
+	if err := h.transactionRepo.CreateBatch(transactions); err != nil {
+		http.Error(w, "Error importing transactions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ImportCommitResponse{Imported: len(transactions), Skipped: skipped})
+}
+
+// csvOptionsFromRequest reads options from a saved profile (profile_id) and/or
+// the "options" JSON form field. Explicit options override the profile.
+func (h *ImportHandler) csvOptionsFromRequest(r *http.Request, userID uint) (CSVImportOptions, error) {
+	var opts CSVImportOptions
+
+	if profileID := formUint(r, "profile_id"); profileID != 0 {
+		profile, err := h.profileRepo.FindByID(profileID)
+		if err != nil || profile.UserID != userID {
+			return opts, errors.New("Import profile not found")
+		}
+		opts = csvOptionsFromProfile(profile)
+	}
+
+	if raw := r.FormValue("options"); raw != "" {
+		var override CSVImportOptions
+		if err := json.Unmarshal([]byte(raw), &override); err != nil {
+			return opts, errors.New("Invalid options")
+		}
+		opts = mergeCSVOptions(opts, override)
+	}
+
+	return opts, nil
+}
+
+func mergeCSVOptions(base, override CSVImportOptions) CSVImportOptions {
+	pick := func(a, b string) string {
+		if b != "" {
+			return b
+		}
+		return a
+	}
+	base.Delimiter = pick(base.Delimiter, override.Delimiter)
+	base.Encoding = pick(base.Encoding, override.Encoding)
+	base.DateFormat = pick(base.DateFormat, override.DateFormat)
+	base.DecimalSeparator = pick(base.DecimalSeparator, override.DecimalSeparator)
+	if override.HasHeader != nil {
+		base.HasHeader = override.HasHeader
+	}
+	if override.SkipRows != 0 {
+		base.SkipRows = override.SkipRows
+	}
+
+	m, o := &base.Mapping, override.Mapping
+	m.Date = pick(m.Date, o.Date)
+	m.Description = pick(m.Description, o.Description)
+	m.Amount = pick(m.Amount, o.Amount)
+	m.Debit = pick(m.Debit, o.Debit)
+	m.Credit = pick(m.Credit, o.Credit)
+	m.Type = pick(m.Type, o.Type)
+	m.Category = pick(m.Category, o.Category)
+	m.Notes = pick(m.Notes, o.Notes)
+	m.Currency = pick(m.Currency, o.Currency)
+	return base
+}
+
+func csvOptionsFromProfile(p *models.ImportProfile) CSVImportOptions {
+	hasHeader := p.HasHeader
+	return CSVImportOptions{
+		Delimiter:        p.Delimiter,
+		Encoding:         p.Encoding,
+		DateFormat:       p.DateFormat,
+		DecimalSeparator: p.DecimalSeparator,
+		HasHeader:        &hasHeader,
+		SkipRows:         p.SkipRows,
+		Mapping: CSVColumnMapping{
+			Date:        p.DateColumn,
+			Description: p.DescriptionColumn,
+			Amount:      p.AmountColumn,
+			Debit:       p.DebitColumn,
+			Credit:      p.CreditColumn,
+			Type:        p.TypeColumn,
+			Category:    p.CategoryColumn,
+			Notes:       p.NotesColumn,
+			Currency:    p.CurrencyColumn,
+		},
+	}
+}
+
+func applyCSVOptionsToProfile(p *models.ImportProfile, opts CSVImportOptions) {
+	p.Delimiter = opts.Delimiter
+	p.Encoding = opts.Encoding
+	p.DateFormat = opts.DateFormat
+	p.DecimalSeparator = opts.DecimalSeparator
+	p.HasHeader = opts.HasHeader == nil || *opts.HasHeader
+	p.SkipRows = opts.SkipRows
+	p.DateColumn = opts.Mapping.Date
+	p.DescriptionColumn = opts.Mapping.Description
+	p.AmountColumn = opts.Mapping.Amount
+	p.DebitColumn = opts.Mapping.Debit
+	p.CreditColumn = opts.Mapping.Credit
+	p.TypeColumn = opts.Mapping.Type
+	p.CategoryColumn = opts.Mapping.Category
+	p.NotesColumn = opts.Mapping.Notes
+	p.CurrencyColumn = opts.Mapping.Currency
+}
+
+func (h *ImportHandler) ListProfiles(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	profiles, err := h.profileRepo.FindByUserID(userID)
+	if err != nil {
+		http.Error(w, "Error fetching import profiles", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profiles)
+}
+
+func (h *ImportHandler) CreateProfile(w http.ResponseWriter, r *http.Request) {
+	var req ImportProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	profile := &models.ImportProfile{
+		UserID: middleware.GetUserID(r),
+		Name:   req.Name,
+		Bank:   req.Bank,
+	}
+	applyCSVOptionsToProfile(profile, req.Options)
+
+	if err := h.profileRepo.Create(profile); err != nil {
+		http.Error(w, "Error creating import profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(profile)
+}
+
+func (h *ImportHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid profile ID", http.StatusBadRequest)
+		return
+	}
+
+	profile, err := h.profileRepo.FindByID(uint(id))
+	if err != nil {
+		http.Error(w, "Import profile not found", http.StatusNotFound)
+		return
+	}
+
+	userID := middleware.GetUserID(r)
+	if profile.UserID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var req ImportProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name != "" {
+		profile.Name = req.Name
+	}
+	profile.Bank = req.Bank
+	applyCSVOptionsToProfile(profile, req.Options)
+
+	if err := h.profileRepo.Update(profile); err != nil {
+		http.Error(w, "Error updating import profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+func (h *ImportHandler) DeleteProfile(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid profile ID", http.StatusBadRequest)
+		return
+	}
+
+	profile, err := h.profileRepo.FindByID(uint(id))
+	if err != nil {
+		http.Error(w, "Import profile not found", http.StatusNotFound)
+		return
+	}
+
+	userID := middleware.GetUserID(r)
+	if profile.UserID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := h.profileRepo.Delete(uint(id)); err != nil {
+		http.Error(w, "Error deleting import profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseCSVStatement decodes and parses a CSV statement. It returns the header
+// names, one ImportRow per data line and the options with every auto-detected
+// setting filled in.
+func parseCSVStatement(data []byte, opts CSVImportOptions) ([]string, []ImportRow, CSVImportOptions, error) {
+	text, encoding, err := decodeStatementText(data, opts.Encoding)
+	if err != nil {
+		return nil, nil, opts, err
+	}
+	opts.Encoding = encoding
+
+	// Drop preamble lines (account number, period, ...) some banks put on top
+	if opts.SkipRows > 0 {
+		lines := strings.SplitN(text, "\n", opts.SkipRows+1)
+		if len(lines) <= opts.SkipRows {
+			return nil, nil, opts, errors.New("File has no rows after skip_rows")
+		}
+		text = lines[opts.SkipRows]
+	}
+
+	if opts.Delimiter == "" {
+		opts.Delimiter = detectDelimiter(text)
+	}
+	if opts.Delimiter == `\t` {
+		opts.Delimiter = "\t"
+	}
+	delimiter, _ := utf8.DecodeRuneInString(opts.Delimiter)
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	var records [][]string
+	var recordLines []int
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, nil, opts, fmt.Errorf("CSV parse error on line %d", parseErr.Line)
+			}
+			return nil, nil, opts, errors.New("Could not read CSV file")
+		}
+		if !isBlankRecord(record) {
+			line, _ := reader.FieldPos(0)
+			records = append(records, record)
+			recordLines = append(recordLines, line+opts.SkipRows)
+		}
+	}
+	if len(records) == 0 {
+		return nil, nil, opts, errors.New("File contains no rows")
+	}
+
+	if opts.HasHeader == nil {
+		hasHeader := looksLikeHeader(records[0])
+		opts.HasHeader = &hasHeader
+	}
+
+	var headers []string
+	if *opts.HasHeader {
+		headers = uniqueHeaders(records[0])
+		records = records[1:]
+		recordLines = recordLines[1:]
+	} else {
+		width := 0
+		for _, rec := range records {
+			if len(rec) > width {
+				width = len(rec)
+			}
+		}
+		for i := 0; i < width; i++ {
+			headers = append(headers, fmt.Sprintf("Column %d", i+1))
+		}
+	}
+
+	opts.Mapping = guessCSVMapping(headers, opts.Mapping)
+	if opts.Mapping.Amount == "" && opts.Mapping.Debit == "" && opts.Mapping.Credit == "" {
+		return headers, nil, opts, errors.New("Could not determine the amount column, please map it manually")
+	}
+	if opts.Mapping.Date == "" {
+		return headers, nil, opts, errors.New("Could not determine the date column, please map it manually")
+	}
+
+	index := make(map[string]int, len(headers))
+	for i, name := range headers {
+		index[name] = i
+	}
+	cell := func(record []string, column string) string {
+		i, ok := index[column]
+		if !ok || column == "" || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	if opts.DateFormat == "" {
+		var samples []string
+		for _, rec := range records {
+			samples = append(samples, cell(rec, opts.Mapping.Date))
+		}
+		opts.DateFormat = detectDateLayout(samples)
+	}
+	if opts.DecimalSeparator == "" {
+		var samples []string
+		for _, rec := range records {
+			for _, col := range []string{opts.Mapping.Amount, opts.Mapping.Debit, opts.Mapping.Credit} {
+				if v := cell(rec, col); v != "" {
+					samples = append(samples, v)
+				}
+			}
+		}
+		opts.DecimalSeparator = detectDecimalSeparator(samples)
+	}
+
+	rows := make([]ImportRow, 0, len(records))
+	for i, rec := range records {
+		row := ImportRow{
+			Line:         recordLines[i],
+			Description:  cell(rec, opts.Mapping.Description),
+			CategoryName: cell(rec, opts.Mapping.Category),
+			Notes:        cell(rec, opts.Mapping.Notes),
+			Currency:     strings.ToUpper(cell(rec, opts.Mapping.Currency)),
+		}
+		if row.Currency == "" {
+			row.Currency = "IDR"
+		}
+
+		if date, err := parseStatementDate(cell(rec, opts.Mapping.Date), opts.DateFormat); err != nil {
+			row.addError("Invalid date: " + cell(rec, opts.Mapping.Date))
+		} else {
+			row.setDate(date)
+		}
+
+		parseCSVAmount(&row, rec, opts, cell)
+		rows = append(rows, row)
+	}
+
+	return headers, rows, opts, nil
+}
+
+// parseCSVAmount fills Amount and Type from either separate debit/credit
+// columns or a single amount column. The direction of a single amount comes
+// from a CR/DB suffix, the type column, or its sign, in that order.
+func parseCSVAmount(row *ImportRow, rec []string, opts CSVImportOptions, cell func([]string, string) string) {
+	m := opts.Mapping
+
+	if m.Debit != "" || m.Credit != "" {
+		debit, credit := cell(rec, m.Debit), cell(rec, m.Credit)
+		if debit != "" {
+			if v, _, err := parseStatementAmount(debit, opts.DecimalSeparator); err == nil && v != 0 {
+				row.Amount, row.Type = absFloat(v), "expense"
+				return
+			}
+		}
+		if credit != "" {
+			if v, _, err := parseStatementAmount(credit, opts.DecimalSeparator); err == nil && v != 0 {
+				row.Amount, row.Type = absFloat(v), "income"
+				return
+			}
+		}
+		if m.Amount == "" {
+			row.addError("Missing debit/credit amount")
+			return
+		}
+	}
+
+	raw := cell(rec, m.Amount)
+	value, direction, err := parseStatementAmount(raw, opts.DecimalSeparator)
+	if err != nil {
+		row.addError("Invalid amount: " + raw)
+		return
+	}
+	if value == 0 {
+		row.addError("Amount is zero")
+		return
+	}
+
+	switch {
+	case direction != "":
+		row.Type = direction
+	case m.Type != "":
+		row.Type = transactionTypeFromLabel(cell(rec, m.Type))
+		if row.Type == "" {
+			row.addError("Unknown transaction type: " + cell(rec, m.Type))
+			return
+		}
+	case value < 0:
+		row.Type = "expense"
+	default:
+		row.Type = "income"
+	}
+	row.Amount = absFloat(value)
+}
+
+// decodeStatementText converts raw bytes to UTF-8 text. Without an explicit
+// encoding it honours a BOM, then falls back to Windows-1252 for files that
+// are not valid UTF-8 (typical for older bank exports).
+func decodeStatementText(data []byte, encoding string) (string, string, error) {
+	encoding = strings.ToLower(encoding)
+	if encoding == "" {
+		switch {
+		case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+			encoding = "utf-8"
+		case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+			encoding = "utf-16le"
+		case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+			encoding = "utf-16be"
+		case utf8.Valid(data):
+			encoding = "utf-8"
+		default:
+			encoding = "windows-1252"
+		}
+	}
+
+	var out []byte
+	var err error
+	switch encoding {
+	case "utf-8", "utf8":
+		encoding = "utf-8"
+		out = bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF})
+	case "utf-16le":
+		out, err = unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder().Bytes(data)
+	case "utf-16be":
+		out, err = unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder().Bytes(data)
+	case "windows-1252", "cp1252", "latin1", "iso-8859-1":
+		encoding = "windows-1252"
+		out, err = charmap.Windows1252.NewDecoder().Bytes(data)
+	default:
+		return "", encoding, fmt.Errorf("Unsupported encoding: %s", encoding)
+	}
+	if err != nil {
+		return "", encoding, errors.New("Could not decode file with encoding " + encoding)
+	}
+
+	text := strings.ReplaceAll(string(out), "\r\n", "\n")
+	return strings.ReplaceAll(text, "\r", "\n"), encoding, nil
+}
+
+// detectDelimiter picks the candidate that splits the first lines into the
+// same (largest) number of fields most consistently.
+func detectDelimiter(text string) string {
+	lines := strings.Split(text, "\n")
+	var sample []string
+	for _, l := range lines {
+		if strings.TrimSpace(l) != "" {
+			sample = append(sample, l)
+		}
+		if len(sample) == 20 {
+			break
+		}
+	}
+
+	best, bestScore := ",", 0
+	for _, candidate := range []string{",", ";", "\t", "|"} {
+		counts := make(map[int]int)
+		for _, l := range sample {
+			counts[strings.Count(l, candidate)]++
+		}
+		for fields, lines := range counts {
+			if fields == 0 {
+				continue
+			}
+			if score := lines*100 + fields; score > bestScore {
+				best, bestScore = candidate, score
+			}
+		}
+	}
+	return best
+}
+
+func isBlankRecord(record []string) bool {
+	for _, c := range record {
+		if strings.TrimSpace(c) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// looksLikeHeader treats the first row as a header when none of its cells
+// parse as a date or an amount.
+func looksLikeHeader(record []string) bool {
+	for _, c := range record {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if _, err := parseStatementDate(c, detectDateLayout([]string{c})); err == nil {
+			return false
+		}
+		if _, _, err := parseStatementAmount(c, "."); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func uniqueHeaders(record []string) []string {
+	seen := make(map[string]int)
+	headers := make([]string, len(record))
+	for i, h := range record {
+		h = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+		if h == "" {
+			h = fmt.Sprintf("Column %d", i+1)
+		}
+		seen[h]++
+		if seen[h] > 1 {
+			h = fmt.Sprintf("%s (%d)", h, seen[h])
+		}
+		headers[i] = h
+	}
+	return headers
+}
+
+// csvHeaderAliases lists, per field and in matching priority, the (Indonesian
+// and English) header words used by common bank exports.
+var csvHeaderAliases = []struct {
+	field   string
+	aliases []string
+}{
+	{"date", []string{"tanggal", "tgl", "date", "waktu"}},
+	{"type", []string{"db/cr", "cr/db", "d/k", "jenis", "tipe", "type"}},
+	{"debit", []string{"debit", "debet", "keluar", "withdrawal", "pengeluaran", "money out", "outgoing"}},
+	{"credit", []string{"credit", "kredit", "masuk", "deposit", "pemasukan", "money in", "incoming"}},
+	{"amount", []string{"amount", "jumlah", "nominal", "nilai", "mutasi", "total"}},
+	{"currency", []string{"currency", "mata uang", "ccy"}},
+	{"category", []string{"category", "kategori"}},
+	{"notes", []string{"notes", "note", "catatan", "memo"}},
+	{"description", []string{"keterangan", "description", "deskripsi", "uraian", "remark", "detail", "payee", "berita", "transaksi", "merchant"}},
+}
+
+// guessCSVMapping fills unmapped fields by matching header names against
+// csvHeaderAliases. Fields mapped explicitly are kept as they are.
+func guessCSVMapping(headers []string, m CSVColumnMapping) CSVColumnMapping {
+	fields := map[string]*string{
+		"date": &m.Date, "type": &m.Type, "debit": &m.Debit, "credit": &m.Credit, "amount": &m.Amount,
+		"currency": &m.Currency, "category": &m.Category, "notes": &m.Notes, "description": &m.Description,
+	}
+
+	used := make(map[string]bool)
+	for _, p := range fields {
+		if *p != "" {
+			used[*p] = true
+		}
+	}
+	// Only guess when nothing was mapped explicitly, so a saved profile is never second-guessed
+	if len(used) > 0 {
+		return m
+	}
+
+	for _, header := range headers {
+		name := strings.ToLower(header)
+		for _, entry := range csvHeaderAliases {
+			target := fields[entry.field]
+			if *target != "" || used[header] {
+				continue
+			}
+			for _, alias := range entry.aliases {
+				if strings.Contains(name, alias) {
+					*target = header
+					used[header] = true
+					break
+				}
+			}
+		}
+	}
+	return m
+}
+
+// transactionTypeFromLabel maps a bank's debit/credit marker to a transaction type
+func transactionTypeFromLabel(label string) string {
+	switch strings.ToLower(strings.TrimSpace(label)) {
+	case "db", "d", "dr", "debit", "debet", "expense", "pengeluaran", "keluar", "out", "withdrawal", "-":
+		return "expense"
+	case "cr", "k", "c", "credit", "kredit", "income", "pemasukan", "masuk", "in", "deposit", "+":
+		return "income"
+	}
+	return ""
+}
+
+// statementDateLayouts are tried in order; day-first layouts come before
+// month-first ones because Indonesian banks use DD/MM.
+var statementDateLayouts = []string{
+	"2006-01-02",
+	"2/1/2006",
+	"1/2/2006",
+	"2-1-2006",
+	"1-2-2006",
+	"2.1.2006",
+	"2006/01/02",
+	"2/1/06",
+	"1/2/06",
+	"2 Jan 2006",
+	"2-Jan-2006",
+	"2 January 2006",
+	"Jan 2, 2006",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+	"2/1/2006 15:04:05",
+	"2/1/2006 15:04",
+	"1/2/2006 15:04:05",
+	"20060102",
+	"2/1",
+}
+
+// detectDateLayout returns the first layout that parses every sample, or the
+// one that parses the most samples.
+func detectDateLayout(samples []string) string {
+	best, bestCount := statementDateLayouts[0], 0
+	total := 0
+	for _, s := range samples {
+		if strings.TrimSpace(s) != "" {
+			total++
+		}
+	}
+
+	for _, layout := range statementDateLayouts {
+		count := 0
+		for _, s := range samples {
+			if strings.TrimSpace(s) == "" {
+				continue
+			}
+			if _, err := parseStatementDate(s, layout); err == nil {
+				count++
+			}
+		}
+		if count == total && total > 0 {
+			return layout
+		}
+		if count > bestCount {
+			best, bestCount = layout, count
+		}
+	}
+	return best
+}
+
+var indonesianMonths = map[string]string{
+	"januari": "January", "februari": "February", "maret": "March", "april": "April",
+	"mei": "May", "juni": "June", "juli": "July", "agustus": "August",
+	"september": "September", "oktober": "October", "november": "November", "desember": "December",
+	"agu": "Aug", "agt": "Aug", "okt": "Oct", "des": "Dec", "peb": "Feb", "nop": "Nov",
+}
+
+var wordPattern = regexp.MustCompile(`[A-Za-z]+`)
+
+// parseStatementDate parses a date with the given layout, accepting
+// Indonesian month names and filling in the year for "DD/MM" dates.
+func parseStatementDate(value, layout string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	value = wordPattern.ReplaceAllStringFunc(value, func(w string) string {
+		if en, ok := indonesianMonths[strings.ToLower(w)]; ok {
+			return en
+		}
+		return w
+	})
+
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return t, err
+	}
+
+	if t.Year() == 0 {
+		// Statement without a year, assume the most recent past occurrence
+		now := time.Now()
+		t = time.Date(now.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		if t.After(now) {
+			t = t.AddDate(-1, 0, 0)
+		}
+	}
+	return t, nil
+}
+
+// detectDecimalSeparator votes over sample amounts. "1.234.567,00" and
+// "1.234" vote for a decimal comma, "1,234.50" and "12.5" for a decimal point.
+func detectDecimalSeparator(samples []string) string {
+	comma, dot, ambiguousDot := 0, 0, 0
+	for _, s := range samples {
+		s = cleanAmountText(s)
+		lastDot, lastComma := strings.LastIndex(s, "."), strings.LastIndex(s, ",")
+		switch {
+		case lastDot >= 0 && lastComma >= 0:
+			if lastComma > lastDot {
+				comma++
+			} else {
+				dot++
+			}
+		case lastComma >= 0:
+			if strings.Count(s, ",") == 1 && len(s)-lastComma-1 != 3 {
+				comma++
+			} else {
+				dot++ // "1,234" or "1,234,567" uses comma thousands
+			}
+		case lastDot >= 0:
+			if strings.Count(s, ".") > 1 {
+				comma++
+			} else if len(s)-lastDot-1 == 3 {
+				ambiguousDot++
+			} else {
+				dot++
+			}
+		}
+	}
+
+	if comma > dot || (comma == dot && ambiguousDot > 0) {
+		return ","
+	}
+	return "."
+}
+
+var amountNoise = strings.NewReplacer("Rp.", "", "Rp", "", "IDR", "", "$", "", "€", "", "£", "", "\u00a0", "", " ", "", "'", "")
+
+func cleanAmountText(s string) string {
+	s = strings.TrimSpace(s)
+	upper := strings.ToUpper(s)
+	for _, suffix := range []string{"CR", "DB", "DR"} {
+		if strings.HasSuffix(upper, suffix) {
+			s = s[:len(s)-len(suffix)]
+			break
+		}
+	}
+	s = amountNoise.Replace(s)
+	return strings.Trim(s, "+-()")
+}
+
+// parseStatementAmount parses a bank-formatted amount such as "1.234.567,00",
+// "(1,234.50)", "-50000" or "1,000.00 CR". direction is "income"/"expense"
+// when the value carries a CR/DB marker, empty otherwise. The returned value
+// is negative for parenthesised or minus-signed amounts.
+func parseStatementAmount(raw, decimalSeparator string) (float64, string, error) {
+	s := strings.TrimSpace(raw)
+	if s == "" {
+		return 0, "", errors.New("empty amount")
+	}
+
+	direction := ""
+	upper := strings.ToUpper(s)
+	switch {
+	case strings.HasSuffix(upper, "CR"):
+		direction = "income"
+	case strings.HasSuffix(upper, "DB"), strings.HasSuffix(upper, "DR"):
+		direction = "expense"
+	}
+
+	negative := strings.HasPrefix(s, "-") || strings.HasSuffix(strings.TrimSpace(amountNoise.Replace(s)), "-") ||
+		(strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")"))
+
+	s = cleanAmountText(s)
+	if decimalSeparator == "," {
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.ReplaceAll(s, ",", ".")
+	} else {
+		s = strings.ReplaceAll(s, ",", "")
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, "", err
+	}
+	if negative {
+		value = -value
+	}
+	return value, direction, nil
+}
@@ -0,0 +1,383 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/money-management/backend/pkg/middleware"
+)
+
+// ofxStatement is the subset of an OFX/QFX statement download we import
+type ofxStatement struct {
+	Currency      string
+	AccountID     string
+	Transactions  []ofxTransaction
+	LedgerBalance *float64
+	LedgerDate    time.Time
+}
+
+type ofxTransaction struct {
+	Type   string // TRNTYPE: DEBIT, CREDIT, POS, ATM, FEE, ...
+	FITID  string
+	Posted time.Time
+	Amount float64 // Signed, negative = money out
+	Name   string
+	Memo   string
+}
+
+type OFXPreviewResponse struct {
+	AccountID     string      `json:"account_id"`
+	Currency      string      `json:"currency"`
+	Rows          []ImportRow `json:"rows"`
+	NewRows       int         `json:"new_rows"`
+	DuplicateRows int         `json:"duplicate_rows"`
+	InvalidRows   int         `json:"invalid_rows"`
+	Reconciliation
+}
+
+// Reconciliation compares the bank's ledger balance with the wallet balance
+// the import would leave behind.
+type Reconciliation struct {
+	LedgerBalance    *float64 `json:"ledger_balance"` // In IDR, nil when the statement has none
+	LedgerDate       string   `json:"ledger_date,omitempty"`
+	WalletBalance    float64  `json:"wallet_balance"`    // Before import
+	ProjectedBalance float64  `json:"projected_balance"` // After import
+	Difference       float64  `json:"difference"`        // Ledger - projected
+	Reconciled       bool     `json:"reconciled"`        // Difference is below one unit
+	BalanceSynced    bool     `json:"balance_synced"`    // Wallet balance was set to the ledger balance
+}
+
+type OFXCommitResponse struct {
+	ImportCommitResponse
+	Duplicates int `json:"duplicates"`
+	Reconciliation
+}
+
+// PreviewOFX parses an OFX/QFX download for the given wallet, flags
+// transactions whose FITID was already imported, suggests categories and
+// reconciles the statement's ledger balance. Nothing is written.
+func (h *ImportHandler) PreviewOFX(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	data, err := readImportFile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	wallet, err := h.resolveWallet(userID, formUint(r, "wallet_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stmt, err := parseOFX(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := h.prepareOFXRows(userID, wallet.ID, stmt, r)
+	if err != nil {
+		http.Error(w, "Error checking existing transactions", http.StatusInternalServerError)
+		return
+	}
+
+	resp := OFXPreviewResponse{
+		AccountID:      stmt.AccountID,
+		Currency:       stmt.Currency,
+		Rows:           rows,
+		Reconciliation: h.reconcile(stmt, wallet.Balance, rows),
+	}
+	for _, row := range rows {
+		switch {
+		case len(row.Errors) > 0:
+			resp.InvalidRows++
+		case row.Duplicate:
+			resp.DuplicateRows++
+		default:
+			resp.NewRows++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CommitOFX imports the new transactions of an OFX/QFX download into the
+// wallet. With sync_balance=true the wallet balance is then set to the
+// statement's ledger balance.
+func (h *ImportHandler) CommitOFX(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	data, err := readImportFile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	wallet, err := h.resolveWallet(userID, formUint(r, "wallet_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stmt, err := parseOFX(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := h.prepareOFXRows(userID, wallet.ID, stmt, r)
+	if err != nil {
+		http.Error(w, "Error checking existing transactions", http.StatusInternalServerError)
+		return
+	}
+	reconciliation := h.reconcile(stmt, wallet.Balance, rows)
+
+	transactions, skipped := h.buildTransactions(userID, wallet.ID, rows)
+	if err := h.transactionRepo.CreateBatch(transactions); err != nil {
+		http.Error(w, "Error importing transactions", http.StatusInternalServerError)
+		return
+	}
+
+	if r.FormValue("sync_balance") == "true" && reconciliation.LedgerBalance != nil && !reconciliation.Reconciled {
+		if updated, err := h.walletRepo.FindByID(wallet.ID); err == nil {
+			updated.Balance = *reconciliation.LedgerBalance
+			if err := h.walletRepo.Update(updated); err == nil {
+				reconciliation.BalanceSynced = true
+			}
+		}
+	}
+
+	duplicates := 0
+	for _, row := range rows {
+		if row.Duplicate {
+			duplicates++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(OFXCommitResponse{
+		ImportCommitResponse: ImportCommitResponse{Imported: len(transactions), Skipped: skipped},
+		Duplicates:           duplicates,
+		Reconciliation:       reconciliation,
+	})
+}
+
+// prepareOFXRows converts statement transactions to rows, marks FITIDs that
+// already exist in the wallet (or repeat within the file) as duplicates and
+// runs category suggestion.
+func (h *ImportHandler) prepareOFXRows(userID, walletID uint, stmt *ofxStatement, r *http.Request) ([]ImportRow, error) {
+	var ids []string
+	for _, t := range stmt.Transactions {
+		if t.FITID != "" {
+			ids = append(ids, t.FITID)
+		}
+	}
+	existing, err := h.transactionRepo.FindExistingExternalIDs(walletID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	rows := make([]ImportRow, 0, len(stmt.Transactions))
+	for i, t := range stmt.Transactions {
+		row := ImportRow{
+			Line:        i + 1,
+			Description: t.Name,
+			Notes:       t.Memo,
+			Currency:    stmt.Currency,
+			ExternalID:  t.FITID,
+		}
+		if row.Description == "" {
+			row.Description, row.Notes = t.Memo, ""
+		} else if t.Memo == t.Name {
+			row.Notes = ""
+		}
+
+		if t.Posted.IsZero() {
+			row.addError("Missing DTPOSTED")
+		} else {
+			row.setDate(t.Posted)
+		}
+
+		switch {
+		case t.Amount < 0:
+			row.Type, row.Amount = "expense", -t.Amount
+		case t.Amount > 0:
+			row.Type, row.Amount = "income", t.Amount
+		default:
+			row.addError("Amount is zero")
+		}
+
+		if t.FITID == "" {
+			row.addError("Missing FITID")
+		} else if existing[t.FITID] || seen[t.FITID] {
+			row.Duplicate = true
+		}
+		seen[t.FITID] = true
+
+		rows = append(rows, row)
+	}
+
+	h.resolveCategories(userID, rows, formUint(r, "default_expense_category_id"), formUint(r, "default_income_category_id"))
+	return rows, nil
+}
+
+// reconcile projects the wallet balance after importing the new rows and
+// compares it with the statement's ledger balance.
+func (h *ImportHandler) reconcile(stmt *ofxStatement, walletBalance float64, rows []ImportRow) Reconciliation {
+	rate := h.currencyHandler.GetRate(stmt.Currency)
+
+	rec := Reconciliation{WalletBalance: walletBalance, ProjectedBalance: walletBalance}
+	for _, row := range rows {
+		if len(row.Errors) > 0 || row.Duplicate {
+			continue
+		}
+		if row.Type == "income" {
+			rec.ProjectedBalance += row.Amount * rate
+		} else {
+			rec.ProjectedBalance -= row.Amount * rate
+		}
+	}
+
+	if stmt.LedgerBalance != nil {
+		ledger := *stmt.LedgerBalance * rate
+		rec.LedgerBalance = &ledger
+		if !stmt.LedgerDate.IsZero() {
+			rec.LedgerDate = stmt.LedgerDate.Format("2006-01-02")
+		}
+		rec.Difference = ledger - rec.ProjectedBalance
+		rec.Reconciled = absFloat(rec.Difference) < 1
+	}
+	return rec
+}
+
+// parseOFX reads both OFX 1.x (SGML, leaf elements without closing tags) and
+// OFX 2.x (XML) statements by scanning tags and their text content. Closing
+// tags of leaf elements are simply ignored, which makes one tokenizer work
+// for both flavours.
+func parseOFX(data []byte) (*ofxStatement, error) {
+	start := bytes.Index(bytes.ToUpper(data), []byte("<OFX>"))
+	if start < 0 {
+		return nil, errors.New("Not an OFX file")
+	}
+	body := string(data[start:])
+
+	stmt := &ofxStatement{Currency: "IDR"}
+	var current *ofxTransaction
+	inLedger := false
+
+	for pos := 0; pos < len(body); {
+		open := strings.IndexByte(body[pos:], '<')
+		if open < 0 {
+			break
+		}
+		open += pos
+		end := strings.IndexByte(body[open:], '>')
+		if end < 0 {
+			break
+		}
+		end += open
+
+		tag := strings.ToUpper(strings.TrimSpace(body[open+1 : end]))
+		next := strings.IndexByte(body[end+1:], '<')
+		if next < 0 {
+			next = len(body) - end - 1
+		}
+		value := strings.TrimSpace(html.UnescapeString(body[end+1 : end+1+next]))
+		pos = end + 1
+
+		if strings.HasPrefix(tag, "?") || strings.HasPrefix(tag, "!") || strings.HasSuffix(tag, "/") {
+			continue
+		}
+
+		switch tag {
+		case "STMTTRN":
+			current = &ofxTransaction{}
+			continue
+		case "/STMTTRN":
+			if current != nil {
+				stmt.Transactions = append(stmt.Transactions, *current)
+			}
+			current = nil
+			continue
+		case "LEDGERBAL":
+			inLedger = true
+			continue
+		case "/LEDGERBAL":
+			inLedger = false
+			continue
+		}
+		if strings.HasPrefix(tag, "/") || value == "" {
+			continue
+		}
+
+		switch {
+		case current != nil:
+			switch tag {
+			case "TRNTYPE":
+				current.Type = value
+			case "FITID":
+				current.FITID = value
+			case "DTPOSTED":
+				current.Posted, _ = parseOFXDate(value)
+			case "TRNAMT":
+				amount, err := parseOFXAmount(value)
+				if err != nil {
+					return nil, errors.New("Invalid TRNAMT: " + value)
+				}
+				current.Amount = amount
+			case "NAME", "PAYEE":
+				current.Name = value
+			case "MEMO":
+				current.Memo = value
+			}
+		case inLedger:
+			switch tag {
+			case "BALAMT":
+				if amount, err := parseOFXAmount(value); err == nil {
+					stmt.LedgerBalance = &amount
+				}
+			case "DTASOF":
+				stmt.LedgerDate, _ = parseOFXDate(value)
+			}
+		default:
+			switch tag {
+			case "CURDEF":
+				stmt.Currency = strings.ToUpper(value)
+			case "ACCTID":
+				stmt.AccountID = value
+			}
+		}
+	}
+
+	if len(stmt.Transactions) == 0 && stmt.LedgerBalance == nil {
+		return nil, errors.New("No statement transactions found in OFX file")
+	}
+	return stmt, nil
+}
+
+// parseOFXDate parses YYYYMMDD[HHMMSS[.XXX][[offset:TZ]]], keeping the date only
+func parseOFXDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, errors.New("invalid OFX date")
+	}
+	return time.Parse("20060102", value[:8])
+}
+
+func parseOFXAmount(value string) (float64, error) {
+	value = strings.ReplaceAll(value, " ", "")
+	if !strings.Contains(value, ".") {
+		value = strings.Replace(value, ",", ".", 1)
+	}
+	return strconv.ParseFloat(value, 64)
+}
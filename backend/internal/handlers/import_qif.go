@@ -0,0 +1,531 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/pkg/middleware"
+)
+
+// qifFile is a parsed QIF export. Only bank, cash and credit-card registers
+// are imported; records of other types are counted and skipped.
+type qifFile struct {
+	Accounts      []*qifAccount
+	CategoryTypes map[string]string // From !Type:Cat lists: name -> income/expense
+	Skipped       int
+}
+
+type qifAccount struct {
+	Name string // Empty for a register without an !Account header
+	Type string // bank, cash, ccard
+	Rows []ImportRow
+}
+
+// qifRecord holds the raw fields of one transaction up to its "^" terminator
+type qifRecord struct {
+	Date, Amount, Payee, Memo, Category, Number string
+	Splits                                      []qifSplit
+}
+
+type qifSplit struct {
+	Category, Memo, Amount string
+}
+
+type QIFAccountPreview struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+type QIFPreviewResponse struct {
+	Accounts         []QIFAccountPreview `json:"accounts"`
+	Categories       []CategoryMapping   `json:"categories"`
+	Rows             []ImportRow         `json:"rows"` // First rows of the file
+	TotalRows        int                 `json:"total_rows"`
+	InvalidRows      int                 `json:"invalid_rows"`
+	SkippedRecords   int                 `json:"skipped_records"` // Investment, memorized and other unsupported records
+	DateFormat       string              `json:"date_format"`     // mdy, dmy
+	DecimalSeparator string              `json:"decimal_separator"`
+}
+
+type QIFCommitResponse struct {
+	ImportCommitResponse
+	CategoriesCreated []models.Category `json:"categories_created"`
+}
+
+// PreviewQIF parses a QIF file and proposes a mapping from its categories to
+// the user's categories. Unknown categories are marked for creation.
+// Nothing is written.
+func (h *ImportHandler) PreviewQIF(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	data, err := readImportFile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	qif, dateFormat, decimal, err := parseQIFUpload(data, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	overrides, err := formJSONMap(r, "category_map")
+	if err != nil {
+		http.Error(w, "Invalid category_map", http.StatusBadRequest)
+		return
+	}
+
+	categories, _ := h.categoryRepo.FindByUserID(userID)
+
+	resp := QIFPreviewResponse{
+		Accounts:         []QIFAccountPreview{},
+		Categories:       qifCategoryMappings(qif, categories, overrides),
+		Rows:             []ImportRow{},
+		SkippedRecords:   qif.Skipped,
+		DateFormat:       dateFormat,
+		DecimalSeparator: decimal,
+	}
+	for _, account := range qif.Accounts {
+		resp.Accounts = append(resp.Accounts, QIFAccountPreview{Name: account.Name, Type: account.Type, Count: len(account.Rows)})
+		for _, row := range account.Rows {
+			if len(resp.Rows) < csvPreviewRows {
+				resp.Rows = append(resp.Rows, row)
+			}
+			if len(row.Errors) > 0 {
+				resp.InvalidRows++
+			}
+			resp.TotalRows++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CommitQIF imports a QIF file. category_map ({"QIF name": category_id})
+// overrides the proposed mapping, 0 creates a new category. Each QIF account
+// goes to the wallet in account_map ({"QIF account": wallet_id}), falling
+// back to wallet_id.
+func (h *ImportHandler) CommitQIF(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	data, err := readImportFile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	qif, _, _, err := parseQIFUpload(data, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	categoryMap, err := formJSONMap(r, "category_map")
+	if err != nil {
+		http.Error(w, "Invalid category_map", http.StatusBadRequest)
+		return
+	}
+	accountMap, err := formJSONMap(r, "account_map")
+	if err != nil {
+		http.Error(w, "Invalid account_map", http.StatusBadRequest)
+		return
+	}
+
+	// Resolve every target wallet before writing anything
+	defaultWalletID := formUint(r, "wallet_id")
+	walletIDs := make(map[*qifAccount]uint)
+	for _, account := range qif.Accounts {
+		walletID := defaultWalletID
+		if id, ok := accountMap[account.Name]; ok && id != 0 {
+			walletID = id
+		}
+		wallet, err := h.resolveWallet(userID, walletID)
+		if err != nil {
+			msg := err.Error()
+			if account.Name != "" {
+				msg = fmt.Sprintf("%s (account %q)", msg, account.Name)
+			}
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		walletIDs[account] = wallet.ID
+	}
+
+	invalid := 0
+	for _, account := range qif.Accounts {
+		invalid += countInvalid(account.Rows)
+	}
+	if invalid > 0 && r.FormValue("skip_invalid") != "true" {
+		http.Error(w, fmt.Sprintf("%d rows have validation errors", invalid), http.StatusUnprocessableEntity)
+		return
+	}
+
+	categories, _ := h.categoryRepo.FindByUserID(userID)
+	mappings := qifCategoryMappings(qif, categories, categoryMap)
+
+	categoryIDs, created, err := h.createMappedCategories(userID, mappings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var transactions []models.Transaction
+	skipped := 0
+	for _, account := range qif.Accounts {
+		for i := range account.Rows {
+			if id, ok := categoryIDs[account.Rows[i].CategoryName]; ok {
+				account.Rows[i].CategoryID = id
+			}
+		}
+		h.resolveCategories(userID, account.Rows, formUint(r, "default_expense_category_id"), formUint(r, "default_income_category_id"))
+
+		built, n := h.buildTransactions(userID, walletIDs[account], account.Rows)
+		transactions = append(transactions, built...)
+		skipped += n
+	}
+
+	if err := h.transactionRepo.CreateBatch(transactions); err != nil {
+		http.Error(w, "Error importing transactions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(QIFCommitResponse{
+		ImportCommitResponse: ImportCommitResponse{Imported: len(transactions), Skipped: skipped},
+		CategoriesCreated:    created,
+	})
+}
+
+// formJSONMap reads an optional {"name": id} JSON object from a form field
+func formJSONMap(r *http.Request, key string) (map[string]uint, error) {
+	m := make(map[string]uint)
+	if raw := r.FormValue(key); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &m); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// qifCategoryMappings maps the categories of every register, with the
+// income/expense types of the file's category list taking precedence
+func qifCategoryMappings(qif *qifFile, categories []models.Category, overrides map[string]uint) []CategoryMapping {
+	var rows []ImportRow
+	for _, account := range qif.Accounts {
+		rows = append(rows, account.Rows...)
+	}
+	return categoryMappings(rows, qif.CategoryTypes, categories, overrides)
+}
+
+// parseQIFUpload decodes and parses an uploaded QIF file. date_format (mdy,
+// dmy) and decimal_separator form fields override detection.
+func parseQIFUpload(data []byte, r *http.Request) (*qifFile, string, string, error) {
+	text, _, err := decodeStatementText(data, r.FormValue("encoding"))
+	if err != nil {
+		return nil, "", "", err
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	var dates, amounts []string
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		switch line[0] {
+		case 'D':
+			dates = append(dates, line[1:])
+		case 'T', 'U', '$':
+			amounts = append(amounts, line[1:])
+		}
+	}
+
+	dateFormat := r.FormValue("date_format")
+	if dateFormat != "mdy" && dateFormat != "dmy" {
+		dateFormat = detectQIFDateFormat(dates)
+	}
+	decimal := r.FormValue("decimal_separator")
+	if decimal != "." && decimal != "," {
+		decimal = detectDecimalSeparator(amounts)
+	}
+
+	qif, err := parseQIF(lines, dateFormat == "dmy", decimal)
+	return qif, dateFormat, decimal, err
+}
+
+// parseQIF reads the registers of a QIF file. Split lines (S/E/$) become
+// separate rows; transfers ("L[Account]") get the Transfer category.
+func parseQIF(lines []string, dayFirst bool, decimal string) (*qifFile, error) {
+	qif := &qifFile{CategoryTypes: make(map[string]string)}
+
+	section := ""
+	accountName := ""
+	var account *qifAccount
+	var rec qifRecord
+	var fields map[byte]string // !Account and !Type:Cat records
+	dirty := false
+	line := 0
+
+	for i, raw := range lines {
+		text := strings.TrimSpace(raw)
+		if text == "" {
+			continue
+		}
+
+		if text[0] == '!' {
+			header := strings.ToLower(text)
+			switch {
+			case strings.HasPrefix(header, "!option:"), strings.HasPrefix(header, "!clear:"):
+				continue
+			case header == "!account":
+				section = "account"
+			case strings.HasPrefix(header, "!type:"):
+				section = qifSectionType(strings.TrimSpace(header[len("!type:"):]))
+				if section == "bank" || section == "cash" || section == "ccard" {
+					account = &qifAccount{Name: accountName, Type: section}
+					qif.Accounts = append(qif.Accounts, account)
+				}
+			default:
+				section = "unsupported"
+			}
+			rec, fields, dirty = qifRecord{}, make(map[byte]string), false
+			continue
+		}
+
+		code, value := text[0], strings.TrimSpace(text[1:])
+		if code == '^' {
+			switch section {
+			case "bank", "cash", "ccard":
+				if dirty {
+					line++
+					account.Rows = append(account.Rows, qifRows(rec, line, dayFirst, decimal)...)
+				}
+			case "account":
+				if name := fields['N']; name != "" {
+					accountName = name
+				}
+			case "cat":
+				if name := fields['N']; name != "" {
+					if _, ok := fields['I']; ok {
+						qif.CategoryTypes[name] = "income"
+					} else {
+						qif.CategoryTypes[name] = "expense"
+					}
+				}
+			case "unsupported":
+				if dirty {
+					qif.Skipped++
+				}
+			}
+			rec, fields, dirty = qifRecord{}, make(map[byte]string), false
+			continue
+		}
+
+		dirty = true
+		switch section {
+		case "bank", "cash", "ccard":
+			switch code {
+			case 'D':
+				rec.Date = value
+			case 'T', 'U':
+				rec.Amount = value
+			case 'P':
+				rec.Payee = value
+			case 'M':
+				rec.Memo = value
+			case 'L':
+				rec.Category = value
+			case 'N':
+				rec.Number = value
+			case 'S':
+				rec.Splits = append(rec.Splits, qifSplit{Category: value})
+			case 'E':
+				if n := len(rec.Splits); n > 0 {
+					rec.Splits[n-1].Memo = value
+				}
+			case '$':
+				if n := len(rec.Splits); n > 0 {
+					rec.Splits[n-1].Amount = value
+				}
+			}
+		case "account", "cat":
+			fields[code] = value
+		case "":
+			return nil, fmt.Errorf("Line %d: data before any !Type header, not a QIF file", i+1)
+		}
+	}
+
+	// A final record without "^" is accepted
+	if dirty && account != nil && (section == "bank" || section == "cash" || section == "ccard") {
+		line++
+		account.Rows = append(account.Rows, qifRows(rec, line, dayFirst, decimal)...)
+	}
+
+	if len(qif.Accounts) == 0 {
+		return nil, errors.New("No bank, cash or credit card register found in QIF file")
+	}
+	return qif, nil
+}
+
+func qifSectionType(t string) string {
+	switch t {
+	case "bank":
+		return "bank"
+	case "cash":
+		return "cash"
+	case "ccard":
+		return "ccard"
+	case "cat":
+		return "cat"
+	}
+	return "unsupported" // invst, memorized, class, oth a, oth l, invoice, ...
+}
+
+// qifRows turns a transaction record into one row, or one row per split
+func qifRows(rec qifRecord, line int, dayFirst bool, decimal string) []ImportRow {
+	base := ImportRow{Line: line, Description: rec.Payee, Notes: rec.Memo, Currency: "IDR"}
+	if base.Description == "" {
+		base.Description, base.Notes = rec.Memo, ""
+	}
+	if rec.Number != "" {
+		base.Notes = strings.TrimSpace(base.Notes + " #" + rec.Number)
+	}
+
+	if rec.Date == "" {
+		base.addError("Missing date")
+	} else if date, err := parseQIFDate(rec.Date, dayFirst); err != nil {
+		base.addError("Invalid date: " + rec.Date)
+	} else {
+		base.setDate(date)
+	}
+
+	if len(rec.Splits) == 0 {
+		row := base
+		row.Errors = append([]string(nil), base.Errors...)
+		applyQIFCategory(&row, rec.Category)
+		applyQIFAmount(&row, rec.Amount, decimal)
+		return []ImportRow{row}
+	}
+
+	rows := make([]ImportRow, 0, len(rec.Splits))
+	for _, split := range rec.Splits {
+		row := base
+		row.Errors = append([]string(nil), base.Errors...)
+		if split.Memo != "" {
+			row.Notes = split.Memo
+		}
+		applyQIFCategory(&row, split.Category)
+		applyQIFAmount(&row, split.Amount, decimal)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// applyQIFCategory strips the "/Class" suffix and maps "[Account]" transfers
+func applyQIFCategory(row *ImportRow, category string) {
+	if i := strings.Index(category, "/"); i >= 0 {
+		category = category[:i]
+	}
+	category = strings.TrimSpace(category)
+
+	if strings.HasPrefix(category, "[") && strings.HasSuffix(category, "]") {
+		// Keep the other account in the notes so an export writes it back as a transfer
+		row.CategoryName = transferCategory
+		row.Notes = strings.TrimSpace(category + " " + row.Notes)
+		return
+	}
+	row.CategoryName = category
+}
+
+func applyQIFAmount(row *ImportRow, raw, decimal string) {
+	if raw == "" {
+		row.addError("Missing amount")
+		return
+	}
+	value, _, err := parseStatementAmount(raw, decimal)
+	if err != nil {
+		row.addError("Invalid amount: " + raw)
+		return
+	}
+	if value == 0 {
+		row.addError("Amount is zero")
+		return
+	}
+	if value < 0 {
+		row.Type = "expense"
+	} else {
+		row.Type = "income"
+	}
+	row.Amount = absFloat(value)
+}
+
+// detectQIFDateFormat defaults to Quicken's month-first dates unless a day
+// above 12 shows up in the first position
+func detectQIFDateFormat(dates []string) string {
+	for _, d := range dates {
+		parts := qifDateParts(d)
+		if len(parts) == 3 && len(parts[0]) < 4 {
+			if n, _ := strconv.Atoi(parts[0]); n > 12 {
+				return "dmy"
+			}
+		}
+	}
+	return "mdy"
+}
+
+func qifDateParts(value string) []string {
+	value = strings.ReplaceAll(strings.TrimSpace(value), " ", "")
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == '/' || r == '\'' || r == '-' || r == '.'
+	})
+}
+
+// parseQIFDate accepts the usual Quicken forms: 1/5/24, 01/05'2024,
+// 01/05/2024, 2024-01-05 and day-first variants when dayFirst is set
+func parseQIFDate(value string, dayFirst bool) (time.Time, error) {
+	parts := qifDateParts(value)
+	if len(parts) != 3 {
+		return time.Time{}, errors.New("invalid QIF date")
+	}
+	nums := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return time.Time{}, errors.New("invalid QIF date")
+		}
+		nums[i] = n
+	}
+
+	var year, month, day int
+	switch {
+	case len(parts[0]) == 4:
+		year, month, day = nums[0], nums[1], nums[2]
+	case dayFirst:
+		day, month, year = nums[0], nums[1], nums[2]
+	default:
+		month, day, year = nums[0], nums[1], nums[2]
+	}
+	if year < 100 {
+		if year < 70 {
+			year += 2000
+		} else {
+			year += 1900
+		}
+	}
+
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if t.Month() != time.Month(month) || t.Day() != day {
+		return time.Time{}, errors.New("invalid QIF date")
+	}
+	return t, nil
+}
@@ -3,26 +3,31 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
 
-	"github.com/go-chi/chi/v5"
 	"github.com/money-management/backend/internal/models"
 	"github.com/money-management/backend/internal/repository"
+	"github.com/money-management/backend/internal/scheduler"
 	"github.com/money-management/backend/pkg/middleware"
+	"github.com/money-management/backend/pkg/rrule"
 )
 
 type RecurringHandler struct {
 	recurringRepo   *repository.RecurringRepository
 	transactionRepo *repository.TransactionRepository
 	walletRepo      *repository.WalletRepository
+	categoryRepo    *repository.CategoryRepository
+	scheduler       *scheduler.RecurringScheduler
 }
 
-func NewRecurringHandler(recurringRepo *repository.RecurringRepository, transactionRepo *repository.TransactionRepository, walletRepo *repository.WalletRepository) *RecurringHandler {
+func NewRecurringHandler(recurringRepo *repository.RecurringRepository, transactionRepo *repository.TransactionRepository, walletRepo *repository.WalletRepository, categoryRepo *repository.CategoryRepository, scheduler *scheduler.RecurringScheduler) *RecurringHandler {
 	return &RecurringHandler{
 		recurringRepo:   recurringRepo,
 		transactionRepo: transactionRepo,
 		walletRepo:      walletRepo,
+		categoryRepo:    categoryRepo,
+		scheduler:       scheduler,
 	}
 }
 
@@ -30,10 +35,28 @@ type CreateRecurringRequest struct {
 	WalletID    uint    `json:"wallet_id"`
 	CategoryID  uint    `json:"category_id"`
 	Amount      float64 `json:"amount"`
-	Type        string  `json:"type"`
+	Type        string  `json:"type"` // income, expense, transfer
 	Description string  `json:"description"`
 	Frequency   string  `json:"frequency"` // daily, weekly, monthly, yearly
 	StartDate   string  `json:"start_date"`
+	// Destination of a transfer
+	TargetWalletID *uint `json:"target_wallet_id"`
+	// fixed (default), percent_balance to post amount percent of the
+	// wallet's balance at the time, e.g. 20 to sweep a fifth of it, or
+	// estimated to post an estimate from the last actual amounts, starting
+	// from amount
+	AmountType string `json:"amount_type"`
+	// For estimated amounts: average (default), median or last, over the
+	// last estimate_window (default 3) occurrences
+	EstimateMethod string `json:"estimate_method"`
+	EstimateWindow int    `json:"estimate_window"`
+	// RRULE-like schedule, e.g. "FREQ=MONTHLY;BYMONTHDAY=25;ADJUST=PREV";
+	// replaces frequency when set
+	RRule string `json:"rrule"`
+	// all, latest or review; defaults to all
+	BacklogPolicy string `json:"backlog_policy"`
+	// Queue due occurrences for confirmation instead of posting them
+	RequireConfirmation bool `json:"require_confirmation"`
 }
 
 func (h *RecurringHandler) Create(w http.ResponseWriter, r *http.Request) {
@@ -45,11 +68,39 @@ func (h *RecurringHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 	userID := middleware.GetUserID(r)
 
+	if req.BacklogPolicy == "" {
+		req.BacklogPolicy = "all"
+	}
+	if !validBacklogPolicy(req.BacklogPolicy) {
+		http.Error(w, "Invalid backlog policy, use all, latest or review", http.StatusBadRequest)
+		return
+	}
+
+	var rule rrule.Rule
+	var err error
+	if req.RRule != "" {
+		rule, err = rrule.Parse(req.RRule)
+		if err != nil {
+			http.Error(w, "Invalid recurrence rule: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if rule, err = rrule.FromFrequency(req.Frequency); err != nil {
+		http.Error(w, "Invalid frequency, use daily, weekly, monthly or yearly", http.StatusBadRequest)
+		return
+	}
+
 	startDate, err := time.Parse("2006-01-02", req.StartDate)
 	if err != nil {
 		startDate = time.Now()
 	}
 
+	// The first run is the first occurrence on or after the start date
+	firstRun, ok := rule.After(startDate, startDate.Add(-time.Nanosecond))
+	if !ok {
+		http.Error(w, "Recurrence rule has no occurrences", http.StatusBadRequest)
+		return
+	}
+
 	recurring := &models.RecurringTransaction{
 		UserID:      userID,
 		WalletID:    req.WalletID,
@@ -57,10 +108,23 @@ func (h *RecurringHandler) Create(w http.ResponseWriter, r *http.Request) {
 		Amount:      req.Amount,
 		Type:        req.Type,
 		Description: req.Description,
-		Frequency:   req.Frequency,
+		Frequency:   strings.ToLower(rule.Freq),
+		RRule:       rule.String(),
 		StartDate:   startDate,
-		NextRunDate: startDate, // First run is start date
+		NextRunDate: firstRun,
 		IsActive:    true,
+
+		BacklogPolicy:  req.BacklogPolicy,
+		TargetWalletID: req.TargetWalletID,
+		AmountType:     req.AmountType,
+		EstimateMethod: req.EstimateMethod,
+		EstimateWindow: req.EstimateWindow,
+
+		RequireConfirmation: req.RequireConfirmation,
+	}
+	if err := h.checkAction(recurring); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	if err := h.recurringRepo.Create(recurring); err != nil {
@@ -68,8 +132,9 @@ func (h *RecurringHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Trigger processing to execute immediately if start date is today or past
-	go h.ProcessPending(userID)
+	// Post it right away if it starts today or in the past, instead of
+	// waiting for the next scheduled run
+	go h.scheduler.RunForUser(userID)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(recurring)
@@ -78,93 +143,32 @@ func (h *RecurringHandler) Create(w http.ResponseWriter, r *http.Request) {
 func (h *RecurringHandler) List(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
 
-	// Ensure pending transactions are processed so the list and balance are up to date
-	h.ProcessPending(userID)
-
 	recurrings, err := h.recurringRepo.FindByUserID(userID)
 	if err != nil {
 		http.Error(w, "Error fetching recurring transactions", http.StatusInternalServerError)
 		return
 	}
+	for i := range recurrings {
+		if err := h.recurringRepo.FillEstimate(&recurrings[i]); err != nil {
+			http.Error(w, "Error estimating amounts", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(recurrings)
 }
 
 func (h *RecurringHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	idStr := chi.URLParam(r, "id")
-	id, err := strconv.ParseUint(idStr, 10, 32)
-	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+	recurring, ok := h.findOwned(w, r)
+	if !ok {
 		return
 	}
 
-	if err := h.recurringRepo.Delete(uint(id)); err != nil {
+	if err := h.recurringRepo.Delete(recurring.ID); err != nil {
 		http.Error(w, "Error deleting recurring transaction", http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
-
-// ProcessPending checks and executes due transactions
-// This should be called when dashboard loads
-func (h *RecurringHandler) ProcessPending(userID uint) error {
-	now := time.Now()
-	pending, err := h.recurringRepo.FindPending(userID, now)
-	if err != nil {
-		return err
-	}
-
-	for _, item := range pending {
-		// Create real transaction
-		tx := &models.Transaction{
-			UserID:      item.UserID,
-			WalletID:    item.WalletID,
-			CategoryID:  item.CategoryID,
-			Amount:      item.Amount,
-			Type:        item.Type,
-			Description: item.Description + " (Otomatis)",
-			Date:        time.Now(), // Record as today
-		}
-
-		if err := h.transactionRepo.Create(tx); err != nil {
-			continue // Skip if fail, retry next time
-		}
-
-		// Update Wallet Balance
-		h.walletRepo.UpdateBalance(item.WalletID, item.Amount, item.Type == "income")
-
-		// Update Next Run Date
-		nextRun := item.NextRunDate
-		switch item.Frequency {
-		case "daily":
-			nextRun = nextRun.AddDate(0, 0, 1)
-		case "weekly":
-			nextRun = nextRun.AddDate(0, 0, 7)
-		case "monthly":
-			nextRun = nextRun.AddDate(0, 1, 0)
-		case "yearly":
-			nextRun = nextRun.AddDate(1, 0, 0)
-		}
-
-		// If next run is still in past (e.g. missed multiple), catch up or set to future?
-		// For simplicity, just set to next logical slot.
-		// If missed months of payments, this loop only processes ONE occurrence per call.
-		// Ideally we valid loop until nextRun > now, but to avoid spam, we just advance once or set to future.
-		// Let's safe loop: advance until > now if we want to skip missed, or just +1 period to create backlog queue.
-		// Let's just +1 period for now (simple logic). User logs in daily = fine. If user away for month, they might want 30 entries or just 1?
-		// Usually subscriptions charge every month. If I miss log in, I still physically paid. So backlog is better.
-		// But let's verify NextRunDate isn't growing infinitely if stuck.
-
-		// Wait, if I set NextRunDate = NextRunDate + 1 month, and NextRunDate is still < Now, it will be picked up Next time `ProcessPending` is called.
-		// So `ProcessPending` needs a loop internally or just process one at a time per request.
-		// Processing ALL due occurrences in one go is better.
-
-		// Update item
-		item.LastRunDate = &now
-		item.NextRunDate = nextRun
-		h.recurringRepo.Update(&item)
-	}
-	return nil
-}
@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/pkg/middleware"
+	"github.com/money-management/backend/pkg/rrule"
+)
+
+// Limits of the recurring pattern detection
+const (
+	detectLookbackMonths = 24
+	detectMinOccurrences = 3
+	detectMinRegularity  = 0.75 // Share of gaps that fit the period
+	detectMinSteadiness  = 0.6  // Share of amounts near the typical one
+	detectAmountSpread   = 0.25 // How far from the typical amount still counts as near
+	detectPriceIncrease  = 0.02 // Rise of the last amount that counts as a price increase
+)
+
+// detectPeriod is a repeat interval we look for, with how far off a gap may be
+type detectPeriod struct {
+	frequency string
+	interval  int
+	days      float64
+	tolerance float64
+}
+
+var detectPeriods = []detectPeriod{
+	{"weekly", 1, 7, 1.5},
+	{"weekly", 2, 14, 2.5},
+	{"monthly", 1, 30.44, 5},
+	{"monthly", 3, 91.31, 10},
+	{"monthly", 6, 182.62, 15},
+	{"yearly", 1, 365.25, 20},
+}
+
+// RecurringSuggestion is a repeating payment or income found in the history
+type RecurringSuggestion struct {
+	Payee            string  `json:"payee"`       // Normalized description the transactions share
+	Description      string  `json:"description"` // As last booked
+	Type             string  `json:"type"`
+	WalletID         uint    `json:"wallet_id"`
+	CategoryID       uint    `json:"category_id"`
+	Frequency        string  `json:"frequency"`
+	Interval         int     `json:"interval"` // e.g. 3 for quarterly
+	TypicalAmount    float64 `json:"typical_amount"`
+	LastAmount       float64 `json:"last_amount"`
+	Occurrences      int     `json:"occurrences"`
+	FirstDate        string  `json:"first_date"`
+	LastDate         string  `json:"last_date"`
+	NextExpectedDate string  `json:"next_expected_date"`
+	Confidence       float64 `json:"confidence"` // 0 to 1
+
+	// The last amount is above what was paid before, e.g. a subscription got
+	// more expensive
+	PriceIncreased bool    `json:"price_increased"`
+	PreviousAmount float64 `json:"previous_amount,omitempty"`
+	// The next expected date is well past, so it seems to have stopped
+	Stopped bool `json:"stopped"`
+	// Rule that already tracks it, if any
+	RecurringID *uint `json:"recurring_id,omitempty"`
+	// Body for POST /recurring to track it from the next expected date
+	Rule CreateRecurringRequest `json:"rule"`
+}
+
+// Suggestions scans the transaction history for payees that repeat at a
+// regular interval with a steady amount and proposes recurring rules for
+// them, flagging price increases and payments that seem to have stopped
+func (h *RecurringHandler) Suggestions(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	now := time.Now()
+	transactions, err := h.transactionRepo.FindRecurringCandidates(userID, now.AddDate(0, -detectLookbackMonths, 0))
+	if err != nil {
+		http.Error(w, "Error fetching transactions", http.StatusInternalServerError)
+		return
+	}
+	rules, err := h.recurringRepo.FindByUserID(userID)
+	if err != nil {
+		http.Error(w, "Error fetching recurring transactions", http.StatusInternalServerError)
+		return
+	}
+
+	suggestions := detectRecurring(transactions, rules, now)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suggestions)
+}
+
+// detectRecurring groups transactions, oldest first, by payee and type and
+// keeps the groups that repeat regularly, best matches first
+func detectRecurring(transactions []models.Transaction, rules []models.RecurringTransaction, now time.Time) []RecurringSuggestion {
+	groups := make(map[string][]models.Transaction)
+	var keys []string
+	for _, t := range transactions {
+		payee := normalizePayee(t.Description)
+		if payee == "" {
+			continue
+		}
+		key := t.Type + "|" + payee
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], t)
+	}
+
+	tracked := make(map[string]uint)
+	for _, rule := range rules {
+		tracked[rule.Type+"|"+normalizePayee(rule.Description)] = rule.ID
+	}
+
+	suggestions := []RecurringSuggestion{}
+	for _, key := range keys {
+		suggestion, ok := detectGroup(groups[key], now)
+		if !ok {
+			continue
+		}
+		suggestion.Payee = key[strings.Index(key, "|")+1:]
+		if id, ok := tracked[key]; ok {
+			suggestion.RecurringID = &id
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].Confidence > suggestions[j].Confidence
+	})
+	return suggestions
+}
+
+// detectGroup checks whether one payee's transactions repeat
+func detectGroup(group []models.Transaction, now time.Time) (RecurringSuggestion, bool) {
+	// One per day, so a split payment doesn't look like a short gap
+	var items []models.Transaction
+	for _, t := range group {
+		if n := len(items); n > 0 && sameDay(items[n-1].Date, t.Date) {
+			items[n-1].Amount += t.Amount
+			continue
+		}
+		items = append(items, t)
+	}
+
+	period, ok := findPeriod(items)
+	if !ok {
+		return RecurringSuggestion{}, false
+	}
+
+	amounts := make([]float64, len(items))
+	for i, t := range items {
+		amounts[i] = t.Amount
+	}
+	typical := median(amounts)
+	near := 0
+	for _, a := range amounts {
+		if math.Abs(a-typical) <= typical*detectAmountSpread {
+			near++
+		}
+	}
+	steadiness := float64(near) / float64(len(amounts))
+	if typical <= 0 || steadiness < detectMinSteadiness {
+		return RecurringSuggestion{}, false
+	}
+
+	first, last := items[0], items[len(items)-1]
+	rule := detectRule(items, period)
+	next, ok := rule.After(last.Date, last.Date)
+	if !ok {
+		return RecurringSuggestion{}, false
+	}
+
+	suggestion := RecurringSuggestion{
+		Description:      strings.TrimSpace(strings.TrimSuffix(last.Description, "(Otomatis)")),
+		Type:             last.Type,
+		WalletID:         last.WalletID,
+		CategoryID:       last.CategoryID,
+		Frequency:        period.frequency,
+		Interval:         period.interval,
+		TypicalAmount:    typical,
+		LastAmount:       last.Amount,
+		Occurrences:      len(items),
+		FirstDate:        first.Date.Format("2006-01-02"),
+		LastDate:         last.Date.Format("2006-01-02"),
+		NextExpectedDate: next.Format("2006-01-02"),
+		// More history makes a pattern more convincing, up to six payments
+		Confidence: math.Round(period.regularity*steadiness*math.Min(1, float64(len(items))/6)*100) / 100,
+	}
+
+	previous := median(amounts[:len(amounts)-1])
+	if last.Amount > previous*(1+detectPriceIncrease) {
+		suggestion.PriceIncreased = true
+		suggestion.PreviousAmount = previous
+	}
+
+	// Overdue by half a period, at least a few days
+	grace := time.Duration(math.Max(3, period.days/2)*24) * time.Hour
+	suggestion.Stopped = now.After(next.Add(grace))
+
+	suggestion.Rule = CreateRecurringRequest{
+		WalletID:    last.WalletID,
+		CategoryID:  last.CategoryID,
+		Amount:      last.Amount,
+		Type:        last.Type,
+		Description: suggestion.Description,
+		Frequency:   period.frequency,
+		StartDate:   suggestion.NextExpectedDate,
+		RRule:       rule.String(),
+	}
+	return suggestion, true
+}
+
+type foundPeriod struct {
+	detectPeriod
+	regularity float64
+}
+
+// findPeriod matches the median gap between the items to a period and
+// checks that enough gaps fit it; a gap of two or three periods, e.g. a
+// skipped month, still fits
+func findPeriod(items []models.Transaction) (foundPeriod, bool) {
+	if len(items) < 2 {
+		return foundPeriod{}, false
+	}
+	gaps := make([]float64, len(items)-1)
+	for i := 1; i < len(items); i++ {
+		gaps[i-1] = items[i].Date.Sub(items[i-1].Date).Hours() / 24
+	}
+	gap := median(gaps)
+
+	for _, p := range detectPeriods {
+		if math.Abs(gap-p.days) > p.tolerance {
+			continue
+		}
+		// Yearly payments take long to repeat, two are enough
+		if len(items) < detectMinOccurrences && p.frequency != "yearly" {
+			return foundPeriod{}, false
+		}
+		fit := 0
+		for _, g := range gaps {
+			k := math.Max(1, math.Round(g/p.days))
+			if k <= 3 && math.Abs(g-k*p.days) <= p.tolerance*k {
+				fit++
+			}
+		}
+		regularity := float64(fit) / float64(len(gaps))
+		if regularity < detectMinRegularity {
+			return foundPeriod{}, false
+		}
+		return foundPeriod{detectPeriod: p, regularity: regularity}, true
+	}
+	return foundPeriod{}, false
+}
+
+// detectRule builds the schedule the items follow: the usual weekday for
+// weekly ones, the usual day of the month, or its last day, for monthly ones
+func detectRule(items []models.Transaction, period foundPeriod) rrule.Rule {
+	rule := rrule.Rule{Interval: period.interval}
+	switch period.frequency {
+	case "weekly":
+		rule.Freq = rrule.Weekly
+		counts := make(map[time.Weekday]int)
+		usual := items[len(items)-1].Date.Weekday()
+		for _, t := range items {
+			counts[t.Date.Weekday()]++
+			if counts[t.Date.Weekday()] > counts[usual] {
+				usual = t.Date.Weekday()
+			}
+		}
+		rule.ByDay = []rrule.WeekdayNum{{Weekday: usual}}
+	case "monthly":
+		rule.Freq = rrule.Monthly
+		days := make([]float64, len(items))
+		monthEnd := true
+		for i, t := range items {
+			days[i] = float64(t.Date.Day())
+			if t.Date.AddDate(0, 0, 1).Day() != 1 {
+				monthEnd = false
+			}
+		}
+		if monthEnd {
+			rule.ByMonthDay = []int{-1}
+		} else {
+			rule.ByMonthDay = []int{int(median(days))}
+		}
+	default:
+		rule.Freq = rrule.Yearly
+	}
+	return rule
+}
+
+// normalizePayee reduces a description to the words that name the payee,
+// dropping numbers, dates and references, e.g. "NETFLIX.COM 12/03 #8841"
+// becomes "netflix com"
+func normalizePayee(description string) string {
+	description = strings.ReplaceAll(strings.ToLower(description), "(otomatis)", "")
+	var words []string
+	for _, word := range strings.FieldsFunc(description, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if strings.IndexFunc(word, unicode.IsDigit) >= 0 {
+			continue
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " ")
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func sameDay(a, b time.Time) bool {
+	return a.Format("2006-01-02") == b.Format("2006-01-02")
+}
@@ -0,0 +1,388 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/internal/repository"
+	"github.com/money-management/backend/pkg/middleware"
+	"github.com/money-management/backend/pkg/rrule"
+)
+
+type UpdateRecurringRequest struct {
+	WalletID            *uint    `json:"wallet_id"`
+	CategoryID          *uint    `json:"category_id"`
+	Amount              *float64 `json:"amount"`
+	Type                *string  `json:"type"`
+	TargetWalletID      *uint    `json:"target_wallet_id"`
+	AmountType          *string  `json:"amount_type"`
+	EstimateMethod      *string  `json:"estimate_method"`
+	EstimateWindow      *int     `json:"estimate_window"`
+	Description         *string  `json:"description"`
+	Frequency           *string  `json:"frequency"`
+	RRule               *string  `json:"rrule"`
+	StartDate           *string  `json:"start_date"`
+	BacklogPolicy       *string  `json:"backlog_policy"`
+	RequireConfirmation *bool    `json:"require_confirmation"`
+	// future (default) leaves the transactions posted so far as they are;
+	// all also applies the new amount, type, wallet, category and
+	// description to them. Schedule changes only ever affect the future.
+	Scope string `json:"scope"`
+}
+
+type UpdateRecurringResponse struct {
+	*models.RecurringTransaction
+	UpdatedTransactions int `json:"updated_transactions"`
+}
+
+// Get returns one recurring transaction
+func (h *RecurringHandler) Get(w http.ResponseWriter, r *http.Request) {
+	recurring, ok := h.findOwned(w, r)
+	if !ok {
+		return
+	}
+	if err := h.recurringRepo.FillEstimate(recurring); err != nil {
+		http.Error(w, "Error estimating amount", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recurring)
+}
+
+// Update edits a recurring transaction. Occurrences already handled keep
+// their dates; a new schedule takes over from the next run date.
+func (h *RecurringHandler) Update(w http.ResponseWriter, r *http.Request) {
+	recurring, ok := h.findOwned(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateRecurringRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = "future"
+	}
+	if req.Scope != "future" && req.Scope != "all" {
+		http.Error(w, "Invalid scope, use future or all", http.StatusBadRequest)
+		return
+	}
+	// What the rule posted so far, before this edit
+	wasTransfer := recurring.Type == "transfer"
+	wasFixed := recurring.AmountType == "" || recurring.AmountType == "fixed"
+
+	if req.WalletID != nil {
+		recurring.WalletID = *req.WalletID
+	}
+	if req.CategoryID != nil {
+		recurring.CategoryID = *req.CategoryID
+	}
+	if req.Amount != nil {
+		recurring.Amount = *req.Amount
+	}
+	if req.Type != nil {
+		recurring.Type = *req.Type
+	}
+	if req.TargetWalletID != nil {
+		recurring.TargetWalletID = req.TargetWalletID
+	}
+	if req.AmountType != nil {
+		recurring.AmountType = *req.AmountType
+	}
+	if req.EstimateMethod != nil {
+		recurring.EstimateMethod = *req.EstimateMethod
+	}
+	if req.EstimateWindow != nil {
+		recurring.EstimateWindow = *req.EstimateWindow
+	}
+	if err := h.checkAction(recurring); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Posted amounts of those differ on purpose, one amount can't replace
+	// them, and a transfer's two legs can't become one transaction or back
+	if req.Scope == "all" && (wasTransfer || !wasFixed || recurring.Type == "transfer" || recurring.AmountType != "fixed") {
+		http.Error(w, "Scope all isn't supported for transfers, percentage or estimated amounts", http.StatusBadRequest)
+		return
+	}
+	if req.Description != nil {
+		recurring.Description = *req.Description
+	}
+	if req.RequireConfirmation != nil {
+		recurring.RequireConfirmation = *req.RequireConfirmation
+	}
+	if req.BacklogPolicy != nil {
+		if !validBacklogPolicy(*req.BacklogPolicy) {
+			http.Error(w, "Invalid backlog policy, use all, latest or review", http.StatusBadRequest)
+			return
+		}
+		recurring.BacklogPolicy = *req.BacklogPolicy
+	}
+
+	if req.Frequency != nil || req.RRule != nil || req.StartDate != nil {
+		rule := repository.RecurrenceRule(recurring)
+		var err error
+		if req.RRule != nil && *req.RRule != "" {
+			if rule, err = rrule.Parse(*req.RRule); err != nil {
+				http.Error(w, "Invalid recurrence rule: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else if req.Frequency != nil {
+			if rule, err = rrule.FromFrequency(*req.Frequency); err != nil {
+				http.Error(w, "Invalid frequency, use daily, weekly, monthly or yearly", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.StartDate != nil {
+			startDate, err := time.Parse("2006-01-02", *req.StartDate)
+			if err != nil {
+				http.Error(w, "Invalid start date, use YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			recurring.StartDate = startDate
+		}
+
+		// Continue from the next run date, so handled occurrences don't come back
+		from := recurring.NextRunDate
+		if recurring.StartDate.After(from) {
+			from = recurring.StartDate
+		}
+		next, ok := rule.After(recurring.StartDate, from.Add(-time.Nanosecond))
+		if !ok {
+			http.Error(w, "Recurrence rule has no further occurrences", http.StatusBadRequest)
+			return
+		}
+		recurring.Frequency = strings.ToLower(rule.Freq)
+		recurring.RRule = rule.String()
+		recurring.NextRunDate = next
+	}
+
+	if err := h.recurringRepo.Update(recurring); err != nil {
+		http.Error(w, "Error updating recurring transaction", http.StatusInternalServerError)
+		return
+	}
+
+	updated := 0
+	if req.Scope == "all" {
+		var err error
+		if updated, err = h.recurringRepo.UpdateGenerated(recurring); err != nil {
+			http.Error(w, "Error updating generated transactions", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UpdateRecurringResponse{RecurringTransaction: recurring, UpdatedTransactions: updated})
+}
+
+// Pause stops posting a recurring transaction until it is resumed
+func (h *RecurringHandler) Pause(w http.ResponseWriter, r *http.Request) {
+	recurring, ok := h.findOwned(w, r)
+	if !ok {
+		return
+	}
+
+	recurring.IsActive = false
+	if err := h.recurringRepo.Update(recurring); err != nil {
+		http.Error(w, "Error pausing recurring transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recurring)
+}
+
+// Resume posts a paused recurring transaction again. Occurrences that fell
+// in the pause are not posted; it carries on from today.
+func (h *RecurringHandler) Resume(w http.ResponseWriter, r *http.Request) {
+	recurring, ok := h.findOwned(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	if recurring.NextRunDate.Before(today) {
+		next, ok := repository.NextOccurrence(recurring, today.Add(-time.Nanosecond))
+		if !ok {
+			http.Error(w, "Recurring transaction has ended", http.StatusConflict)
+			return
+		}
+		recurring.NextRunDate = next
+	}
+
+	recurring.IsActive = true
+	if err := h.recurringRepo.Update(recurring); err != nil {
+		http.Error(w, "Error resuming recurring transaction", http.StatusInternalServerError)
+		return
+	}
+	go h.scheduler.RunForUser(recurring.UserID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recurring)
+}
+
+// Skip skips the next occurrence without posting it
+func (h *RecurringHandler) Skip(w http.ResponseWriter, r *http.Request) {
+	h.handleNext(w, r, false)
+}
+
+// PostNow posts the next occurrence today, ahead of its date
+func (h *RecurringHandler) PostNow(w http.ResponseWriter, r *http.Request) {
+	h.handleNext(w, r, true)
+}
+
+func (h *RecurringHandler) handleNext(w http.ResponseWriter, r *http.Request, post bool) {
+	recurring, ok := h.findOwned(w, r)
+	if !ok {
+		return
+	}
+	if !recurring.IsActive {
+		http.Error(w, "Recurring transaction is paused or has ended", http.StatusConflict)
+		return
+	}
+
+	var recorded bool
+	var err error
+	if post {
+		recorded, err = h.recurringRepo.PostNow(recurring, time.Now())
+	} else {
+		recorded, err = h.recurringRepo.RecordOccurrence(recurring, "skipped", time.Now())
+	}
+	if err != nil {
+		http.Error(w, "Error updating recurring transaction", http.StatusInternalServerError)
+		return
+	}
+	if !recorded {
+		http.Error(w, "Occurrence was already handled", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recurring)
+}
+
+// History returns the handled occurrences of a recurring transaction with
+// the transactions it generated, newest first
+func (h *RecurringHandler) History(w http.ResponseWriter, r *http.Request) {
+	recurring, ok := h.findOwned(w, r)
+	if !ok {
+		return
+	}
+
+	occurrences, err := h.recurringRepo.FindHistory(recurring.ID)
+	if err != nil {
+		http.Error(w, "Error fetching history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(occurrences)
+}
+
+// findOwned loads the recurring transaction in the URL, writing the error
+// response when it doesn't exist or belongs to someone else
+func (h *RecurringHandler) findOwned(w http.ResponseWriter, r *http.Request) (*models.RecurringTransaction, bool) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	recurring, err := h.recurringRepo.FindByID(uint(id))
+	if err != nil {
+		http.Error(w, "Recurring transaction not found", http.StatusNotFound)
+		return nil, false
+	}
+	if recurring.UserID != middleware.GetUserID(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return recurring, true
+}
+
+// checkAction validates what a rule posts. Transfers need a second wallet of
+// the user's and are booked in the transfer category.
+func (h *RecurringHandler) checkAction(recurring *models.RecurringTransaction) error {
+	switch recurring.AmountType {
+	case "":
+		recurring.AmountType = "fixed"
+		fallthrough
+	case "fixed":
+		if recurring.Amount <= 0 {
+			return errors.New("amount must be positive")
+		}
+	case "percent_balance":
+		if recurring.Amount <= 0 || recurring.Amount > 100 {
+			return errors.New("percentage must be between 0 and 100")
+		}
+	case "estimated":
+		if recurring.Amount <= 0 {
+			return errors.New("amount, the first estimate, must be positive")
+		}
+	default:
+		return errors.New("invalid amount type, use fixed, percent_balance or estimated")
+	}
+
+	switch recurring.EstimateMethod {
+	case "":
+		recurring.EstimateMethod = "average"
+	case "average", "median", "last":
+	default:
+		return errors.New("invalid estimate method, use average, median or last")
+	}
+	if recurring.EstimateWindow == 0 {
+		recurring.EstimateWindow = 3
+	}
+	if recurring.EstimateWindow < 1 || recurring.EstimateWindow > 24 {
+		return errors.New("estimate window must be between 1 and 24 occurrences")
+	}
+
+	if wallet, err := h.walletRepo.FindByID(recurring.WalletID); err != nil || wallet.UserID != recurring.UserID {
+		return errors.New("wallet not found or access denied")
+	}
+
+	switch recurring.Type {
+	case "income", "expense":
+		if category, err := h.categoryRepo.FindByID(recurring.CategoryID); err != nil || category.UserID != recurring.UserID {
+			return errors.New("category not found or access denied")
+		}
+		recurring.TargetWalletID = nil
+	case "transfer":
+		if recurring.TargetWalletID == nil {
+			return errors.New("transfers need a target wallet")
+		}
+		if *recurring.TargetWalletID == recurring.WalletID {
+			return errors.New("source and target wallets must be different")
+		}
+		if target, err := h.walletRepo.FindByID(*recurring.TargetWalletID); err != nil || target.UserID != recurring.UserID {
+			return errors.New("target wallet not found or access denied")
+		}
+		category, err := h.categoryRepo.FindOrCreateTransfer(recurring.UserID)
+		if err != nil {
+			return errors.New("error finding the transfer category")
+		}
+		recurring.CategoryID = category.ID
+	default:
+		return errors.New("invalid type, use income, expense or transfer")
+	}
+	return nil
+}
+
+func validBacklogPolicy(policy string) bool {
+	switch policy {
+	case "all", "latest", "review":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/internal/repository"
+	"github.com/money-management/backend/pkg/middleware"
+)
+
+type ConfirmOccurrenceRequest struct {
+	Amount *float64 `json:"amount"` // Defaults to the rule's amount or estimate
+	Date   *string  `json:"date"`   // YYYY-MM-DD, defaults to the scheduled date
+}
+
+type OccurrenceAmountRequest struct {
+	Amount float64 `json:"amount"`
+}
+
+type SnoozeOccurrenceRequest struct {
+	Until string `json:"until"` // YYYY-MM-DD; takes precedence over days
+	Days  int    `json:"days"`  // Defaults to 1
+}
+
+// ListReview returns the missed occurrences waiting for the user to post or
+// skip them
+func (h *RecurringHandler) ListReview(w http.ResponseWriter, r *http.Request) {
+	h.listQueue(w, r, "review")
+}
+
+// ListPending returns the due occurrences of rules that need confirmation.
+// Snoozed ones are left out until their snooze ends, unless
+// include_snoozed=true.
+func (h *RecurringHandler) ListPending(w http.ResponseWriter, r *http.Request) {
+	h.listQueue(w, r, "pending")
+}
+
+func (h *RecurringHandler) listQueue(w http.ResponseWriter, r *http.Request, status string) {
+	userID := middleware.GetUserID(r)
+	includeSnoozed := r.URL.Query().Get("include_snoozed") == "true"
+
+	occurrences, err := h.recurringRepo.FindQueuedOccurrences(userID, status, includeSnoozed, time.Now())
+	if err != nil {
+		http.Error(w, "Error fetching occurrences", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(occurrences)
+}
+
+// ConfirmOccurrence posts a queued occurrence, on its scheduled date and
+// with the rule's amount unless the request changes them. Only now does the
+// wallet balance change.
+func (h *RecurringHandler) ConfirmOccurrence(w http.ResponseWriter, r *http.Request) {
+	occurrence, ok := h.findOwnedOccurrence(w, r)
+	if !ok {
+		return
+	}
+
+	var req ConfirmOccurrenceRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	edit := &repository.OccurrenceEdit{Amount: req.Amount}
+	if req.Amount != nil && *req.Amount <= 0 {
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.Date != nil {
+		date, err := time.Parse("2006-01-02", *req.Date)
+		if err != nil {
+			http.Error(w, "Invalid date, use YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		edit.Date = &date
+	}
+
+	h.resolveOccurrence(w, occurrence, true, edit)
+}
+
+// SkipOccurrence drops a queued occurrence without posting it
+func (h *RecurringHandler) SkipOccurrence(w http.ResponseWriter, r *http.Request) {
+	occurrence, ok := h.findOwnedOccurrence(w, r)
+	if !ok {
+		return
+	}
+	h.resolveOccurrence(w, occurrence, false, nil)
+}
+
+// SnoozeOccurrence hides a queued occurrence until a later date
+func (h *RecurringHandler) SnoozeOccurrence(w http.ResponseWriter, r *http.Request) {
+	occurrence, ok := h.findOwnedOccurrence(w, r)
+	if !ok {
+		return
+	}
+
+	var req SnoozeOccurrenceRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now()
+	var until time.Time
+	if req.Until != "" {
+		date, err := time.ParseInLocation("2006-01-02", req.Until, time.Local)
+		if err != nil {
+			http.Error(w, "Invalid until date, use YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		until = date
+	} else {
+		if req.Days == 0 {
+			req.Days = 1
+		}
+		until = time.Date(now.Year(), now.Month(), now.Day()+req.Days, 0, 0, 0, 0, time.Local)
+	}
+	if !until.After(now) {
+		http.Error(w, "Snooze must end in the future", http.StatusBadRequest)
+		return
+	}
+
+	snoozed, err := h.recurringRepo.SnoozeOccurrence(occurrence, until)
+	if err != nil {
+		http.Error(w, "Error snoozing occurrence", http.StatusInternalServerError)
+		return
+	}
+	if !snoozed {
+		http.Error(w, "Occurrence is not waiting in a queue", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(occurrence)
+}
+
+// SetOccurrenceAmount records the actual amount of a posted occurrence, e.g.
+// the real bill of one posted with an estimate. Its transaction and wallet
+// balance follow, and estimated rules use it for their next estimate.
+func (h *RecurringHandler) SetOccurrenceAmount(w http.ResponseWriter, r *http.Request) {
+	occurrence, ok := h.findOwnedOccurrence(w, r)
+	if !ok {
+		return
+	}
+
+	var req OccurrenceAmountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Amount <= 0 {
+		http.Error(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := h.recurringRepo.SetOccurrenceAmount(occurrence, req.Amount)
+	if err != nil {
+		http.Error(w, "Error updating occurrence", http.StatusInternalServerError)
+		return
+	}
+	if !updated {
+		http.Error(w, "Only posted occurrences have an amount, confirm queued ones instead", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(occurrence)
+}
+
+func (h *RecurringHandler) resolveOccurrence(w http.ResponseWriter, occurrence *models.RecurringOccurrence, post bool, edit *repository.OccurrenceEdit) {
+	resolved, err := h.recurringRepo.ResolveOccurrence(occurrence, post, edit)
+	if errors.Is(err, repository.ErrOccurrenceOrphaned) {
+		http.Error(w, "The recurring transaction or its wallet was deleted, skip this occurrence instead", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error resolving occurrence", http.StatusInternalServerError)
+		return
+	}
+	if !resolved {
+		http.Error(w, "Occurrence is not waiting in a queue", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(occurrence)
+}
+
+// findOwnedOccurrence loads the occurrence in the URL, writing the error
+// response when it doesn't exist or belongs to someone else
+func (h *RecurringHandler) findOwnedOccurrence(w http.ResponseWriter, r *http.Request) (*models.RecurringOccurrence, bool) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	occurrence, err := h.recurringRepo.FindOccurrenceByID(uint(id))
+	if err != nil {
+		http.Error(w, "Occurrence not found", http.StatusNotFound)
+		return nil, false
+	}
+	if occurrence.UserID != middleware.GetUserID(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return occurrence, true
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"sort"
 	"strconv"
 	"time"
 
@@ -13,27 +14,33 @@ import (
 type ReportHandler struct {
 	transactionRepo *repository.TransactionRepository
 	categoryRepo    *repository.CategoryRepository
+	budgetRepo      *repository.BudgetRepository
 }
 
-func NewReportHandler(transactionRepo *repository.TransactionRepository, categoryRepo *repository.CategoryRepository) *ReportHandler {
+func NewReportHandler(transactionRepo *repository.TransactionRepository, categoryRepo *repository.CategoryRepository, budgetRepo *repository.BudgetRepository) *ReportHandler {
 	return &ReportHandler{
 		transactionRepo: transactionRepo,
 		categoryRepo:    categoryRepo,
+		budgetRepo:      budgetRepo,
 	}
 }
 
 type CategoryBreakdown struct {
-	CategoryID   uint    `json:"category_id"`
-	CategoryName string  `json:"category_name"`
-	CategoryIcon string  `json:"category_icon"`
-	Amount       float64 `json:"amount"`
-	Percentage   float64 `json:"percentage"`
+	CategoryID    uint    `json:"category_id"`
+	CategoryName  string  `json:"category_name"`
+	CategoryIcon  string  `json:"category_icon"`
+	CategoryColor string  `json:"category_color"`
+	Amount        float64 `json:"amount"`
+	Percentage    float64 `json:"percentage"`
 }
 
 type MonthComparison struct {
-	IncomeChange  float64 `json:"income_change"`
-	ExpenseChange float64 `json:"expense_change"`
-	SavingsChange float64 `json:"savings_change"`
+	IncomeChange    float64 `json:"income_change"`
+	ExpenseChange   float64 `json:"expense_change"`
+	SavingsChange   float64 `json:"savings_change"`
+	PreviousIncome  float64 `json:"previous_income"`
+	PreviousExpense float64 `json:"previous_expense"`
+	PreviousSavings float64 `json:"previous_savings"`
 }
 
 type DailyData struct {
@@ -57,8 +64,20 @@ type MonthlyReportResponse struct {
 
 func (h *ReportHandler) GetMonthlyReport(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r)
+	year, month := reportMonth(r)
 
-	// Parse year and month from query
+	report, err := h.monthlyReport(userID, year, month)
+	if err != nil {
+		http.Error(w, "Error fetching transactions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// reportMonth reads year and month from the query, defaulting to the current month
+func reportMonth(r *http.Request) (int, int) {
 	year, _ := strconv.Atoi(r.URL.Query().Get("year"))
 	month, _ := strconv.Atoi(r.URL.Query().Get("month"))
 
@@ -69,6 +88,13 @@ func (h *ReportHandler) GetMonthlyReport(w http.ResponseWriter, r *http.Request)
 	if month == 0 {
 		month = int(now.Month())
 	}
+	return year, month
+}
+
+// monthlyReport computes the report of one month for GetMonthlyReport and
+// GetMonthlyReportPDF
+func (h *ReportHandler) monthlyReport(userID uint, year, month int) (*MonthlyReportResponse, error) {
+	now := time.Now()
 
 	// Calculate date range for the month
 	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local)
@@ -81,8 +107,7 @@ func (h *ReportHandler) GetMonthlyReport(w http.ResponseWriter, r *http.Request)
 	// Get transactions for current month
 	transactions, err := h.transactionRepo.FindByUserIDAndDateRange(userID, startDate, endDate)
 	if err != nil {
-		http.Error(w, "Error fetching transactions", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
 	// Get transactions for previous month
@@ -93,6 +118,7 @@ func (h *ReportHandler) GetMonthlyReport(w http.ResponseWriter, r *http.Request)
 	categoryAmounts := make(map[uint]float64)
 	categoryNames := make(map[uint]string)
 	categoryIcons := make(map[uint]string)
+	categoryColors := make(map[uint]string)
 	dailyIncome := make(map[string]float64)
 	dailyExpense := make(map[string]float64)
 
@@ -105,15 +131,21 @@ func (h *ReportHandler) GetMonthlyReport(w http.ResponseWriter, r *http.Request)
 		if tx.Type == "income" && !isTransfer {
 			totalIncome += tx.Amount
 			dailyIncome[dateKey] += tx.Amount
-		} else if tx.Type == "expense" {
-			totalExpense += tx.Amount
-			dailyExpense[dateKey] += tx.Amount
+		} else if tx.Type == "expense" || tx.Type == "refund" {
+			// Refunds are netted against the original expense's category
+			amount := tx.Amount
+			if tx.Type == "refund" {
+				amount = -amount
+			}
+			totalExpense += amount
+			dailyExpense[dateKey] += amount
 
 			// Category breakdown (expenses only, excluding transfers)
 			if tx.CategoryID != 0 && !isTransfer {
-				categoryAmounts[tx.CategoryID] += tx.Amount
+				categoryAmounts[tx.CategoryID] += amount
 				categoryNames[tx.CategoryID] = tx.Category.Name
 				categoryIcons[tx.CategoryID] = tx.Category.Icon
+				categoryColors[tx.CategoryID] = tx.Category.Color
 			}
 		}
 	}
@@ -121,18 +153,26 @@ func (h *ReportHandler) GetMonthlyReport(w http.ResponseWriter, r *http.Request)
 	// Build category breakdown
 	var categoryBreakdown []CategoryBreakdown
 	for catID, amount := range categoryAmounts {
+		if amount <= 0 {
+			continue // Fully refunded within the month
+		}
 		percentage := 0.0
 		if totalExpense > 0 {
 			percentage = (amount / totalExpense) * 100
 		}
 		categoryBreakdown = append(categoryBreakdown, CategoryBreakdown{
-			CategoryID:   catID,
-			CategoryName: categoryNames[catID],
-			CategoryIcon: categoryIcons[catID],
-			Amount:       amount,
-			Percentage:   percentage,
+			CategoryID:    catID,
+			CategoryName:  categoryNames[catID],
+			CategoryIcon:  categoryIcons[catID],
+			CategoryColor: categoryColors[catID],
+			Amount:        amount,
+			Percentage:    percentage,
 		})
 	}
+	// Largest first
+	sort.Slice(categoryBreakdown, func(i, j int) bool {
+		return categoryBreakdown[i].Amount > categoryBreakdown[j].Amount
+	})
 
 	// Build daily trend
 	var dailyTrend []DailyData
@@ -153,6 +193,8 @@ func (h *ReportHandler) GetMonthlyReport(w http.ResponseWriter, r *http.Request)
 			prevIncome += tx.Amount
 		} else if tx.Type == "expense" && !isTransfer {
 			prevExpense += tx.Amount
+		} else if tx.Type == "refund" {
+			prevExpense -= tx.Amount
 		}
 	}
 
@@ -183,7 +225,7 @@ func (h *ReportHandler) GetMonthlyReport(w http.ResponseWriter, r *http.Request)
 		savingsRate = (netSavings / totalIncome) * 100
 	}
 
-	response := MonthlyReportResponse{
+	return &MonthlyReportResponse{
 		Year:              year,
 		Month:             month,
 		TotalIncome:       totalIncome,
@@ -192,16 +234,16 @@ func (h *ReportHandler) GetMonthlyReport(w http.ResponseWriter, r *http.Request)
 		SavingsRate:       savingsRate,
 		CategoryBreakdown: categoryBreakdown,
 		Comparison: MonthComparison{
-			IncomeChange:  incomeChange,
-			ExpenseChange: expenseChange,
-			SavingsChange: savingsChange,
+			IncomeChange:    incomeChange,
+			ExpenseChange:   expenseChange,
+			SavingsChange:   savingsChange,
+			PreviousIncome:  prevIncome,
+			PreviousExpense: prevExpense,
+			PreviousSavings: prevSavings,
 		},
 		DailyTrend:       dailyTrend,
 		TransactionCount: len(transactions),
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	}, nil
 }
 
 func absFloat(x float64) float64 {
@@ -0,0 +1,472 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/pkg/middleware"
+	"github.com/money-management/backend/pkg/pdf"
+)
+
+// reportLabels are the texts of the PDF report, per language like the
+// frontend translations
+var reportLabels = map[string]map[string]string{
+	"id": {
+		"title":           "Laporan Bulanan",
+		"generated":       "Dibuat",
+		"income":          "Pemasukan",
+		"expense":         "Pengeluaran",
+		"net_savings":     "Tabungan Bersih",
+		"savings_rate":    "Rasio Tabungan",
+		"transactions":    "%d transaksi",
+		"comparison":      "Perbandingan dengan Bulan Lalu",
+		"this_month":      "Bulan Ini",
+		"last_month":      "Bulan Lalu",
+		"change":          "Perubahan",
+		"categories":      "Pengeluaran per Kategori",
+		"other":           "Lainnya",
+		"no_expenses":     "Belum ada pengeluaran bulan ini.",
+		"daily_trend":     "Tren Harian",
+		"budgets":         "Status Anggaran",
+		"category":        "Kategori",
+		"budget":          "Anggaran",
+		"spent":           "Terpakai",
+		"remaining":       "Sisa",
+		"used":            "Pemakaian",
+		"no_budgets":      "Belum ada anggaran.",
+		"page":            "Halaman %d",
+		"thousands":       ".",
+		"decimal":         ",",
+		"no_transactions": "Tidak ada transaksi bulan ini.",
+	},
+	"en": {
+		"title":           "Monthly Report",
+		"generated":       "Generated",
+		"income":          "Income",
+		"expense":         "Expenses",
+		"net_savings":     "Net Savings",
+		"savings_rate":    "Savings Rate",
+		"transactions":    "%d transactions",
+		"comparison":      "Month-over-Month Comparison",
+		"this_month":      "This Month",
+		"last_month":      "Last Month",
+		"change":          "Change",
+		"categories":      "Expenses by Category",
+		"other":           "Other",
+		"no_expenses":     "No expenses this month yet.",
+		"daily_trend":     "Daily Trend",
+		"budgets":         "Budget Status",
+		"category":        "Category",
+		"budget":          "Budget",
+		"spent":           "Spent",
+		"remaining":       "Remaining",
+		"used":            "Used",
+		"no_budgets":      "No budgets yet.",
+		"page":            "Page %d",
+		"thousands":       ",",
+		"decimal":         ".",
+		"no_transactions": "No transactions this month.",
+	},
+}
+
+var reportMonthNames = map[string][]string{
+	"id": {"Januari", "Februari", "Maret", "April", "Mei", "Juni", "Juli", "Agustus", "September", "Oktober", "November", "Desember"},
+	"en": {"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+}
+
+// Report colors
+var (
+	pdfText     = pdf.Color{R: 31, G: 41, B: 55}
+	pdfMuted    = pdf.Color{R: 107, G: 114, B: 128}
+	pdfBorder   = pdf.Color{R: 229, G: 231, B: 235}
+	pdfPanel    = pdf.Color{R: 249, G: 250, B: 251}
+	pdfIncome   = pdf.Color{R: 22, G: 163, B: 74}
+	pdfExpense  = pdf.Color{R: 220, G: 38, B: 38}
+	pdfWarning  = pdf.Color{R: 217, G: 119, B: 6}
+	pdfAccent   = pdf.Color{R: 79, G: 70, B: 229}
+	pdfBarTrack = pdf.Color{R: 243, G: 244, B: 246}
+)
+
+// Page layout in points
+const (
+	pdfMargin  = 40.0
+	pdfRight   = pdf.A4Width - pdfMargin
+	pdfContent = pdf.A4Width - 2*pdfMargin
+	pdfBottom  = pdf.A4Height - 50
+
+	// pdfMaxCategories limits the chart, smaller categories are summed up
+	pdfMaxCategories = 12
+)
+
+// GetMonthlyReportPDF renders the monthly report as a printable PDF: totals,
+// savings rate, month-over-month comparison, expenses by category, the daily
+// trend and budget status. lang (id, en) picks the language, falling back to
+// Accept-Language.
+func (h *ReportHandler) GetMonthlyReportPDF(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+	year, month := reportMonth(r)
+	if month < 1 || month > 12 {
+		http.Error(w, "Invalid month", http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.monthlyReport(userID, year, month)
+	if err != nil {
+		http.Error(w, "Error fetching transactions", http.StatusInternalServerError)
+		return
+	}
+
+	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.Local)
+	budgets, err := h.budgetRepo.GetBudgetsWithSpending(userID, budgetReferenceDate(startDate, time.Now()))
+	if err != nil {
+		http.Error(w, "Error fetching budgets", http.StatusInternalServerError)
+		return
+	}
+
+	lang := reportLanguage(r)
+	doc := renderMonthlyReport(report, budgets, lang)
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=monthly-report-%04d-%02d.pdf", year, month))
+	doc.WriteTo(w)
+}
+
+// reportLanguage reads lang from the query or Accept-Language, defaulting to
+// Indonesian like the frontend
+func reportLanguage(r *http.Request) string {
+	if lang := strings.ToLower(r.URL.Query().Get("lang")); reportLabels[lang] != nil {
+		return lang
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		lang := strings.ToLower(strings.TrimSpace(part))
+		if i := strings.IndexAny(lang, "-;"); i >= 0 {
+			lang = lang[:i]
+		}
+		if reportLabels[lang] != nil {
+			return lang
+		}
+	}
+	return "id"
+}
+
+// reportWriter places blocks on pages top to bottom, starting a new page
+// when a block doesn't fit
+type reportWriter struct {
+	doc    *pdf.Document
+	page   *pdf.Page
+	pages  []*pdf.Page
+	y      float64
+	labels map[string]string
+}
+
+func (rw *reportWriter) newPage() {
+	rw.page = rw.doc.AddPage()
+	rw.pages = append(rw.pages, rw.page)
+	rw.y = pdfMargin
+}
+
+// need makes sure height points are left on the page
+func (rw *reportWriter) need(height float64) {
+	if rw.y+height > pdfBottom {
+		rw.newPage()
+	}
+}
+
+func (rw *reportWriter) section(title string, height float64) {
+	rw.need(height + 32)
+	rw.y += 14
+	rw.page.Text(pdfMargin, rw.y+12, pdf.Bold, 13, pdfText, title)
+	rw.y += 18
+	rw.page.Line(pdfMargin, rw.y, pdfRight, rw.y, 0.75, pdfBorder)
+	rw.y += 10
+}
+
+// money formats an IDR amount with the language's separators
+func (rw *reportWriter) money(v float64) string {
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return sign + "Rp " + formatThousands(v, rw.labels["thousands"])
+}
+
+// formatThousands formats a non-negative amount as a whole number with the
+// given thousands separator
+func formatThousands(v float64, separator string) string {
+	digits := fmt.Sprintf("%.0f", math.Round(v))
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(separator)
+		}
+		b.WriteRune(d)
+	}
+	return b.String()
+}
+
+func (rw *reportWriter) percent(v float64) string {
+	return strings.Replace(fmt.Sprintf("%.1f%%", v), ".", rw.labels["decimal"], 1)
+}
+
+// change formats a percentage change with its sign
+func (rw *reportWriter) change(v float64) string {
+	if v > 0 {
+		return "+" + rw.percent(v)
+	}
+	return rw.percent(v)
+}
+
+func renderMonthlyReport(report *MonthlyReportResponse, budgets []models.Budget, lang string) *pdf.Document {
+	labels := reportLabels[lang]
+	period := fmt.Sprintf("%s %d", reportMonthNames[lang][report.Month-1], report.Year)
+
+	rw := &reportWriter{doc: pdf.New(labels["title"] + " - " + period), labels: labels}
+	rw.newPage()
+
+	// Header
+	rw.page.Text(pdfMargin, rw.y+20, pdf.Bold, 20, pdfText, labels["title"])
+	rw.page.Text(pdfMargin, rw.y+38, pdf.Regular, 12, pdfMuted, period)
+	now := time.Now()
+	generated := fmt.Sprintf("%s %d %s %d", labels["generated"], now.Day(), reportMonthNames[lang][now.Month()-1], now.Year())
+	rw.page.TextRight(pdfRight, rw.y+20, pdf.Regular, 9, pdfMuted, generated)
+	rw.y += 56
+
+	rw.summary(report)
+	rw.comparison(report)
+	rw.categories(report)
+	rw.dailyTrend(report)
+	rw.budgets(budgets)
+
+	for i, page := range rw.pages {
+		page.TextRight(pdfRight, pdf.A4Height-25, pdf.Regular, 8, pdfMuted, fmt.Sprintf(labels["page"], i+1))
+		page.Text(pdfMargin, pdf.A4Height-25, pdf.Regular, 8, pdfMuted, labels["title"]+" - "+period)
+	}
+	return rw.doc
+}
+
+// summary draws the totals as four cards
+func (rw *reportWriter) summary(report *MonthlyReportResponse) {
+	savingsColor := pdfIncome
+	if report.NetSavings < 0 {
+		savingsColor = pdfExpense
+	}
+	cards := []struct {
+		label, value string
+		color        pdf.Color
+	}{
+		{rw.labels["income"], rw.money(report.TotalIncome), pdfIncome},
+		{rw.labels["expense"], rw.money(report.TotalExpense), pdfExpense},
+		{rw.labels["net_savings"], rw.money(report.NetSavings), savingsColor},
+		{rw.labels["savings_rate"], rw.percent(report.SavingsRate), pdfAccent},
+	}
+
+	const gap, height = 10.0, 54.0
+	width := (pdfContent - gap*float64(len(cards)-1)) / float64(len(cards))
+	for i, card := range cards {
+		x := pdfMargin + float64(i)*(width+gap)
+		rw.page.Rect(x, rw.y, width, height, pdfPanel)
+		rw.page.Rect(x, rw.y, 3, height, card.color)
+		rw.page.Text(x+12, rw.y+20, pdf.Regular, 9, pdfMuted, card.label)
+		rw.page.Text(x+12, rw.y+40, pdf.Bold, 12, card.color, pdf.Truncate(card.value, pdf.Bold, 12, width-18))
+	}
+	rw.y += height + 16
+
+	count := fmt.Sprintf(rw.labels["transactions"], report.TransactionCount)
+	if report.TransactionCount == 0 {
+		count = rw.labels["no_transactions"]
+	}
+	rw.page.Text(pdfMargin, rw.y, pdf.Regular, 9, pdfMuted, count)
+	rw.y += 6
+}
+
+// comparison draws this month against the previous one
+func (rw *reportWriter) comparison(report *MonthlyReportResponse) {
+	rw.section(rw.labels["comparison"], 4*18)
+
+	columns := []float64{pdfMargin + 250, pdfMargin + 370, pdfRight}
+	rw.page.TextRight(columns[0], rw.y+10, pdf.Bold, 9, pdfMuted, rw.labels["this_month"])
+	rw.page.TextRight(columns[1], rw.y+10, pdf.Bold, 9, pdfMuted, rw.labels["last_month"])
+	rw.page.TextRight(columns[2], rw.y+10, pdf.Bold, 9, pdfMuted, rw.labels["change"])
+	rw.y += 18
+
+	c := report.Comparison
+	rows := []struct {
+		label          string
+		current, prev  float64
+		change         float64
+		higherIsBetter bool
+	}{
+		{rw.labels["income"], report.TotalIncome, c.PreviousIncome, c.IncomeChange, true},
+		{rw.labels["expense"], report.TotalExpense, c.PreviousExpense, c.ExpenseChange, false},
+		{rw.labels["net_savings"], report.NetSavings, c.PreviousSavings, c.SavingsChange, true},
+	}
+	for _, row := range rows {
+		color := pdfMuted
+		if (row.change > 0) == row.higherIsBetter && row.change != 0 {
+			color = pdfIncome
+		} else if row.change != 0 {
+			color = pdfExpense
+		}
+		rw.page.Text(pdfMargin, rw.y+10, pdf.Regular, 10, pdfText, row.label)
+		rw.page.TextRight(columns[0], rw.y+10, pdf.Regular, 10, pdfText, rw.money(row.current))
+		rw.page.TextRight(columns[1], rw.y+10, pdf.Regular, 10, pdfMuted, rw.money(row.prev))
+		rw.page.TextRight(columns[2], rw.y+10, pdf.Bold, 10, color, rw.change(row.change))
+		rw.y += 18
+	}
+}
+
+// categories draws a bar chart of expenses by category
+func (rw *reportWriter) categories(report *MonthlyReportResponse) {
+	breakdown := report.CategoryBreakdown
+	if len(breakdown) > pdfMaxCategories {
+		other := CategoryBreakdown{CategoryName: rw.labels["other"], CategoryColor: "#9ca3af"}
+		for _, c := range breakdown[pdfMaxCategories-1:] {
+			other.Amount += c.Amount
+			other.Percentage += c.Percentage
+		}
+		breakdown = append(append([]CategoryBreakdown{}, breakdown[:pdfMaxCategories-1]...), other)
+	}
+
+	const rowHeight = 20.0
+	rw.section(rw.labels["categories"], math.Max(1, float64(len(breakdown)))*rowHeight)
+	if len(breakdown) == 0 {
+		rw.page.Text(pdfMargin, rw.y+10, pdf.Regular, 10, pdfMuted, rw.labels["no_expenses"])
+		rw.y += rowHeight
+		return
+	}
+
+	maxAmount := breakdown[0].Amount
+	for _, c := range breakdown {
+		maxAmount = math.Max(maxAmount, c.Amount)
+	}
+
+	const labelWidth, valueWidth, percentWidth = 130.0, 90.0, 45.0
+	barX := pdfMargin + labelWidth
+	barWidth := pdfContent - labelWidth - valueWidth - percentWidth - 10
+	for _, c := range breakdown {
+		rw.page.Text(pdfMargin, rw.y+11, pdf.Regular, 10, pdfText, pdf.Truncate(c.CategoryName, pdf.Regular, 10, labelWidth-8))
+		rw.page.Rect(barX, rw.y+3, barWidth, 10, pdfBarTrack)
+		if maxAmount > 0 {
+			rw.page.Rect(barX, rw.y+3, math.Max(1, barWidth*c.Amount/maxAmount), 10, pdf.HexColor(c.CategoryColor, pdfAccent))
+		}
+		rw.page.TextRight(pdfRight-percentWidth, rw.y+11, pdf.Regular, 10, pdfText, rw.money(c.Amount))
+		rw.page.TextRight(pdfRight, rw.y+11, pdf.Regular, 10, pdfMuted, rw.percent(c.Percentage))
+		rw.y += rowHeight
+	}
+}
+
+// dailyTrend draws income and expenses per day as two lines
+func (rw *reportWriter) dailyTrend(report *MonthlyReportResponse) {
+	const height = 150.0
+	rw.section(rw.labels["daily_trend"], height+40)
+
+	maxValue := 0.0
+	for _, d := range report.DailyTrend {
+		maxValue = math.Max(maxValue, math.Max(d.Income, d.Expense))
+	}
+	if maxValue == 0 {
+		maxValue = 1
+	}
+
+	const axisWidth = 70.0
+	left, top := pdfMargin+axisWidth, rw.y
+	width := pdfContent - axisWidth
+	days := time.Date(report.Year, time.Month(report.Month)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	x := func(day int) float64 {
+		if days == 1 {
+			return left
+		}
+		return left + width*float64(day-1)/float64(days-1)
+	}
+	y := func(v float64) float64 { return top + height - height*v/maxValue }
+
+	// Grid with amounts on the left
+	for i := 0; i <= 4; i++ {
+		v := maxValue * float64(i) / 4
+		rw.page.Line(left, y(v), left+width, y(v), 0.5, pdfBorder)
+		rw.page.TextRight(left-6, y(v)+3, pdf.Regular, 7, pdfMuted, rw.money(v))
+	}
+	for day := 1; day <= days; day++ {
+		if day == 1 || day%5 == 0 {
+			rw.page.TextRight(x(day)+3, top+height+12, pdf.Regular, 7, pdfMuted, fmt.Sprint(day))
+		}
+	}
+
+	var income, expense []float64
+	for _, d := range report.DailyTrend {
+		date, err := time.Parse("2006-01-02", d.Date)
+		if err != nil {
+			continue
+		}
+		income = append(income, x(date.Day()), y(d.Income))
+		expense = append(expense, x(date.Day()), y(d.Expense))
+	}
+	rw.page.Polyline(income, 1.5, pdfIncome)
+	rw.page.Polyline(expense, 1.5, pdfExpense)
+
+	// Legend
+	legendY := top + height + 28
+	rw.page.Rect(left, legendY-7, 10, 3, pdfIncome)
+	rw.page.Text(left+14, legendY, pdf.Regular, 8, pdfText, rw.labels["income"])
+	offset := 24 + pdf.TextWidth(rw.labels["income"], pdf.Regular, 8)
+	rw.page.Rect(left+offset, legendY-7, 10, 3, pdfExpense)
+	rw.page.Text(left+offset+14, legendY, pdf.Regular, 8, pdfText, rw.labels["expense"])
+	rw.y = legendY + 6
+}
+
+// budgets lists every budget with how much of it was used
+func (rw *reportWriter) budgets(budgets []models.Budget) {
+	const rowHeight = 22.0
+	rw.section(rw.labels["budgets"], rowHeight*2)
+	if len(budgets) == 0 {
+		rw.page.Text(pdfMargin, rw.y+10, pdf.Regular, 10, pdfMuted, rw.labels["no_budgets"])
+		rw.y += rowHeight
+		return
+	}
+
+	columns := []float64{pdfMargin + 230, pdfMargin + 310, pdfMargin + 390}
+	header := func() {
+		rw.page.Text(pdfMargin, rw.y+10, pdf.Bold, 9, pdfMuted, rw.labels["category"])
+		rw.page.TextRight(columns[0], rw.y+10, pdf.Bold, 9, pdfMuted, rw.labels["budget"])
+		rw.page.TextRight(columns[1], rw.y+10, pdf.Bold, 9, pdfMuted, rw.labels["spent"])
+		rw.page.TextRight(columns[2], rw.y+10, pdf.Bold, 9, pdfMuted, rw.labels["remaining"])
+		rw.page.TextRight(pdfRight, rw.y+10, pdf.Bold, 9, pdfMuted, rw.labels["used"])
+		rw.y += 18
+	}
+	header()
+
+	for _, b := range budgets {
+		if rw.y+rowHeight > pdfBottom {
+			rw.newPage()
+			header()
+		}
+
+		color := pdfIncome
+		switch {
+		case b.Percentage > 100:
+			color = pdfExpense
+		case b.Percentage >= 80:
+			color = pdfWarning
+		}
+		remainingColor := pdfText
+		if b.Remaining < 0 {
+			remainingColor = pdfExpense
+		}
+
+		rw.page.Text(pdfMargin, rw.y+10, pdf.Regular, 10, pdfText, pdf.Truncate(b.Category.Name, pdf.Regular, 10, 140))
+		rw.page.TextRight(columns[0], rw.y+10, pdf.Regular, 10, pdfText, rw.money(b.EffectiveAmount))
+		rw.page.TextRight(columns[1], rw.y+10, pdf.Regular, 10, pdfText, rw.money(b.Spent))
+		rw.page.TextRight(columns[2], rw.y+10, pdf.Regular, 10, remainingColor, rw.money(b.Remaining))
+
+		const barWidth = 70.0
+		barX := pdfRight - barWidth
+		rw.page.Rect(barX, rw.y+13, barWidth, 4, pdfBarTrack)
+		rw.page.Rect(barX, rw.y+13, barWidth*math.Max(0, math.Min(b.Percentage, 100))/100, 4, color)
+		rw.page.TextRight(pdfRight, rw.y+9, pdf.Bold, 9, color, rw.percent(b.Percentage))
+		rw.y += rowHeight
+	}
+}
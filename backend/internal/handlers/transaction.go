@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
@@ -43,6 +45,7 @@ type CreateTransactionRequest struct {
 	Date        string  `json:"date"`
 	Notes       string  `json:"notes"`
 	ProofURL    string  `json:"proof_url"`
+	RefundOfID  *uint   `json:"refund_of_id"` // Required when Type is "refund"
 }
 
 type UpdateTransactionRequest struct {
@@ -55,6 +58,7 @@ type UpdateTransactionRequest struct {
 	Date        string  `json:"date"`
 	Notes       string  `json:"notes"`
 	ProofURL    string  `json:"proof_url"`
+	RefundOfID  *uint   `json:"refund_of_id"` // Required when Type is "refund"
 }
 
 type TransactionListResponse struct {
@@ -79,45 +83,56 @@ func (h *TransactionHandler) List(w http.ResponseWriter, r *http.Request) {
 
 	offset := (page - 1) * limit
 
-	// Parse filters
-	var startDate, endDate *time.Time
+	f := parseTransactionFilters(r)
+
+	transactions, total, err := h.transactionRepo.Search(userID, f.startDate, f.endDate, f.categoryID, f.transactionType, f.search, limit, offset)
+	if err != nil {
+		http.Error(w, "Error fetching transactions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TransactionListResponse{
+		Transactions: transactions,
+		Total:        total,
+		Page:         page,
+		Limit:        limit,
+	})
+}
+
+// transactionFilters are the query filters shared by List and Export
+type transactionFilters struct {
+	startDate, endDate *time.Time
+	categoryID         *uint
+	transactionType    string
+	search             string
+}
+
+func parseTransactionFilters(r *http.Request) transactionFilters {
+	var f transactionFilters
 	if s := r.URL.Query().Get("start_date"); s != "" {
 		if t, err := time.Parse("2006-01-02", s); err == nil {
-			startDate = &t
+			f.startDate = &t
 		}
 	}
 	if e := r.URL.Query().Get("end_date"); e != "" {
 		if t, err := time.Parse("2006-01-02", e); err == nil {
 			// Set to end of day
 			t = t.Add(23*time.Hour + 59*time.Minute + 59*time.Second)
-			endDate = &t
+			f.endDate = &t
 		}
 	}
 
-	var categoryID *uint
 	if c := r.URL.Query().Get("category_id"); c != "" {
 		if id, err := strconv.ParseUint(c, 10, 32); err == nil {
 			uid := uint(id)
-			categoryID = &uid
+			f.categoryID = &uid
 		}
 	}
 
-	search := r.URL.Query().Get("search")
-	transactionType := r.URL.Query().Get("type")
-
-	transactions, total, err := h.transactionRepo.Search(userID, startDate, endDate, categoryID, transactionType, search, limit, offset)
-	if err != nil {
-		http.Error(w, "Error fetching transactions", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(TransactionListResponse{
-		Transactions: transactions,
-		Total:        total,
-		Page:         page,
-		Limit:        limit,
-	})
+	f.search = r.URL.Query().Get("search")
+	f.transactionType = r.URL.Query().Get("type")
+	return f
 }
 
 func (h *TransactionHandler) Get(w http.ResponseWriter, r *http.Request) {
@@ -153,9 +168,25 @@ func (h *TransactionHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 	userID := middleware.GetUserID(r)
 
-	// Get default wallet if not specified
-	// Get default wallet if not specified
 	walletID := req.WalletID
+
+	// Refunds are booked against the original expense's category so that
+	// category spending and budgets are netted instead of counting as income
+	var refundOfID *uint
+	if req.Type == "refund" {
+		original, err := h.validateRefund(userID, req.RefundOfID, req.Amount, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.CategoryID = original.CategoryID
+		refundOfID = &original.ID
+		if walletID == 0 {
+			walletID = original.WalletID
+		}
+	}
+
+	// Get default wallet if not specified
 	if walletID == 0 {
 		defaultWallet, _ := h.walletRepo.FindDefaultByUserID(userID)
 		if defaultWallet != nil {
@@ -202,6 +233,7 @@ func (h *TransactionHandler) Create(w http.ResponseWriter, r *http.Request) {
 		Date:           date,
 		Notes:          req.Notes,
 		ProofURL:       req.ProofURL,
+		RefundOfID:     refundOfID,
 	}
 
 	if err := h.transactionRepo.Create(transaction); err != nil {
@@ -210,7 +242,7 @@ func (h *TransactionHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update wallet balance
-	h.walletRepo.UpdateBalance(walletID, req.Amount, req.Type == "income")
+	h.walletRepo.UpdateBalance(walletID, req.Amount, isInflow(req.Type))
 
 	// Gamification: Update Streak and XP
 	// 50 XP for every transaction
@@ -258,6 +290,30 @@ func (h *TransactionHandler) Update(w http.ResponseWriter, r *http.Request) {
 		date = transaction.Date
 	}
 
+	if req.Type == "refund" {
+		refundOfID := req.RefundOfID
+		if refundOfID == nil {
+			refundOfID = transaction.RefundOfID
+		}
+		original, err := h.validateRefund(userID, refundOfID, req.Amount, transaction.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.CategoryID = original.CategoryID
+		transaction.RefundOfID = &original.ID
+	} else {
+		// An expense cannot shrink below (or stop being) what was already refunded
+		if transaction.Type == "expense" {
+			refunded, _ := h.transactionRepo.GetRefundedAmount(transaction.ID, 0)
+			if refunded > 0 && (req.Type != "expense" || req.Amount < refunded) {
+				http.Error(w, "Expense has refunds exceeding the new amount", http.StatusBadRequest)
+				return
+			}
+		}
+		transaction.RefundOfID = nil
+	}
+
 	// Revert previous balance impact
 	// If old was income, we now subtract (isIncome=false)
 	// If old was expense, we now add (isIncome=true)
@@ -277,7 +333,7 @@ func (h *TransactionHandler) Update(w http.ResponseWriter, r *http.Request) {
 	transaction.ProofURL = req.ProofURL
 
 	// Apply NEW balance impact
-	isNewIncome := req.Type == "income"
+	isNewIncome := isInflow(req.Type)
 	if err := h.walletRepo.UpdateBalance(transaction.WalletID, req.Amount, isNewIncome); err != nil {
 		http.Error(w, "Error updating new wallet balance", http.StatusInternalServerError)
 		return
@@ -315,6 +371,13 @@ func (h *TransactionHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if transaction.Type == "expense" {
+		if count, _ := h.transactionRepo.CountRefunds(transaction.ID); count > 0 {
+			http.Error(w, "Delete the refunds for this expense first", http.StatusConflict)
+			return
+		}
+	}
+
 	// Revert wallet balance
 	// If it was an expense, we add money back (isIncome = true)
 	// If it was an income, we remove money (isIncome = false)
@@ -334,6 +397,41 @@ func (h *TransactionHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// isInflow reports whether a transaction type adds money to its wallet.
+func isInflow(transactionType string) bool {
+	return transactionType == "income" || transactionType == "refund"
+}
+
+// validateRefund checks that a refund points at one of the user's expenses and
+// that together with earlier refunds it does not exceed the original amount.
+// Partial refunds are allowed. excludeID skips the refund being edited.
+func (h *TransactionHandler) validateRefund(userID uint, refundOfID *uint, amount float64, excludeID uint) (*models.Transaction, error) {
+	if refundOfID == nil {
+		return nil, errors.New("Refund must reference the original expense")
+	}
+	if amount <= 0 {
+		return nil, errors.New("Refund amount must be positive")
+	}
+
+	original, err := h.transactionRepo.FindByID(*refundOfID)
+	if err != nil || original.UserID != userID {
+		return nil, errors.New("Original expense not found")
+	}
+	if original.Type != "expense" {
+		return nil, errors.New("Only expenses can be refunded")
+	}
+
+	refunded, err := h.transactionRepo.GetRefundedAmount(original.ID, excludeID)
+	if err != nil {
+		return nil, err
+	}
+	if refunded+amount > original.Amount {
+		return nil, fmt.Errorf("Refund exceeds the remaining refundable amount (%.2f)", original.Amount-refunded)
+	}
+
+	return original, nil
+}
+
 type TransferRequest struct {
 	SourceWalletID uint    `json:"source_wallet_id"`
 	TargetWalletID uint    `json:"target_wallet_id"`
@@ -376,80 +474,10 @@ func (h *TransactionHandler) Transfer(w http.ResponseWriter, r *http.Request) {
 		date = time.Now()
 	}
 
-	// Find or Create "Transfer" category
-	// We need 2 categories? Or one?
-	// Usually "Transfer Out" (Expense) and "Transfer In" (Income).
-	// Let's try to find a generic "Transfer" category.
-	// Since we don't have a "FindByName" easily exposed maybe, let's just pick the first one matching "Transfer" or create it.
-	// Actually, let's keep it simple: Try to find a category named "Transfer"
-	// If not found, use the first available category as fallback to avoid errors.
-	var categoryID uint
-	categories, _ := h.categoryRepo.FindByUserID(userID)
-	for _, c := range categories {
-		if c.Name == "Transfer" || c.Name == "Transfer Out" || c.Name == "Transfer In" {
-			categoryID = c.ID
-			break
-		}
-	}
-
-	// If not found, create new "Transfer" category
-	if categoryID == 0 {
-		newCat := &models.Category{
-			UserID:      userID,
-			Name:        "Transfer",
-			Icon:        "🔄",       // Transfer emoji
-			Color:       "#808080", // Grey
-			Type:        "expense", // Default to expense? Or doesn't matter much if we filter.
-			IsDefault:   false,
-			IsEssential: false,
-		}
-		if err := h.categoryRepo.Create(newCat); err == nil {
-			categoryID = newCat.ID
-		} else {
-			// Fallback if create fails (shouldn't happen often)
-			if len(categories) > 0 {
-				categoryID = categories[0].ID
-			}
-		}
-	}
-
-	// 1. Create Expense from Source
-	expenseTx := &models.Transaction{
-		UserID:      userID,
-		WalletID:    req.SourceWalletID,
-		CategoryID:  categoryID,
-		Amount:      req.Amount,
-		Type:        "expense",
-		Description: "Transfer ke " + targetWallet.Name,
-		Date:        date,
-		Notes:       req.Description,
-	}
-
-	if err := h.transactionRepo.Create(expenseTx); err != nil {
-		http.Error(w, "Error processing transfer (debit)", http.StatusInternalServerError)
-		return
-	}
-	h.walletRepo.UpdateBalance(req.SourceWalletID, req.Amount, false)
-
-	// 2. Create Income to Target
-	incomeTx := &models.Transaction{
-		UserID:      userID,
-		WalletID:    req.TargetWalletID,
-		CategoryID:  categoryID,
-		Amount:      req.Amount,
-		Type:        "income",
-		Description: "Transfer dari " + sourceWallet.Name,
-		Date:        date,
-		Notes:       req.Description,
-	}
-
-	if err := h.transactionRepo.Create(incomeTx); err != nil {
-		// This is bad state if first one succeeded.
-		// Detailed handling omitted for MVP (should use DB transaction).
-		http.Error(w, "Error processing transfer (credit)", http.StatusInternalServerError)
+	if _, _, err := h.transactionRepo.CreateTransfer(sourceWallet, targetWallet, req.Amount, date, req.Description); err != nil {
+		http.Error(w, "Error processing transfer", http.StatusInternalServerError)
 		return
 	}
-	h.walletRepo.UpdateBalance(req.TargetWalletID, req.Amount, true)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Transfer successful"})
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/pkg/middleware"
+	"github.com/money-management/backend/pkg/xlsx"
+)
+
+// exportBatchSize is how many transactions are read from the database at a time
+const exportBatchSize = 500
+
+var exportColumns = []string{
+	"Date", "Type", "Category", "Wallet", "Description", "Notes",
+	"Currency", "Original Amount", "Exchange Rate", "Amount (IDR)", "Running Balance",
+}
+
+// exportRow is one transaction as it appears in a spreadsheet. Amounts are
+// signed: money out is negative. Balance is the balance of the transaction's
+// wallet right after it.
+type exportRow struct {
+	Date           time.Time
+	Type           string
+	Category       string
+	Wallet         string
+	Description    string
+	Notes          string
+	Currency       string
+	OriginalAmount float64
+	ExchangeRate   float64
+	Amount         float64
+	Balance        float64
+}
+
+// categoryTotal accumulates the summary sheet. Refunds are netted into their
+// expense category.
+type categoryTotal struct {
+	Name   string
+	Type   string
+	Count  int
+	Amount float64
+}
+
+// Export streams the transactions matching the List filters (start_date,
+// end_date, category_id, type, search) as CSV or, with format=xlsx, as a
+// workbook with a category summary sheet. The running balance is kept per
+// wallet, from its balance at start_date through every transaction in the
+// date range, including those the other filters leave out.
+func (h *TransactionHandler) Export(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r)
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "xlsx" {
+		http.Error(w, "Invalid format, use csv or xlsx", http.StatusBadRequest)
+		return
+	}
+
+	f := parseTransactionFilters(r)
+	filename := "transactions-" + time.Now().Format("20060102") + "." + format
+
+	// Running balances start from each wallet's balance at the start date
+	balances, err := h.transactionRepo.WalletBalancesAt(userID, f.startDate)
+	if err != nil {
+		http.Error(w, "Error fetching wallet balances", http.StatusInternalServerError)
+		return
+	}
+
+	// Headers go out with the first batch, so errors after that point can
+	// only cut the download short.
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+	net := 0.0
+	toRow := func(t models.Transaction) exportRow {
+		row := exportRow{
+			Date:           t.Date,
+			Type:           t.Type,
+			Category:       t.Category.Name,
+			Wallet:         t.Wallet.Name,
+			Description:    t.Description,
+			Notes:          t.Notes,
+			Currency:       t.Currency,
+			OriginalAmount: t.OriginalAmount,
+			ExchangeRate:   t.ExchangeRate,
+			Amount:         t.Amount,
+		}
+		// Transactions created before multi-currency support have no original amount
+		if row.Currency == "" {
+			row.Currency = "IDR"
+		}
+		if row.OriginalAmount == 0 {
+			row.OriginalAmount = t.Amount
+		}
+		if row.ExchangeRate == 0 {
+			row.ExchangeRate = 1
+		}
+		if !isInflow(t.Type) {
+			row.OriginalAmount, row.Amount = -row.OriginalAmount, -row.Amount
+		}
+		net += row.Amount
+		row.Balance = balances[t.WalletID]
+		return row
+	}
+
+	// each passes the transactions to export to fn, moving the balances
+	// along for all of them
+	each := func(fn func(t models.Transaction) error) error {
+		return h.transactionRepo.SearchInBatches(userID, f.startDate, f.endDate, nil, "", "", exportBatchSize, func(batch []models.Transaction) error {
+			for _, t := range batch {
+				if isInflow(t.Type) {
+					balances[t.WalletID] += t.Amount
+				} else {
+					balances[t.WalletID] -= t.Amount
+				}
+				if !f.matches(t) {
+					continue
+				}
+				if err := fn(t); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write(exportColumns)
+
+		rows := 0
+		each(func(t models.Transaction) error {
+			row := toRow(t)
+			cw.Write([]string{
+				row.Date.Format("2006-01-02"),
+				row.Type,
+				row.Category,
+				row.Wallet,
+				row.Description,
+				row.Notes,
+				row.Currency,
+				formatExportAmount(row.OriginalAmount),
+				strconv.FormatFloat(row.ExchangeRate, 'f', -1, 64),
+				formatExportAmount(row.Amount),
+				formatExportAmount(row.Balance),
+			})
+			if rows++; rows%exportBatchSize == 0 {
+				cw.Flush()
+			}
+			return cw.Error()
+		})
+		cw.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	xw := xlsx.NewWriter(w)
+	xw.NewSheet("Transactions", 12, 9, 18, 16, 32, 32, 9, 16, 13, 16, 16)
+	xw.WriteHeader(exportColumns...)
+
+	totals := make(map[uint]*categoryTotal)
+	err = each(func(t models.Transaction) error {
+		row := toRow(t)
+		if err := xw.WriteRow(
+			row.Date, row.Type, row.Category, row.Wallet, row.Description, row.Notes,
+			row.Currency, row.OriginalAmount, row.ExchangeRate, row.Amount, row.Balance,
+		); err != nil {
+			return err
+		}
+
+		total, ok := totals[t.CategoryID]
+		if !ok {
+			total = &categoryTotal{Name: t.Category.Name, Type: t.Category.Type}
+			totals[t.CategoryID] = total
+		}
+		total.Count++
+		total.Amount += row.Amount
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	writeExportSummary(xw, totals, net)
+	xw.Close()
+}
+
+// matches reports whether t passes the filters other than the date range
+func (f transactionFilters) matches(t models.Transaction) bool {
+	if f.categoryID != nil && t.CategoryID != *f.categoryID {
+		return false
+	}
+	if f.transactionType != "" && t.Type != f.transactionType {
+		return false
+	}
+	if f.search != "" && !strings.Contains(t.Description, f.search) && !strings.Contains(t.Notes, f.search) {
+		return false
+	}
+	return true
+}
+
+func writeExportSummary(xw *xlsx.Writer, totals map[uint]*categoryTotal, net float64) {
+	list := make([]*categoryTotal, 0, len(totals))
+	for _, t := range totals {
+		list = append(list, t)
+	}
+	// Income first, then the biggest expenses
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Type != list[j].Type {
+			return list[i].Type == "income"
+		}
+		return absFloat(list[i].Amount) > absFloat(list[j].Amount)
+	})
+
+	xw.NewSheet("Summary", 24, 10, 12, 18)
+	xw.WriteHeader("Category", "Type", "Transactions", "Total (IDR)")
+
+	income, expense := 0.0, 0.0
+	for _, t := range list {
+		xw.WriteRow(t.Name, t.Type, t.Count, t.Amount)
+		if t.Amount > 0 {
+			income += t.Amount
+		} else {
+			expense += t.Amount
+		}
+	}
+
+	xw.WriteRow()
+	xw.WriteRow("Total Income", nil, nil, income)
+	xw.WriteRow("Total Expense", nil, nil, expense)
+	xw.WriteRow("Net", nil, nil, net)
+}
+
+func formatExportAmount(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
@@ -182,6 +182,12 @@ func (h *WalletHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Their occurrences would fail to post, a paused one once resumed
+	if count, _ := h.walletRepo.CountRecurring(wallet.ID); count > 0 {
+		http.Error(w, "Delete or change the recurring transactions using this wallet first", http.StatusConflict)
+		return
+	}
+
 	if err := h.walletRepo.Delete(uint(id)); err != nil {
 		http.Error(w, "Error deleting wallet", http.StatusInternalServerError)
 		return
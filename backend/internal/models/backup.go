@@ -0,0 +1,306 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// BackupVersion is the current backup schema version. Bump it whenever the
+// shape of Backup changes and add an upgrade step for the previous version.
+//
+//	1: legacy export, raw models without a version field
+//	2: every user-owned entity, IDs only meaningful inside the backup
+//	3: transfers, schedules, amount types and confirmation of recurring
+//	   transactions, their occurrences, budget rollover and periods,
+//	   envelope budgeting
+const BackupVersion = 3
+
+// Backup is the portable export of one user's data. It is decoupled from the
+// database models so model changes don't silently change the file format.
+// IDs are the exporter's primary keys and are only used to link records
+// within the backup; restore assigns new IDs.
+type Backup struct {
+	Version    int       `json:"version"`
+	ExportDate time.Time `json:"export_date"`
+
+	Profile               BackupProfile                `json:"profile"`
+	Wallets               []BackupWallet               `json:"wallets"`
+	Categories            []BackupCategory             `json:"categories"`
+	Transactions          []BackupTransaction          `json:"transactions"`
+	Budgets               []BackupBudget               `json:"budgets"`
+	BudgetPeriods         []BackupBudgetPeriod         `json:"budget_periods"`
+	EnvelopeMonths        []BackupEnvelopeMonth        `json:"envelope_months"`
+	EnvelopeAssignments   []BackupEnvelopeAssignment   `json:"envelope_assignments"`
+	EnvelopeBalances      []BackupEnvelopeBalance      `json:"envelope_balances"`
+	EnvelopeMoves         []BackupEnvelopeMove         `json:"envelope_moves"`
+	Goals                 []BackupGoal                 `json:"goals"`
+	GoalItems             []BackupGoalItem             `json:"goal_items"`
+	GoalMembers           []BackupGoalMember           `json:"goal_members"`
+	GoalContributions     []BackupGoalContribution     `json:"goal_contributions"`
+	RecurringTransactions []BackupRecurringTransaction `json:"recurring_transactions"`
+	RecurringOccurrences  []BackupRecurringOccurrence  `json:"recurring_occurrences"`
+	Debts                 []BackupDebt                 `json:"debts"`
+	Badges                []BackupBadge                `json:"badges"`
+	ImportProfiles        []BackupImportProfile        `json:"import_profiles"`
+}
+
+// BackupProfile holds the gamification state stored on the user
+type BackupProfile struct {
+	CurrentStreak       int       `json:"current_streak"`
+	LongestStreak       int       `json:"longest_streak"`
+	LastTransactionDate time.Time `json:"last_transaction_date"`
+	Level               int       `json:"level"`
+	XP                  int       `json:"xp"`
+}
+
+type BackupWallet struct {
+	ID          uint      `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Name        string    `json:"name"`
+	Icon        string    `json:"icon"`
+	Color       string    `json:"color"`
+	Balance     float64   `json:"balance"`
+	IsDefault   bool      `json:"is_default"`
+	Description string    `json:"description"`
+	Deleted     bool      `json:"deleted,omitempty"` // Deleted but still referenced by transactions
+}
+
+type BackupCategory struct {
+	ID          uint      `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Name        string    `json:"name"`
+	Icon        string    `json:"icon"`
+	Color       string    `json:"color"`
+	Type        string    `json:"type"`
+	IsDefault   bool      `json:"is_default"`
+	IsEssential bool      `json:"is_essential"`
+	Deleted     bool      `json:"deleted,omitempty"` // Deleted but still referenced by transactions or budgets
+}
+
+type BackupTransaction struct {
+	ID             uint      `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	CategoryID     uint      `json:"category_id"`
+	WalletID       uint      `json:"wallet_id"`
+	Amount         float64   `json:"amount"`
+	OriginalAmount float64   `json:"original_amount"`
+	Currency       string    `json:"currency"`
+	ExchangeRate   float64   `json:"exchange_rate"`
+	Type           string    `json:"type"`
+	Description    string    `json:"description"`
+	Date           time.Time `json:"date"`
+	Notes          string    `json:"notes"`
+	ProofURL       string    `json:"proof_url"`
+	RefundOfID     *uint     `json:"refund_of_id,omitempty"`
+	ExternalID     string    `json:"external_id,omitempty"`
+
+	// Recurring transaction that generated this one and the occurrence it posted
+	RecurringID    *uint      `json:"recurring_id,omitempty"`
+	OccurrenceDate *time.Time `json:"occurrence_date,omitempty"`
+}
+
+type BackupBudget struct {
+	ID         uint      `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	CategoryID uint      `json:"category_id"`
+	Amount     float64   `json:"amount"`
+	Period     string    `json:"period"`
+	StartDate  time.Time `json:"start_date"`
+
+	RolloverPolicy string   `json:"rollover_policy,omitempty"`
+	RolloverCap    *float64 `json:"rollover_cap,omitempty"`
+	Mode           string   `json:"mode,omitempty"`
+	Deleted        bool     `json:"deleted,omitempty"` // Deleted but still referenced by envelope history
+}
+
+// BackupBudgetPeriod is the stored result of one window of a budget
+type BackupBudgetPeriod struct {
+	CreatedAt       time.Time `json:"created_at"`
+	BudgetID        uint      `json:"budget_id"`
+	PeriodStart     time.Time `json:"start"`
+	PeriodEnd       time.Time `json:"end"`
+	Amount          float64   `json:"amount"`
+	RolloverPolicy  string    `json:"rollover_policy,omitempty"`
+	RolloverCap     *float64  `json:"rollover_cap,omitempty"`
+	CarriedIn       float64   `json:"carried_in"`
+	EffectiveAmount float64   `json:"effective_amount"`
+	Spent           float64   `json:"spent"`
+	CarriedOut      float64   `json:"carried_out"`
+}
+
+// BackupEnvelopeMonth is a rolled over month of envelope budgeting
+type BackupEnvelopeMonth struct {
+	CreatedAt    time.Time `json:"created_at"`
+	Month        time.Time `json:"month"`
+	CarriedIn    float64   `json:"carried_in"`
+	Income       float64   `json:"income"`
+	Assigned     float64   `json:"assigned"`
+	Overspent    float64   `json:"overspent"`
+	ToBeBudgeted float64   `json:"to_be_budgeted"`
+	Closed       bool      `json:"closed"`
+}
+
+type BackupEnvelopeAssignment struct {
+	CreatedAt time.Time `json:"created_at"`
+	BudgetID  uint      `json:"budget_id"`
+	Month     time.Time `json:"month"`
+	Assigned  float64   `json:"assigned"`
+}
+
+type BackupEnvelopeBalance struct {
+	CreatedAt  time.Time `json:"created_at"`
+	BudgetID   uint      `json:"budget_id"`
+	CategoryID uint      `json:"category_id"`
+	Month      time.Time `json:"month"`
+	CarriedIn  float64   `json:"carried_in"`
+	Assigned   float64   `json:"assigned"`
+	Spent      float64   `json:"spent"`
+	Available  float64   `json:"available"`
+}
+
+// BackupEnvelopeMove is money moved between envelopes; a nil side is the pool
+type BackupEnvelopeMove struct {
+	CreatedAt    time.Time `json:"created_at"`
+	Month        time.Time `json:"month"`
+	FromBudgetID *uint     `json:"from_budget_id,omitempty"`
+	ToBudgetID   *uint     `json:"to_budget_id,omitempty"`
+	Amount       float64   `json:"amount"`
+	Note         string    `json:"note"`
+}
+
+type BackupGoal struct {
+	ID            uint       `json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	Name          string     `json:"name"`
+	TargetAmount  float64    `json:"target_amount"`
+	CurrentAmount float64    `json:"current_amount"`
+	Deadline      *time.Time `json:"deadline"`
+	Icon          string     `json:"icon"`
+	Color         string     `json:"color"`
+	Description   string     `json:"description"`
+}
+
+type BackupGoalItem struct {
+	ID             uint      `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	GoalID         uint      `json:"goal_id"`
+	Name           string    `json:"name"`
+	EstimatedType  string    `json:"estimated_type"`
+	EstimatedPrice float64   `json:"estimated_price"`
+	ActualPrice    float64   `json:"actual_price"`
+	IsPurchased    bool      `json:"is_purchased"`
+	Note           string    `json:"note"`
+}
+
+// BackupGoalMember identifies other members by email; Self marks the
+// exporting user, who becomes the restoring user.
+type BackupGoalMember struct {
+	GoalID   uint      `json:"goal_id"`
+	Email    string    `json:"email,omitempty"`
+	Self     bool      `json:"self"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// BackupGoalContribution is a GoalTransaction, attributed like BackupGoalMember
+type BackupGoalContribution struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	GoalID    uint      `json:"goal_id"`
+	Email     string    `json:"email,omitempty"`
+	Self      bool      `json:"self"`
+	Amount    float64   `json:"amount"`
+	Date      time.Time `json:"date"`
+	Notes     string    `json:"notes"`
+}
+
+type BackupRecurringTransaction struct {
+	ID                  uint       `json:"id"`
+	CreatedAt           time.Time  `json:"created_at"`
+	WalletID            uint       `json:"wallet_id"`
+	CategoryID          uint       `json:"category_id"`
+	Amount              float64    `json:"amount"`
+	Type                string     `json:"type"`
+	Description         string     `json:"description"`
+	Frequency           string     `json:"frequency"`
+	RRule               string     `json:"rrule,omitempty"`
+	BacklogPolicy       string     `json:"backlog_policy,omitempty"`
+	TargetWalletID      *uint      `json:"target_wallet_id,omitempty"`
+	AmountType          string     `json:"amount_type,omitempty"`
+	RequireConfirmation bool       `json:"require_confirmation,omitempty"`
+	EstimateMethod      string     `json:"estimate_method,omitempty"`
+	EstimateWindow      int        `json:"estimate_window,omitempty"`
+	StartDate           time.Time  `json:"start_date"`
+	NextRunDate         time.Time  `json:"next_run_date"`
+	IsActive            bool       `json:"is_active"`
+	LastRunDate         *time.Time `json:"last_run_date"`
+}
+
+// BackupRecurringOccurrence is what happened to one occurrence of a
+// recurring transaction. A posted one is linked on restore to the
+// transaction carrying the same rule and occurrence date.
+type BackupRecurringOccurrence struct {
+	CreatedAt      time.Time  `json:"created_at"`
+	RecurringID    uint       `json:"recurring_id"`
+	OccurrenceDate time.Time  `json:"occurrence_date"`
+	Status         string     `json:"status"`
+	SnoozedUntil   *time.Time `json:"snoozed_until,omitempty"`
+	Amount         float64    `json:"amount"`
+	Estimated      bool       `json:"estimated,omitempty"`
+}
+
+type BackupDebt struct {
+	ID          uint       `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Type        string     `json:"type"`
+	PersonName  string     `json:"person_name"`
+	Amount      float64    `json:"amount"`
+	Description string     `json:"description"`
+	DueDate     *time.Time `json:"due_date"`
+	Status      string     `json:"status"`
+}
+
+// BackupBadge refers to a global badge by its unique name
+type BackupBadge struct {
+	Name     string    `json:"name"`
+	EarnedAt time.Time `json:"earned_at"`
+}
+
+type BackupImportProfile struct {
+	ID                uint      `json:"id"`
+	CreatedAt         time.Time `json:"created_at"`
+	Name              string    `json:"name"`
+	Bank              string    `json:"bank"`
+	Delimiter         string    `json:"delimiter"`
+	Encoding          string    `json:"encoding"`
+	DateFormat        string    `json:"date_format"`
+	DecimalSeparator  string    `json:"decimal_separator"`
+	HasHeader         bool      `json:"has_header"`
+	SkipRows          int       `json:"skip_rows"`
+	DateColumn        string    `json:"date_column"`
+	DescriptionColumn string    `json:"description_column"`
+	AmountColumn      string    `json:"amount_column"`
+	DebitColumn       string    `json:"debit_column"`
+	CreditColumn      string    `json:"credit_column"`
+	TypeColumn        string    `json:"type_column"`
+	CategoryColumn    string    `json:"category_column"`
+	NotesColumn       string    `json:"notes_column"`
+	CurrencyColumn    string    `json:"currency_column"`
+}
+
+// BackupStreamRecord is one line of a streamed backup (NDJSON). The first
+// line is a "header" record, then one line per record with Data holding a
+// Backup* value, and finally an "end" record so a truncated file is noticed.
+type BackupStreamRecord struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type BackupStreamHeader struct {
+	Version    int       `json:"version"`
+	ExportDate time.Time `json:"export_date"`
+}
+
+type BackupStreamEnd struct {
+	Records int `json:"records"` // Records between header and end
+}
@@ -12,16 +12,28 @@ type Budget struct {
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 
-	UserID     uint    `gorm:"not null" json:"user_id"`
-	CategoryID uint    `gorm:"not null" json:"category_id"`
-	Amount     float64 `gorm:"not null" json:"amount"`
-	Period     string  `gorm:"not null;default:'monthly'" json:"period"` // monthly, weekly, yearly
-	StartDate  time.Time `json:"start_date"`
+	UserID     uint      `gorm:"not null" json:"user_id"`
+	CategoryID uint      `gorm:"not null" json:"category_id"`
+	Amount     float64   `gorm:"not null" json:"amount"`
+	Period     string    `gorm:"not null;default:'monthly'" json:"period"` // monthly, weekly, yearly
+	StartDate  time.Time `json:"start_date"`                               // Anchors the windows, e.g. the 25th for payday months; zero follows the calendar
+	// What's left at the end of a window carries into the next: none,
+	// unspent (leftovers add to it), overspent (overspending is deducted) or
+	// both; RolloverCap limits how much carries either way
+	RolloverPolicy string   `gorm:"default:'none'" json:"rollover_policy"`
+	RolloverCap    *float64 `json:"rollover_cap"`
+	// cap limits spending to Amount each window; envelope makes it a monthly
+	// envelope that is funded by assigning money, see EnvelopeMonth
+	Mode string `gorm:"default:'cap'" json:"mode"`
 
 	// Computed fields (not stored in DB)
-	Spent      float64 `gorm:"-" json:"spent"`
-	Remaining  float64 `gorm:"-" json:"remaining"`
-	Percentage float64 `gorm:"-" json:"percentage"`
+	PeriodStart     time.Time `gorm:"-" json:"period_start"` // Window the spending covers
+	PeriodEnd       time.Time `gorm:"-" json:"period_end"`
+	CarriedIn       float64   `gorm:"-" json:"carried_in"`       // Rolled over from the window before
+	EffectiveAmount float64   `gorm:"-" json:"effective_amount"` // Amount plus CarriedIn
+	Spent           float64   `gorm:"-" json:"spent"`
+	Remaining       float64   `gorm:"-" json:"remaining"`
+	Percentage      float64   `gorm:"-" json:"percentage"`
 
 	// Relations
 	User     User     `gorm:"foreignKey:UserID" json:"-"`
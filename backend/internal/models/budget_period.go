@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// BudgetPeriod is the result of one window of a budget. The base amount and
+// rollover settings are pinned once the window has ended, so later edits of
+// the budget don't rewrite how past windows carried over.
+type BudgetPeriod struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	BudgetID    uint      `gorm:"not null;uniqueIndex:idx_budget_period" json:"budget_id"`
+	UserID      uint      `gorm:"not null;index" json:"user_id"`
+	PeriodStart time.Time `gorm:"not null;uniqueIndex:idx_budget_period" json:"start"`
+	PeriodEnd   time.Time `gorm:"not null" json:"end"` // Last second of the window
+
+	Amount         float64  `gorm:"not null" json:"amount"` // Budget amount at the time
+	RolloverPolicy string   `json:"rollover_policy"`
+	RolloverCap    *float64 `json:"rollover_cap"`
+
+	CarriedIn       float64 `json:"carried_in"`       // From the window before, negative for overspending
+	EffectiveAmount float64 `json:"effective_amount"` // Amount plus CarriedIn
+	Spent           float64 `json:"spent"`
+	CarriedOut      float64 `json:"carried_out"` // Into the next window
+
+	// Computed fields (not stored in DB)
+	Remaining  float64 `gorm:"-" json:"remaining"`
+	Percentage float64 `gorm:"-" json:"percentage"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// CalendarFeed is a user's subscribable iCalendar feed. The URL carries a
+// secret token; only its hash is stored, and rotating or deleting the feed
+// revokes the old URL.
+type CalendarFeed struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID         uint       `gorm:"uniqueIndex;not null" json:"user_id"`
+	TokenHash      string     `gorm:"uniqueIndex;not null" json:"-"`  // SHA-256 of the token, hex
+	Months         int        `gorm:"default:3" json:"months"`        // Rolling window ahead of today
+	AlarmMinutes   int        `gorm:"default:0" json:"alarm_minutes"` // Reminder before the start of the day, 0 = none
+	LastAccessedAt *time.Time `json:"last_accessed_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
@@ -0,0 +1,72 @@
+package models
+
+import "time"
+
+// Envelope budgeting: income goes into a to-be-budgeted pool each month, the
+// user assigns money from it to envelopes, budgets with Mode envelope, and
+// spending in an envelope's category draws it down. Months are stored with
+// their first day.
+
+// EnvelopeMonth is the to-be-budgeted pool of one month. Stored rows are
+// months that were rolled over; the open months are worked out from them.
+type EnvelopeMonth struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID       uint      `gorm:"not null;uniqueIndex:idx_envelope_month" json:"user_id"`
+	Month        time.Time `gorm:"not null;uniqueIndex:idx_envelope_month" json:"month"`
+	CarriedIn    float64   `json:"carried_in"` // Left to be budgeted the month before, negative when over-assigned
+	Income       float64   `json:"income"`
+	Assigned     float64   `json:"assigned"`       // Net assigned to envelopes this month
+	Overspent    float64   `json:"overspent"`      // Envelope overspending of the month before, taken from the pool
+	ToBeBudgeted float64   `json:"to_be_budgeted"` // CarriedIn + Income - Assigned - Overspent
+	Closed       bool      `json:"closed"`
+
+	Envelopes []EnvelopeBalance `gorm:"-" json:"envelopes"`
+}
+
+// EnvelopeAssignment is the money assigned to an envelope in a month, net of
+// what was moved out of it
+type EnvelopeAssignment struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID   uint      `gorm:"not null;index" json:"user_id"`
+	BudgetID uint      `gorm:"not null;uniqueIndex:idx_envelope_assignment" json:"budget_id"`
+	Month    time.Time `gorm:"not null;uniqueIndex:idx_envelope_assignment" json:"month"`
+	Assigned float64   `json:"assigned"`
+}
+
+// EnvelopeBalance is where an envelope stood at the end of a month. Stored
+// when the month is rolled over; what's available carries into the next
+// month, and overspending is taken from the next month's pool instead.
+type EnvelopeBalance struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID     uint      `gorm:"not null;index" json:"user_id"`
+	BudgetID   uint      `gorm:"not null;uniqueIndex:idx_envelope_balance" json:"budget_id"`
+	CategoryID uint      `gorm:"not null" json:"category_id"`
+	Month      time.Time `gorm:"not null;uniqueIndex:idx_envelope_balance" json:"month"`
+	CarriedIn  float64   `json:"carried_in"`
+	Assigned   float64   `json:"assigned"`
+	Spent      float64   `json:"spent"`     // Expenses less refunds in the category
+	Available  float64   `json:"available"` // CarriedIn + Assigned - Spent
+
+	Category Category `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
+}
+
+// EnvelopeMove records money moved in a month between envelopes, or between
+// an envelope and the to-be-budgeted pool when one side is nil
+type EnvelopeMove struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID       uint      `gorm:"not null;index" json:"user_id"`
+	Month        time.Time `gorm:"not null;index" json:"month"`
+	FromBudgetID *uint     `json:"from_budget_id"` // nil is the pool
+	ToBudgetID   *uint     `json:"to_budget_id"`   // nil is the pool
+	Amount       float64   `gorm:"not null" json:"amount"`
+	Note         string    `json:"note"`
+}
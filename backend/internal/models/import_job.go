@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+)
+
+// ImportJob tracks a streamed backup import. Records are committed in
+// batches together with ProcessedRecords, so a failed job can be resumed from
+// the last committed batch.
+type ImportJob struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID           uint       `gorm:"not null;index" json:"user_id"`
+	Status           string     `gorm:"size:20;not null" json:"status"` // pending, running, failed, completed
+	FileName         string     `json:"file_name"`
+	FilePath         string     `json:"-"`                      // Uploaded file, kept until the job completes
+	TotalRecords     int        `json:"total_records"`          // Counted when the file is validated
+	ProcessedRecords int        `json:"processed_records"`      // Committed so far
+	Cleared          bool       `json:"-"`                      // Existing data has been deleted
+	Snapshot         string     `json:"snapshot,omitempty"`     // Pre-import snapshot name
+	Error            string     `json:"error,omitempty"`        // Why the last run failed
+	CompletedAt      *time.Time `json:"completed_at,omitempty"` // When the last batch was committed
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// ImportIDMap records which primary key a backup record got during an import
+// job. Restored refunds keep the backup ID of their original in RefundOfID
+// until the whole file is in.
+type ImportIDMap struct {
+	JobID      uint   `gorm:"primaryKey" json:"job_id"`
+	Entity     string `gorm:"primaryKey;size:20" json:"entity"` // wallet, category, goal, transaction
+	OldID      uint   `gorm:"primaryKey" json:"old_id"`
+	NewID      uint   `gorm:"not null" json:"new_id"`
+	RefundOfID *uint  `json:"refund_of_id,omitempty"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ImportProfile is a saved CSV column mapping for a bank's export format,
+// e.g. BCA, Mandiri or Jenius statements.
+type ImportProfile struct {
+	ID        uint           `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	UserID           uint   `gorm:"not null" json:"user_id"`
+	Name             string `gorm:"not null" json:"name"`
+	Bank             string `json:"bank"`              // BCA, Mandiri, Jenius, ...
+	Delimiter        string `json:"delimiter"`         // Empty = auto-detect
+	Encoding         string `json:"encoding"`          // utf-8, utf-16le, utf-16be, windows-1252; empty = auto-detect
+	DateFormat       string `json:"date_format"`       // Go time layout; empty = auto-detect
+	DecimalSeparator string `json:"decimal_separator"` // "." or ","; empty = auto-detect
+	HasHeader        bool   `gorm:"default:true" json:"has_header"`
+	SkipRows         int    `gorm:"default:0" json:"skip_rows"` // Preamble lines before the header (account info, etc)
+
+	// Column mapping by header name ("Column 1", "Column 2", ... when the file has no header)
+	DateColumn        string `json:"date_column"`
+	DescriptionColumn string `json:"description_column"`
+	AmountColumn      string `json:"amount_column"` // Signed amount, or unsigned together with TypeColumn / a CR/DB suffix
+	DebitColumn       string `json:"debit_column"`  // Money out, when the bank uses separate columns
+	CreditColumn      string `json:"credit_column"` // Money in
+	TypeColumn        string `json:"type_column"`   // e.g. DB/CR, debit/kredit, expense/income
+	CategoryColumn    string `json:"category_column"`
+	NotesColumn       string `json:"notes_column"`
+	CurrencyColumn    string `json:"currency_column"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
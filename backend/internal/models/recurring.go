@@ -12,20 +12,38 @@ type RecurringTransaction struct {
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 
-	UserID      uint       `gorm:"not null" json:"user_id"`
-	WalletID    uint       `gorm:"not null" json:"wallet_id"`
-	CategoryID  uint       `gorm:"not null" json:"category_id"`
-	Amount      float64    `gorm:"not null" json:"amount"`
-	Type        string     `gorm:"not null" json:"type"` // income, expense
-	Description string     `json:"description"`
-	Frequency   string     `gorm:"not null" json:"frequency"` // daily, weekly, monthly, yearly
-	StartDate   time.Time  `gorm:"not null" json:"start_date"`
-	NextRunDate time.Time  `gorm:"not null" json:"next_run_date"`
-	IsActive    bool       `gorm:"default:true" json:"is_active"`
-	LastRunDate *time.Time `json:"last_run_date"`
+	UserID         uint       `gorm:"not null" json:"user_id"`
+	WalletID       uint       `gorm:"not null" json:"wallet_id"`
+	TargetWalletID *uint      `json:"target_wallet_id"` // Transfers move the amount from WalletID to here
+	CategoryID     uint       `gorm:"not null" json:"category_id"`
+	Amount         float64    `gorm:"not null" json:"amount"`
+	AmountType     string     `gorm:"default:'fixed'" json:"amount_type"` // fixed, percent_balance (Amount is a % of WalletID's balance when posted), estimated
+	Type           string     `gorm:"not null" json:"type"`               // income, expense, transfer
+	Description    string     `json:"description"`
+	Frequency      string     `gorm:"not null" json:"frequency"` // daily, weekly, monthly, yearly
+	RRule          string     `json:"rrule"`                     // RRULE-like schedule anchored at StartDate, see pkg/rrule; empty on older rules
+	StartDate      time.Time  `gorm:"not null" json:"start_date"`
+	NextRunDate    time.Time  `gorm:"not null" json:"next_run_date"`
+	IsActive       bool       `gorm:"default:true" json:"is_active"`
+	LastRunDate    *time.Time `json:"last_run_date"`
+	// What to do with occurrences missed while nothing was running, e.g.
+	// during downtime: all posts them, latest posts only the most recent one
+	// and review queues the older ones for the user to post or dismiss
+	BacklogPolicy string `gorm:"default:'all'" json:"backlog_policy"`
+	// Due occurrences wait in the pending queue until the user confirms,
+	// skips or snoozes them, instead of being posted automatically
+	RequireConfirmation bool `json:"require_confirmation"`
+	// Estimated amounts, e.g. utility bills, are worked out from the actual
+	// amounts of the last EstimateWindow posted occurrences with
+	// EstimateMethod (average, median or last); Amount is the first guess
+	EstimateMethod string `gorm:"default:'average'" json:"estimate_method"`
+	EstimateWindow int    `gorm:"default:3" json:"estimate_window"`
+	// What the next occurrence is expected to come to, filled in when listed
+	EstimatedAmount *float64 `gorm:"-" json:"estimated_amount,omitempty"`
 
 	// Relationships
-	User     User     `gorm:"foreignKey:UserID" json:"-"`
-	Wallet   Wallet   `gorm:"foreignKey:WalletID" json:"wallet"`
-	Category Category `gorm:"foreignKey:CategoryID" json:"category"`
+	User         User     `gorm:"foreignKey:UserID" json:"-"`
+	Wallet       Wallet   `gorm:"foreignKey:WalletID" json:"wallet"`
+	Category     Category `gorm:"foreignKey:CategoryID" json:"category"`
+	TargetWallet *Wallet  `gorm:"foreignKey:TargetWalletID" json:"target_wallet,omitempty"`
 }
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// RecurringOccurrence records what happened to one occurrence of a recurring
+// transaction. Its key is unique, so an occurrence can only ever be handled
+// once, whichever process or request gets there first.
+type RecurringOccurrence struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Key            string     `gorm:"uniqueIndex;not null" json:"key"` // recurring:<id>:<YYYY-MM-DD>
+	RecurringID    uint       `gorm:"not null;index" json:"recurring_id"`
+	UserID         uint       `gorm:"not null;index" json:"user_id"`
+	OccurrenceDate time.Time  `gorm:"not null" json:"occurrence_date"`
+	Status         string     `gorm:"not null;default:'posted';index" json:"status"` // posted, skipped, review, pending
+	SnoozedUntil   *time.Time `json:"snoozed_until"`                                 // Hidden from the queue until then
+	Amount         float64    `json:"amount"`                                        // Amount posted, 0 when not posted
+	TransactionID  *uint      `json:"transaction_id"`                                // The expense leg for transfers
+	Estimated      bool       `json:"estimated"`                                     // Posted with an estimate nobody has corrected yet
+
+	Recurring   *RecurringTransaction `gorm:"foreignKey:RecurringID" json:"recurring,omitempty"`
+	Transaction *Transaction          `gorm:"foreignKey:TransactionID" json:"transaction,omitempty"`
+}
+
+// RecurringOccurrenceKey is the idempotency key of the occurrence of a
+// recurring transaction due on date
+func RecurringOccurrenceKey(recurringID uint, date time.Time) string {
+	return fmt.Sprintf("recurring:%d:%s", recurringID, date.Format("2006-01-02"))
+}
+
+// RecurringRun is the log of one pass of the recurring scheduler
+type RecurringRun struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	StartedAt  time.Time  `gorm:"not null;index" json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+
+	Trigger string `gorm:"not null" json:"trigger"` // schedule, user
+	UserID  *uint  `gorm:"index" json:"user_id"`    // Set when the run was for a single user
+	Due     int    `json:"due"`                     // Recurring transactions found due
+	Posted  int    `json:"posted"`                  // Occurrences posted by this run
+	Skipped int    `json:"skipped"`                 // Already handled by another run
+	Missed  int    `json:"missed"`                  // Older occurrences dropped by the latest policy
+	Queued  int    `json:"queued"`                  // Occurrences queued for review or confirmation
+	Failed  int    `json:"failed"`
+	Error   string `json:"error,omitempty"` // Last error, if any
+}
@@ -12,23 +12,28 @@ type Transaction struct {
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 
-	UserID         uint      `gorm:"not null" json:"user_id"`
-	CategoryID     uint      `gorm:"not null" json:"category_id"`
-	WalletID       uint      `gorm:"not null" json:"wallet_id"`
-	Amount         float64   `gorm:"not null" json:"amount"`         // Amount in IDR (converted)
-	OriginalAmount float64   `json:"original_amount"`                // Amount in original currency
-	Currency       string    `gorm:"default:'IDR'" json:"currency"`  // Currency code (IDR, USD, etc)
-	ExchangeRate   float64   `gorm:"default:1" json:"exchange_rate"` // Rate used for conversion
-	Type           string    `gorm:"not null" json:"type"`           // income, expense
-	Description    string    `json:"description"`
-	Date           time.Time `gorm:"not null" json:"date"`
-	Notes          string    `json:"notes"`
-	ProofURL       string    `json:"proof_url"` // Optional proof image URL
+	UserID         uint       `gorm:"not null" json:"user_id"`
+	CategoryID     uint       `gorm:"not null" json:"category_id"`
+	WalletID       uint       `gorm:"not null" json:"wallet_id"`
+	Amount         float64    `gorm:"not null" json:"amount"`         // Amount in IDR (converted)
+	OriginalAmount float64    `json:"original_amount"`                // Amount in original currency
+	Currency       string     `gorm:"default:'IDR'" json:"currency"`  // Currency code (IDR, USD, etc)
+	ExchangeRate   float64    `gorm:"default:1" json:"exchange_rate"` // Rate used for conversion
+	Type           string     `gorm:"not null" json:"type"`           // income, expense, refund
+	Description    string     `json:"description"`
+	Date           time.Time  `gorm:"not null" json:"date"`
+	Notes          string     `json:"notes"`
+	ProofURL       string     `json:"proof_url"`                           // Optional proof image URL
+	RefundOfID     *uint      `gorm:"index" json:"refund_of_id,omitempty"` // Original expense for refund transactions
+	ExternalID     string     `gorm:"index" json:"external_id,omitempty"`  // Bank-provided ID (e.g. OFX FITID) used to skip re-imports
+	RecurringID    *uint      `gorm:"index" json:"recurring_id,omitempty"` // Recurring transaction that generated this one
+	OccurrenceDate *time.Time `json:"occurrence_date,omitempty"`           // Scheduled date of the generating occurrence
 
 	// Relations
-	User     User     `gorm:"foreignKey:UserID" json:"-"`
-	Category Category `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
-	Wallet   Wallet   `gorm:"foreignKey:WalletID" json:"wallet,omitempty"`
+	User     User         `gorm:"foreignKey:UserID" json:"-"`
+	Category Category     `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
+	Wallet   Wallet       `gorm:"foreignKey:WalletID" json:"wallet,omitempty"`
+	RefundOf *Transaction `gorm:"foreignKey:RefundOfID" json:"refund_of,omitempty"`
 }
 
 type TransactionSummary struct {
@@ -0,0 +1,819 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/money-management/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// maxMergeChanges caps the change list of a merge report; counts are always complete
+const maxMergeChanges = 500
+
+// MergeCounts tallies what a merge does with one kind of record
+type MergeCounts struct {
+	Create int `json:"create"`
+	Update int `json:"update"`
+	Skip   int `json:"skip"`
+}
+
+type MergeChange struct {
+	Entity string   `json:"entity"`
+	Action string   `json:"action"` // create, update
+	Name   string   `json:"name"`
+	Fields []string `json:"fields,omitempty"` // Changed fields for updates
+}
+
+// MergeReport describes a merge. With Applied false nothing was written.
+type MergeReport struct {
+	Applied   bool                    `json:"applied"`
+	Entities  map[string]*MergeCounts `json:"entities"`
+	Changes   []MergeChange           `json:"changes"`
+	Truncated bool                    `json:"truncated"` // More changes than listed
+}
+
+func (m *MergeReport) record(entity, action, name string, fields ...string) {
+	counts, ok := m.Entities[entity]
+	if !ok {
+		counts = &MergeCounts{}
+		m.Entities[entity] = counts
+	}
+	switch action {
+	case "create":
+		counts.Create++
+	case "update":
+		counts.Update++
+	default:
+		counts.Skip++
+		return
+	}
+	if len(m.Changes) >= maxMergeChanges {
+		m.Truncated = true
+		return
+	}
+	m.Changes = append(m.Changes, MergeChange{Entity: entity, Action: action, Name: name, Fields: fields})
+}
+
+// errMergeDryRun rolls back a dry-run merge
+var errMergeDryRun = errors.New("merge dry run")
+
+// Merge adds a backup to the user's existing data without deleting
+// anything. Records are matched by natural keys: wallets by name, categories
+// by name and type, transactions by wallet, day, type, amount and
+// description (or bank ID). Matches are updated when they differ, the rest
+// is created. Unless apply is set the merge runs in a transaction that is
+// rolled back, so the report shows exactly what applying would do.
+func (r *BackupRepository) Merge(userID uint, b *models.Backup, apply bool) (*MergeReport, error) {
+	report := &MergeReport{Entities: make(map[string]*MergeCounts), Changes: []MergeChange{}}
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := mergeBackup(tx, userID, b, report); err != nil {
+			return err
+		}
+		if !apply {
+			return errMergeDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errMergeDryRun) {
+		return nil, err
+	}
+	report.Applied = apply
+	return report, nil
+}
+
+func mergeKey(parts ...string) string {
+	for i, p := range parts {
+		parts[i] = strings.ToLower(strings.TrimSpace(p))
+	}
+	return strings.Join(parts, "|")
+}
+
+func transactionFingerprint(walletID uint, date time.Time, transactionType string, amount float64, description string) string {
+	return mergeKey(fmt.Sprint(walletID), date.Format("2006-01-02"), transactionType, fmt.Sprintf("%.2f", amount), description)
+}
+
+func mergeBackup(tx *gorm.DB, userID uint, b *models.Backup, report *MergeReport) error {
+	ids := newBackupIDMap()
+
+	// Wallets by name. Existing wallets keep their balance and later move by
+	// the transactions added to them; new wallets take the backup balance,
+	// which already includes their transactions.
+	var wallets []models.Wallet
+	if err := tx.Where("user_id = ?", userID).Find(&wallets).Error; err != nil {
+		return err
+	}
+	walletsByName := make(map[string]*models.Wallet)
+	for i := range wallets {
+		walletsByName[mergeKey(wallets[i].Name)] = &wallets[i]
+	}
+	existingWallets := make(map[uint]bool)
+	for _, w := range b.Wallets {
+		if existing, ok := walletsByName[mergeKey(w.Name)]; ok {
+			var changed []string
+			if w.Icon != "" && existing.Icon != w.Icon {
+				existing.Icon = w.Icon
+				changed = append(changed, "icon")
+			}
+			if w.Color != "" && existing.Color != w.Color {
+				existing.Color = w.Color
+				changed = append(changed, "color")
+			}
+			if w.Description != "" && existing.Description != w.Description {
+				existing.Description = w.Description
+				changed = append(changed, "description")
+			}
+			if err := saveMerged(tx, report, "wallets", w.Name, existing, changed); err != nil {
+				return err
+			}
+			ids.wallets[w.ID] = existing.ID
+			existingWallets[existing.ID] = true
+			continue
+		}
+
+		wallet := models.Wallet{
+			UserID: userID, Name: w.Name, Icon: w.Icon, Color: w.Color,
+			Balance: w.Balance, Description: w.Description, // Never steal the default flag
+		}
+		if err := tx.Create(&wallet).Error; err != nil {
+			return fmt.Errorf("wallet %q: %w", w.Name, err)
+		}
+		if w.Deleted {
+			if err := tx.Delete(&wallet).Error; err != nil {
+				return err
+			}
+		}
+		walletsByName[mergeKey(w.Name)] = &wallet
+		ids.wallets[w.ID] = wallet.ID
+		report.record("wallets", "create", w.Name)
+	}
+
+	// Categories by name and type
+	var categories []models.Category
+	if err := tx.Where("user_id = ?", userID).Find(&categories).Error; err != nil {
+		return err
+	}
+	categoriesByKey := make(map[string]*models.Category)
+	for i := range categories {
+		categoriesByKey[mergeKey(categories[i].Name, categories[i].Type)] = &categories[i]
+	}
+	for _, c := range b.Categories {
+		key := mergeKey(c.Name, c.Type)
+		if existing, ok := categoriesByKey[key]; ok {
+			var changed []string
+			if c.Icon != "" && existing.Icon != c.Icon {
+				existing.Icon = c.Icon
+				changed = append(changed, "icon")
+			}
+			if c.Color != "" && existing.Color != c.Color {
+				existing.Color = c.Color
+				changed = append(changed, "color")
+			}
+			if existing.IsEssential != c.IsEssential {
+				existing.IsEssential = c.IsEssential
+				changed = append(changed, "is_essential")
+			}
+			if err := saveMerged(tx, report, "categories", c.Name, existing, changed); err != nil {
+				return err
+			}
+			ids.categories[c.ID] = existing.ID
+			continue
+		}
+
+		category := models.Category{
+			UserID: userID, Name: c.Name, Icon: c.Icon, Color: c.Color,
+			Type: c.Type, IsDefault: c.IsDefault, IsEssential: c.IsEssential,
+		}
+		if err := tx.Create(&category).Error; err != nil {
+			return fmt.Errorf("category %q: %w", c.Name, err)
+		}
+		if !c.IsEssential {
+			if err := tx.Model(&category).Update("is_essential", false).Error; err != nil {
+				return err
+			}
+		}
+		if c.Deleted {
+			if err := tx.Delete(&category).Error; err != nil {
+				return err
+			}
+		}
+		categoriesByKey[key] = &category
+		ids.categories[c.ID] = category.ID
+		report.record("categories", "create", c.Name)
+	}
+
+	// Budgets by category and period
+	var budgets []models.Budget
+	if err := tx.Where("user_id = ?", userID).Find(&budgets).Error; err != nil {
+		return err
+	}
+	budgetsByKey := make(map[string]*models.Budget)
+	for i := range budgets {
+		budgetsByKey[mergeKey(fmt.Sprint(budgets[i].CategoryID), budgets[i].Period)] = &budgets[i]
+	}
+	for _, bu := range b.Budgets {
+		categoryID := ids.categories[bu.CategoryID]
+		name := fmt.Sprintf("%s budget for category %d", bu.Period, categoryID)
+		// Deleted budgets only carry envelope history and are never matched
+		if existing, ok := budgetsByKey[mergeKey(fmt.Sprint(categoryID), bu.Period)]; ok && !bu.Deleted {
+			var changed []string
+			if existing.Amount != bu.Amount {
+				existing.Amount = bu.Amount
+				changed = append(changed, "amount")
+			}
+			if err := saveMerged(tx, report, "budgets", name, existing, changed); err != nil {
+				return err
+			}
+			ids.budgets[bu.ID] = existing.ID
+			continue
+		}
+		budget := models.Budget{
+			UserID: userID, CategoryID: categoryID, Amount: bu.Amount, Period: bu.Period, StartDate: bu.StartDate,
+			RolloverPolicy: bu.RolloverPolicy, RolloverCap: bu.RolloverCap, Mode: bu.Mode,
+		}
+		if err := tx.Create(&budget).Error; err != nil {
+			return fmt.Errorf("budget %d: %w", bu.ID, err)
+		}
+		if bu.Deleted {
+			if err := tx.Delete(&budget).Error; err != nil {
+				return err
+			}
+		}
+		ids.budgets[bu.ID] = budget.ID
+		report.record("budgets", "create", name)
+	}
+
+	// Budget periods by budget and start; windows already stored keep their result
+	var storedPeriods []models.BudgetPeriod
+	if err := tx.Select("budget_id, period_start").Where("user_id = ?", userID).Find(&storedPeriods).Error; err != nil {
+		return err
+	}
+	periodKey := func(budgetID uint, start time.Time) string {
+		return fmt.Sprintf("%d|%d", budgetID, start.Unix())
+	}
+	storedPeriodKeys := make(map[string]bool)
+	for _, p := range storedPeriods {
+		storedPeriodKeys[periodKey(p.BudgetID, p.PeriodStart)] = true
+	}
+	for _, p := range b.BudgetPeriods {
+		budgetID := ids.budgets[p.BudgetID]
+		name := fmt.Sprintf("budget %d period from %s", budgetID, p.PeriodStart.Format("2006-01-02"))
+		if storedPeriodKeys[periodKey(budgetID, p.PeriodStart)] {
+			report.record("budget_periods", "skip", name)
+			continue
+		}
+		period := models.BudgetPeriod{
+			BudgetID: budgetID, UserID: userID, PeriodStart: p.PeriodStart, PeriodEnd: p.PeriodEnd,
+			Amount: p.Amount, RolloverPolicy: p.RolloverPolicy, RolloverCap: p.RolloverCap, CarriedIn: p.CarriedIn,
+			EffectiveAmount: p.EffectiveAmount, Spent: p.Spent, CarriedOut: p.CarriedOut,
+		}
+		if err := tx.Create(&period).Error; err != nil {
+			return fmt.Errorf("budget period %d: %w", p.BudgetID, err)
+		}
+		storedPeriodKeys[periodKey(budgetID, p.PeriodStart)] = true
+		report.record("budget_periods", "create", name)
+	}
+
+	if err := mergeEnvelopes(tx, userID, b, ids, report); err != nil {
+		return err
+	}
+
+	if err := mergeGoals(tx, userID, b, ids, report); err != nil {
+		return err
+	}
+
+	// Recurring transactions by wallet, category, description, amount and frequency
+	var recurring []models.RecurringTransaction
+	if err := tx.Where("user_id = ?", userID).Find(&recurring).Error; err != nil {
+		return err
+	}
+	recurringByKey := make(map[string]uint)
+	for _, rt := range recurring {
+		recurringByKey[mergeKey(fmt.Sprint(rt.WalletID), fmt.Sprint(rt.CategoryID), rt.Description, fmt.Sprintf("%.2f", rt.Amount), rt.Frequency)] = rt.ID
+	}
+	for _, rt := range b.RecurringTransactions {
+		walletID, categoryID := ids.wallets[rt.WalletID], ids.categories[rt.CategoryID]
+		key := mergeKey(fmt.Sprint(walletID), fmt.Sprint(categoryID), rt.Description, fmt.Sprintf("%.2f", rt.Amount), rt.Frequency)
+		if existingID, ok := recurringByKey[key]; ok {
+			ids.recurring[rt.ID] = existingID
+			report.record("recurring_transactions", "skip", rt.Description)
+			continue
+		}
+		item := models.RecurringTransaction{
+			UserID: userID, WalletID: walletID, CategoryID: categoryID,
+			Amount: rt.Amount, Type: rt.Type, Description: rt.Description, Frequency: rt.Frequency, RRule: rt.RRule,
+			BacklogPolicy: rt.BacklogPolicy, TargetWalletID: ids.wallet(rt.TargetWalletID),
+			AmountType: rt.AmountType, RequireConfirmation: rt.RequireConfirmation,
+			EstimateMethod: rt.EstimateMethod, EstimateWindow: rt.EstimateWindow,
+			StartDate: rt.StartDate, NextRunDate: rt.NextRunDate, IsActive: rt.IsActive, LastRunDate: rt.LastRunDate,
+		}
+		if err := tx.Create(&item).Error; err != nil {
+			return fmt.Errorf("recurring transaction %d: %w", rt.ID, err)
+		}
+		if !rt.IsActive {
+			if err := tx.Model(&item).Update("is_active", false).Error; err != nil {
+				return err
+			}
+		}
+		recurringByKey[key] = item.ID
+		ids.recurring[rt.ID] = item.ID
+		report.record("recurring_transactions", "create", rt.Description)
+	}
+
+	// Transactions after recurring transactions, which they may link to
+	if err := mergeTransactions(tx, userID, b, ids, existingWallets, report); err != nil {
+		return err
+	}
+
+	// Recurring occurrences by rule and date; an occurrence is only ever handled once
+	var occurrenceKeys []string
+	if err := tx.Model(&models.RecurringOccurrence{}).Where("user_id = ?", userID).Pluck("key", &occurrenceKeys).Error; err != nil {
+		return err
+	}
+	handled := make(map[string]bool)
+	for _, key := range occurrenceKeys {
+		handled[key] = true
+	}
+	for _, o := range b.RecurringOccurrences {
+		recurringID := ids.recurring[o.RecurringID]
+		key := models.RecurringOccurrenceKey(recurringID, o.OccurrenceDate)
+		if handled[key] {
+			report.record("recurring_occurrences", "skip", key)
+			continue
+		}
+		occurrence := models.RecurringOccurrence{
+			Key: key, RecurringID: recurringID, UserID: userID, OccurrenceDate: o.OccurrenceDate,
+			Status: o.Status, SnoozedUntil: o.SnoozedUntil, Amount: o.Amount, Estimated: o.Estimated,
+		}
+		if err := tx.Create(&occurrence).Error; err != nil {
+			return fmt.Errorf("recurring occurrence %s: %w", key, err)
+		}
+		handled[key] = true
+		report.record("recurring_occurrences", "create", key)
+	}
+	if err := linkOccurrenceTransactions(tx, userID); err != nil {
+		return err
+	}
+
+	// Debts by person, type and amount
+	var debts []models.Debt
+	if err := tx.Where("user_id = ?", userID).Find(&debts).Error; err != nil {
+		return err
+	}
+	debtsByKey := make(map[string]*models.Debt)
+	for i := range debts {
+		debtsByKey[mergeKey(debts[i].PersonName, debts[i].Type, fmt.Sprintf("%.2f", debts[i].Amount))] = &debts[i]
+	}
+	for _, d := range b.Debts {
+		key := mergeKey(d.PersonName, d.Type, fmt.Sprintf("%.2f", d.Amount))
+		if existing, ok := debtsByKey[key]; ok {
+			var changed []string
+			if existing.Status != d.Status {
+				existing.Status = d.Status
+				changed = append(changed, "status")
+			}
+			if err := saveMerged(tx, report, "debts", d.PersonName, existing, changed); err != nil {
+				return err
+			}
+			continue
+		}
+		debt := models.Debt{
+			UserID: userID, Type: d.Type, PersonName: d.PersonName, Amount: d.Amount,
+			Description: d.Description, DueDate: d.DueDate, Status: d.Status,
+		}
+		if err := tx.Create(&debt).Error; err != nil {
+			return fmt.Errorf("debt %d: %w", d.ID, err)
+		}
+		debtsByKey[key] = &debt
+		report.record("debts", "create", d.PersonName)
+	}
+
+	// Badges the user doesn't have yet
+	for _, badge := range b.Badges {
+		var found models.Badge
+		if tx.Where("name = ?", badge.Name).First(&found).Error != nil {
+			continue
+		}
+		var count int64
+		tx.Model(&models.UserBadge{}).Where("user_id = ? AND badge_id = ?", userID, found.ID).Count(&count)
+		if count > 0 {
+			report.record("badges", "skip", badge.Name)
+			continue
+		}
+		if err := tx.Create(&models.UserBadge{UserID: userID, BadgeID: found.ID, EarnedAt: badge.EarnedAt}).Error; err != nil {
+			return fmt.Errorf("badge %q: %w", badge.Name, err)
+		}
+		report.record("badges", "create", badge.Name)
+	}
+
+	// Import profiles by name; an existing profile keeps its settings
+	var profiles []models.ImportProfile
+	if err := tx.Where("user_id = ?", userID).Find(&profiles).Error; err != nil {
+		return err
+	}
+	profileNames := make(map[string]bool)
+	for _, p := range profiles {
+		profileNames[mergeKey(p.Name)] = true
+	}
+	for _, p := range b.ImportProfiles {
+		if profileNames[mergeKey(p.Name)] {
+			report.record("import_profiles", "skip", p.Name)
+			continue
+		}
+		profile := models.ImportProfile{
+			UserID: userID, Name: p.Name, Bank: p.Bank, Delimiter: p.Delimiter,
+			Encoding: p.Encoding, DateFormat: p.DateFormat, DecimalSeparator: p.DecimalSeparator,
+			HasHeader: p.HasHeader, SkipRows: p.SkipRows, DateColumn: p.DateColumn,
+			DescriptionColumn: p.DescriptionColumn, AmountColumn: p.AmountColumn, DebitColumn: p.DebitColumn,
+			CreditColumn: p.CreditColumn, TypeColumn: p.TypeColumn, CategoryColumn: p.CategoryColumn,
+			NotesColumn: p.NotesColumn, CurrencyColumn: p.CurrencyColumn,
+		}
+		if err := tx.Create(&profile).Error; err != nil {
+			return fmt.Errorf("import profile %q: %w", p.Name, err)
+		}
+		if !p.HasHeader {
+			if err := tx.Model(&profile).Update("has_header", false).Error; err != nil {
+				return err
+			}
+		}
+		profileNames[mergeKey(p.Name)] = true
+		report.record("import_profiles", "create", p.Name)
+	}
+
+	return nil
+}
+
+// saveMerged saves an existing record when fields changed and reports it
+func saveMerged(tx *gorm.DB, report *MergeReport, entity, name string, record interface{}, changed []string) error {
+	if len(changed) == 0 {
+		report.record(entity, "skip", name)
+		return nil
+	}
+	if err := tx.Save(record).Error; err != nil {
+		return fmt.Errorf("%s %q: %w", entity, name, err)
+	}
+	report.record(entity, "update", name, changed...)
+	return nil
+}
+
+// mergeTransactions matches backup transactions against existing ones. Two
+// identical coffees on the same day are two transactions, so fingerprints
+// are counted rather than just looked up.
+func mergeTransactions(tx *gorm.DB, userID uint, b *models.Backup, ids backupIDMap, existingWallets map[uint]bool, report *MergeReport) error {
+	var existing []models.Transaction
+	err := tx.Select("id, wallet_id, category_id, date, type, amount, description, notes, external_id").
+		Where("user_id = ?", userID).Find(&existing).Error
+	if err != nil {
+		return err
+	}
+
+	byFingerprint := make(map[string][]models.Transaction)
+	byExternalID := make(map[string]models.Transaction)
+	for _, t := range existing {
+		key := transactionFingerprint(t.WalletID, t.Date, t.Type, t.Amount, t.Description)
+		byFingerprint[key] = append(byFingerprint[key], t)
+		if t.ExternalID != "" {
+			byExternalID[mergeKey(fmt.Sprint(t.WalletID), t.ExternalID)] = t
+		}
+	}
+	used := make(map[uint]bool)
+
+	var refunds []models.BackupTransaction
+	balanceChanges := make(map[uint]float64)
+	for _, t := range b.Transactions {
+		walletID, categoryID := ids.wallets[t.WalletID], ids.categories[t.CategoryID]
+		name := fmt.Sprintf("%s %s %.2f %s", t.Date.Format("2006-01-02"), t.Type, t.Amount, t.Description)
+
+		var match *models.Transaction
+		if t.ExternalID != "" {
+			if m, ok := byExternalID[mergeKey(fmt.Sprint(walletID), t.ExternalID)]; ok && !used[m.ID] {
+				match = &m
+			}
+		}
+		if match == nil {
+			for _, candidate := range byFingerprint[transactionFingerprint(walletID, t.Date, t.Type, t.Amount, t.Description)] {
+				if !used[candidate.ID] {
+					c := candidate
+					match = &c
+					break
+				}
+			}
+		}
+
+		if match != nil {
+			used[match.ID] = true
+			ids.transactions[t.ID] = match.ID
+
+			updates := map[string]interface{}{}
+			var changed []string
+			if match.CategoryID != categoryID {
+				updates["category_id"] = categoryID
+				changed = append(changed, "category")
+			}
+			if t.Notes != "" && match.Notes != t.Notes {
+				updates["notes"] = t.Notes
+				changed = append(changed, "notes")
+			}
+			if len(changed) == 0 {
+				report.record("transactions", "skip", name)
+				continue
+			}
+			if err := tx.Model(&models.Transaction{}).Where("id = ?", match.ID).Updates(updates).Error; err != nil {
+				return fmt.Errorf("transaction %d: %w", t.ID, err)
+			}
+			report.record("transactions", "update", name, changed...)
+			continue
+		}
+
+		transaction := models.Transaction{
+			UserID: userID, CategoryID: categoryID, WalletID: walletID,
+			Amount: t.Amount, OriginalAmount: t.OriginalAmount, Currency: t.Currency, ExchangeRate: t.ExchangeRate,
+			Type: t.Type, Description: t.Description, Date: t.Date, Notes: t.Notes, ProofURL: t.ProofURL,
+			ExternalID: t.ExternalID, RecurringID: ids.recurringRule(t.RecurringID), OccurrenceDate: t.OccurrenceDate,
+		}
+		if err := tx.Create(&transaction).Error; err != nil {
+			return fmt.Errorf("transaction %d: %w", t.ID, err)
+		}
+		ids.transactions[t.ID] = transaction.ID
+		if t.RefundOfID != nil {
+			refunds = append(refunds, t)
+		}
+		if existingWallets[walletID] {
+			if t.Type == "expense" {
+				balanceChanges[walletID] -= t.Amount
+			} else {
+				balanceChanges[walletID] += t.Amount
+			}
+		}
+		report.record("transactions", "create", name)
+	}
+
+	for _, t := range refunds {
+		err := tx.Model(&models.Transaction{}).Where("id = ?", ids.transactions[t.ID]).
+			Update("refund_of_id", ids.transactions[*t.RefundOfID]).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	for walletID, amount := range balanceChanges {
+		err := tx.Model(&models.Wallet{}).Where("id = ?", walletID).
+			UpdateColumn("balance", gorm.Expr("balance + ?", amount)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeEnvelopes adds envelope months, assignments and balances the user
+// doesn't have for that month yet, and moves not recorded yet
+func mergeEnvelopes(tx *gorm.DB, userID uint, b *models.Backup, ids backupIDMap, report *MergeReport) error {
+	monthKey := func(budgetID uint, month time.Time) string {
+		return fmt.Sprintf("%d|%s", budgetID, month.Format("2006-01"))
+	}
+
+	var months []models.EnvelopeMonth
+	if err := tx.Where("user_id = ?", userID).Find(&months).Error; err != nil {
+		return err
+	}
+	storedMonths := make(map[string]bool)
+	for _, m := range months {
+		storedMonths[m.Month.Format("2006-01")] = true
+	}
+	for _, m := range b.EnvelopeMonths {
+		name := m.Month.Format("2006-01")
+		if storedMonths[name] {
+			report.record("envelope_months", "skip", name)
+			continue
+		}
+		month := models.EnvelopeMonth{
+			UserID: userID, Month: m.Month, CarriedIn: m.CarriedIn, Income: m.Income,
+			Assigned: m.Assigned, Overspent: m.Overspent, ToBeBudgeted: m.ToBeBudgeted, Closed: m.Closed,
+		}
+		if err := tx.Create(&month).Error; err != nil {
+			return fmt.Errorf("envelope month %s: %w", name, err)
+		}
+		storedMonths[name] = true
+		report.record("envelope_months", "create", name)
+	}
+
+	var assignments []models.EnvelopeAssignment
+	if err := tx.Where("user_id = ?", userID).Find(&assignments).Error; err != nil {
+		return err
+	}
+	storedAssignments := make(map[string]bool)
+	for _, a := range assignments {
+		storedAssignments[monthKey(a.BudgetID, a.Month)] = true
+	}
+	for _, a := range b.EnvelopeAssignments {
+		budgetID := ids.budgets[a.BudgetID]
+		name := fmt.Sprintf("budget %d in %s", budgetID, a.Month.Format("2006-01"))
+		if storedAssignments[monthKey(budgetID, a.Month)] {
+			report.record("envelope_assignments", "skip", name)
+			continue
+		}
+		assignment := models.EnvelopeAssignment{UserID: userID, BudgetID: budgetID, Month: a.Month, Assigned: a.Assigned}
+		if err := tx.Create(&assignment).Error; err != nil {
+			return fmt.Errorf("envelope assignment %s: %w", name, err)
+		}
+		storedAssignments[monthKey(budgetID, a.Month)] = true
+		report.record("envelope_assignments", "create", name)
+	}
+
+	var balances []models.EnvelopeBalance
+	if err := tx.Where("user_id = ?", userID).Find(&balances).Error; err != nil {
+		return err
+	}
+	storedBalances := make(map[string]bool)
+	for _, e := range balances {
+		storedBalances[monthKey(e.BudgetID, e.Month)] = true
+	}
+	for _, e := range b.EnvelopeBalances {
+		budgetID := ids.budgets[e.BudgetID]
+		name := fmt.Sprintf("budget %d in %s", budgetID, e.Month.Format("2006-01"))
+		if storedBalances[monthKey(budgetID, e.Month)] {
+			report.record("envelope_balances", "skip", name)
+			continue
+		}
+		balance := models.EnvelopeBalance{
+			UserID: userID, BudgetID: budgetID, CategoryID: ids.categories[e.CategoryID], Month: e.Month,
+			CarriedIn: e.CarriedIn, Assigned: e.Assigned, Spent: e.Spent, Available: e.Available,
+		}
+		if err := tx.Omit("Category").Create(&balance).Error; err != nil {
+			return fmt.Errorf("envelope balance %s: %w", name, err)
+		}
+		storedBalances[monthKey(budgetID, e.Month)] = true
+		report.record("envelope_balances", "create", name)
+	}
+
+	// Moves by month, envelopes, amount, note and time
+	moveKey := func(m models.EnvelopeMove) string {
+		side := func(id *uint) string {
+			if id == nil {
+				return "pool"
+			}
+			return fmt.Sprint(*id)
+		}
+		return mergeKey(m.Month.Format("2006-01"), side(m.FromBudgetID), side(m.ToBudgetID),
+			fmt.Sprintf("%.2f", m.Amount), m.Note, fmt.Sprint(m.CreatedAt.Unix()))
+	}
+	var moves []models.EnvelopeMove
+	if err := tx.Where("user_id = ?", userID).Find(&moves).Error; err != nil {
+		return err
+	}
+	storedMoves := make(map[string]bool)
+	for _, m := range moves {
+		storedMoves[moveKey(m)] = true
+	}
+	for _, m := range b.EnvelopeMoves {
+		move := models.EnvelopeMove{
+			CreatedAt: m.CreatedAt, UserID: userID, Month: m.Month,
+			FromBudgetID: ids.budget(m.FromBudgetID), ToBudgetID: ids.budget(m.ToBudgetID), Amount: m.Amount, Note: m.Note,
+		}
+		name := fmt.Sprintf("%.2f in %s", m.Amount, m.Month.Format("2006-01"))
+		key := moveKey(move)
+		if storedMoves[key] {
+			report.record("envelope_moves", "skip", name)
+			continue
+		}
+		if err := tx.Create(&move).Error; err != nil {
+			return fmt.Errorf("envelope move %s: %w", name, err)
+		}
+		storedMoves[key] = true
+		report.record("envelope_moves", "create", name)
+	}
+	return nil
+}
+
+// mergeGoals matches goals by name, their items by name and contributions
+// by day, amount and notes
+func mergeGoals(tx *gorm.DB, userID uint, b *models.Backup, ids backupIDMap, report *MergeReport) error {
+	var goals []models.Goal
+	if err := tx.Where("user_id = ?", userID).Find(&goals).Error; err != nil {
+		return err
+	}
+	goalsByName := make(map[string]*models.Goal)
+	for i := range goals {
+		goalsByName[mergeKey(goals[i].Name)] = &goals[i]
+	}
+
+	existingGoals := make(map[uint]bool)
+	for _, g := range b.Goals {
+		if existing, ok := goalsByName[mergeKey(g.Name)]; ok {
+			var changed []string
+			if existing.TargetAmount != g.TargetAmount {
+				existing.TargetAmount = g.TargetAmount
+				changed = append(changed, "target_amount")
+			}
+			if g.Deadline != nil && (existing.Deadline == nil || !existing.Deadline.Equal(*g.Deadline)) {
+				existing.Deadline = g.Deadline
+				changed = append(changed, "deadline")
+			}
+			if err := saveMerged(tx, report, "goals", g.Name, existing, changed); err != nil {
+				return err
+			}
+			ids.goals[g.ID] = existing.ID
+			existingGoals[existing.ID] = true
+			continue
+		}
+		goal := models.Goal{
+			UserID: userID, Name: g.Name, TargetAmount: g.TargetAmount, CurrentAmount: g.CurrentAmount,
+			Deadline: g.Deadline, Icon: g.Icon, Color: g.Color, Description: g.Description,
+		}
+		if err := tx.Create(&goal).Error; err != nil {
+			return fmt.Errorf("goal %q: %w", g.Name, err)
+		}
+		goalsByName[mergeKey(g.Name)] = &goal
+		ids.goals[g.ID] = goal.ID
+		report.record("goals", "create", g.Name)
+	}
+
+	// Members only come along with new goals; shared goals that already
+	// exist keep their membership
+	memberUser := goalMemberResolver(tx, userID)
+	for _, m := range b.GoalMembers {
+		goalID := ids.goals[m.GoalID]
+		if existingGoals[goalID] {
+			continue
+		}
+		memberID, ok := memberUser(m.Self, m.Email)
+		if !ok {
+			continue
+		}
+		if err := tx.Create(&models.GoalMember{GoalID: goalID, UserID: memberID, Role: m.Role, JoinedAt: m.JoinedAt}).Error; err != nil {
+			return fmt.Errorf("goal member: %w", err)
+		}
+	}
+
+	var items []models.GoalItem
+	if err := tx.Where("goal_id IN (SELECT id FROM goals WHERE user_id = ?)", userID).Find(&items).Error; err != nil {
+		return err
+	}
+	itemKeys := make(map[string]bool)
+	for _, i := range items {
+		itemKeys[mergeKey(fmt.Sprint(i.GoalID), i.Name)] = true
+	}
+	for _, i := range b.GoalItems {
+		goalID := ids.goals[i.GoalID]
+		key := mergeKey(fmt.Sprint(goalID), i.Name)
+		if itemKeys[key] {
+			report.record("goal_items", "skip", i.Name)
+			continue
+		}
+		item := models.GoalItem{
+			GoalID: goalID, Name: i.Name, EstimatedType: i.EstimatedType, EstimatedPrice: i.EstimatedPrice,
+			ActualPrice: i.ActualPrice, IsPurchased: i.IsPurchased, Note: i.Note,
+		}
+		if err := tx.Create(&item).Error; err != nil {
+			return fmt.Errorf("goal item %q: %w", i.Name, err)
+		}
+		itemKeys[key] = true
+		report.record("goal_items", "create", i.Name)
+	}
+
+	var contributions []models.GoalTransaction
+	if err := tx.Where("goal_id IN (SELECT id FROM goals WHERE user_id = ?)", userID).Find(&contributions).Error; err != nil {
+		return err
+	}
+	contributionCounts := make(map[string]int)
+	for _, c := range contributions {
+		contributionCounts[mergeKey(fmt.Sprint(c.GoalID), c.Date.Format("2006-01-02"), fmt.Sprintf("%.2f", c.Amount), c.Notes)]++
+	}
+	added := make(map[uint]float64)
+	for _, c := range b.GoalContributions {
+		goalID := ids.goals[c.GoalID]
+		key := mergeKey(fmt.Sprint(goalID), c.Date.Format("2006-01-02"), fmt.Sprintf("%.2f", c.Amount), c.Notes)
+		name := fmt.Sprintf("%s %.2f", c.Date.Format("2006-01-02"), c.Amount)
+		if contributionCounts[key] > 0 {
+			contributionCounts[key]--
+			report.record("goal_contributions", "skip", name)
+			continue
+		}
+		contributorID, ok := memberUser(c.Self, c.Email)
+		if !ok {
+			contributorID = userID
+		}
+		contribution := models.GoalTransaction{GoalID: goalID, UserID: contributorID, Amount: c.Amount, Date: c.Date, Notes: c.Notes}
+		if err := tx.Create(&contribution).Error; err != nil {
+			return fmt.Errorf("goal contribution %d: %w", c.ID, err)
+		}
+		if existingGoals[goalID] {
+			added[goalID] += c.Amount
+		}
+		report.record("goal_contributions", "create", name)
+	}
+
+	// New goals carry their saved amount over; existing ones grow by what was added
+	for goalID, amount := range added {
+		err := tx.Model(&models.Goal{}).Where("id = ?", goalID).
+			UpdateColumn("current_amount", gorm.Expr("current_amount + ?", amount)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,1038 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/money-management/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+type BackupRepository struct {
+	db *gorm.DB
+}
+
+func NewBackupRepository(db *gorm.DB) *BackupRepository {
+	return &BackupRepository{db: db}
+}
+
+// Export collects every record the user owns into one Backup
+func (r *BackupRepository) Export(userID uint) (*models.Backup, error) {
+	b := newBackup()
+	err := r.ExportEach(userID, func(record interface{}) error {
+		addBackupRecord(b, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// newBackup returns an empty backup of the current version
+func newBackup() *models.Backup {
+	return &models.Backup{
+		Version:               models.BackupVersion,
+		ExportDate:            time.Now(),
+		Wallets:               []models.BackupWallet{},
+		Categories:            []models.BackupCategory{},
+		Transactions:          []models.BackupTransaction{},
+		Budgets:               []models.BackupBudget{},
+		BudgetPeriods:         []models.BackupBudgetPeriod{},
+		EnvelopeMonths:        []models.BackupEnvelopeMonth{},
+		EnvelopeAssignments:   []models.BackupEnvelopeAssignment{},
+		EnvelopeBalances:      []models.BackupEnvelopeBalance{},
+		EnvelopeMoves:         []models.BackupEnvelopeMove{},
+		Goals:                 []models.BackupGoal{},
+		GoalItems:             []models.BackupGoalItem{},
+		GoalMembers:           []models.BackupGoalMember{},
+		GoalContributions:     []models.BackupGoalContribution{},
+		RecurringTransactions: []models.BackupRecurringTransaction{},
+		RecurringOccurrences:  []models.BackupRecurringOccurrence{},
+		Debts:                 []models.BackupDebt{},
+		Badges:                []models.BackupBadge{},
+		ImportProfiles:        []models.BackupImportProfile{},
+	}
+}
+
+// addBackupRecord adds a record as passed by ExportEach to b
+func addBackupRecord(b *models.Backup, record interface{}) {
+	switch v := record.(type) {
+	case *models.BackupProfile:
+		b.Profile = *v
+	case *models.BackupWallet:
+		b.Wallets = append(b.Wallets, *v)
+	case *models.BackupCategory:
+		b.Categories = append(b.Categories, *v)
+	case *models.BackupTransaction:
+		b.Transactions = append(b.Transactions, *v)
+	case *models.BackupBudget:
+		b.Budgets = append(b.Budgets, *v)
+	case *models.BackupBudgetPeriod:
+		b.BudgetPeriods = append(b.BudgetPeriods, *v)
+	case *models.BackupEnvelopeMonth:
+		b.EnvelopeMonths = append(b.EnvelopeMonths, *v)
+	case *models.BackupEnvelopeAssignment:
+		b.EnvelopeAssignments = append(b.EnvelopeAssignments, *v)
+	case *models.BackupEnvelopeBalance:
+		b.EnvelopeBalances = append(b.EnvelopeBalances, *v)
+	case *models.BackupEnvelopeMove:
+		b.EnvelopeMoves = append(b.EnvelopeMoves, *v)
+	case *models.BackupGoal:
+		b.Goals = append(b.Goals, *v)
+	case *models.BackupGoalItem:
+		b.GoalItems = append(b.GoalItems, *v)
+	case *models.BackupGoalMember:
+		b.GoalMembers = append(b.GoalMembers, *v)
+	case *models.BackupGoalContribution:
+		b.GoalContributions = append(b.GoalContributions, *v)
+	case *models.BackupRecurringTransaction:
+		b.RecurringTransactions = append(b.RecurringTransactions, *v)
+	case *models.BackupRecurringOccurrence:
+		b.RecurringOccurrences = append(b.RecurringOccurrences, *v)
+	case *models.BackupDebt:
+		b.Debts = append(b.Debts, *v)
+	case *models.BackupBadge:
+		b.Badges = append(b.Badges, *v)
+	case *models.BackupImportProfile:
+		b.ImportProfiles = append(b.ImportProfiles, *v)
+	}
+}
+
+// ExportEach passes every record the user owns to emit, one at a time, as a
+// pointer to its Backup* type. Records come in dependency order (wallets and
+// categories before the records referring to them) and large tables are
+// read in batches, so a whole history never has to be in memory. Goals
+// shared with the user by someone else belong to the owner's backup and are
+// left out.
+func (r *BackupRepository) ExportEach(userID uint, emit func(record interface{}) error) error {
+	var user models.User
+	if err := r.db.First(&user, userID).Error; err != nil {
+		return err
+	}
+	err := emit(&models.BackupProfile{
+		CurrentStreak:       user.CurrentStreak,
+		LongestStreak:       user.LongestStreak,
+		LastTransactionDate: user.LastTransactionDate,
+		Level:               user.Level,
+		XP:                  user.XP,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Deleted wallets, categories and budgets are kept when live records or
+	// envelope history still point to them
+	keptBudgets := func() *gorm.DB {
+		return r.db.Unscoped().Model(&models.Budget{}).
+			Where("user_id = ?", userID).
+			Where("deleted_at IS NULL OR id IN (SELECT budget_id FROM envelope_assignments WHERE user_id = ?) OR id IN (SELECT budget_id FROM envelope_balances WHERE user_id = ?) OR id IN (SELECT from_budget_id FROM envelope_moves WHERE user_id = ?) OR id IN (SELECT to_budget_id FROM envelope_moves WHERE user_id = ?)", userID, userID, userID, userID)
+	}
+
+	var wallets []models.Wallet
+	err = r.db.Unscoped().
+		Where("user_id = ?", userID).
+		Where("deleted_at IS NULL OR id IN (SELECT wallet_id FROM transactions WHERE user_id = ? AND deleted_at IS NULL) OR id IN (SELECT wallet_id FROM recurring_transactions WHERE user_id = ? AND deleted_at IS NULL) OR id IN (SELECT target_wallet_id FROM recurring_transactions WHERE user_id = ? AND deleted_at IS NULL)", userID, userID, userID).
+		Order("id").Find(&wallets).Error
+	if err != nil {
+		return err
+	}
+	for _, w := range wallets {
+		err := emit(&models.BackupWallet{
+			ID: w.ID, CreatedAt: w.CreatedAt, Name: w.Name, Icon: w.Icon, Color: w.Color,
+			Balance: w.Balance, IsDefault: w.IsDefault, Description: w.Description, Deleted: w.DeletedAt.Valid,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var categories []models.Category
+	err = r.db.Unscoped().
+		Where("user_id = ?", userID).
+		Where("deleted_at IS NULL OR id IN (SELECT category_id FROM transactions WHERE user_id = ? AND deleted_at IS NULL) OR id IN (?) OR id IN (SELECT category_id FROM envelope_balances WHERE user_id = ?) OR id IN (SELECT category_id FROM recurring_transactions WHERE user_id = ? AND deleted_at IS NULL)", userID, keptBudgets().Select("category_id"), userID, userID).
+		Order("id").Find(&categories).Error
+	if err != nil {
+		return err
+	}
+	for _, c := range categories {
+		err := emit(&models.BackupCategory{
+			ID: c.ID, CreatedAt: c.CreatedAt, Name: c.Name, Icon: c.Icon, Color: c.Color,
+			Type: c.Type, IsDefault: c.IsDefault, IsEssential: c.IsEssential, Deleted: c.DeletedAt.Valid,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var goals []models.Goal
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&goals).Error; err != nil {
+		return err
+	}
+	for _, g := range goals {
+		err := emit(&models.BackupGoal{
+			ID: g.ID, CreatedAt: g.CreatedAt, Name: g.Name, TargetAmount: g.TargetAmount,
+			CurrentAmount: g.CurrentAmount, Deadline: g.Deadline, Icon: g.Icon, Color: g.Color,
+			Description: g.Description,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	const ownedGoals = "goal_id IN (SELECT id FROM goals WHERE user_id = ? AND deleted_at IS NULL)"
+
+	var items []models.GoalItem
+	if err := r.db.Where(ownedGoals, userID).Order("id").Find(&items).Error; err != nil {
+		return err
+	}
+	for _, i := range items {
+		err := emit(&models.BackupGoalItem{
+			ID: i.ID, CreatedAt: i.CreatedAt, GoalID: i.GoalID, Name: i.Name, EstimatedType: i.EstimatedType,
+			EstimatedPrice: i.EstimatedPrice, ActualPrice: i.ActualPrice, IsPurchased: i.IsPurchased, Note: i.Note,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var members []models.GoalMember
+	if err := r.db.Preload("User").Where(ownedGoals, userID).Find(&members).Error; err != nil {
+		return err
+	}
+	for _, m := range members {
+		member := &models.BackupGoalMember{GoalID: m.GoalID, Role: m.Role, JoinedAt: m.JoinedAt, Self: m.UserID == userID}
+		if !member.Self {
+			member.Email = m.User.Email
+		}
+		if err := emit(member); err != nil {
+			return err
+		}
+	}
+
+	var contributions []models.GoalTransaction
+	err = r.db.Preload("User").Where(ownedGoals, userID).FindInBatches(&contributions, 1000, func(tx *gorm.DB, _ int) error {
+		for _, c := range contributions {
+			contribution := &models.BackupGoalContribution{
+				ID: c.ID, CreatedAt: c.CreatedAt, GoalID: c.GoalID, Self: c.UserID == userID,
+				Amount: c.Amount, Date: c.Date, Notes: c.Notes,
+			}
+			if !contribution.Self {
+				contribution.Email = c.User.Email
+			}
+			if err := emit(contribution); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	var budgets []models.Budget
+	if err := keptBudgets().Order("id").Find(&budgets).Error; err != nil {
+		return err
+	}
+	for _, bu := range budgets {
+		err := emit(&models.BackupBudget{
+			ID: bu.ID, CreatedAt: bu.CreatedAt, CategoryID: bu.CategoryID,
+			Amount: bu.Amount, Period: bu.Period, StartDate: bu.StartDate,
+			RolloverPolicy: bu.RolloverPolicy, RolloverCap: bu.RolloverCap, Mode: bu.Mode, Deleted: bu.DeletedAt.Valid,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var periods []models.BudgetPeriod
+	err = r.db.Where("user_id = ? AND budget_id IN (SELECT id FROM budgets WHERE user_id = ? AND deleted_at IS NULL)", userID, userID).
+		Order("budget_id, period_start").Find(&periods).Error
+	if err != nil {
+		return err
+	}
+	for _, p := range periods {
+		err := emit(&models.BackupBudgetPeriod{
+			CreatedAt: p.CreatedAt, BudgetID: p.BudgetID, PeriodStart: p.PeriodStart, PeriodEnd: p.PeriodEnd,
+			Amount: p.Amount, RolloverPolicy: p.RolloverPolicy, RolloverCap: p.RolloverCap, CarriedIn: p.CarriedIn,
+			EffectiveAmount: p.EffectiveAmount, Spent: p.Spent, CarriedOut: p.CarriedOut,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var months []models.EnvelopeMonth
+	if err := r.db.Where("user_id = ?", userID).Order("month").Find(&months).Error; err != nil {
+		return err
+	}
+	for _, m := range months {
+		err := emit(&models.BackupEnvelopeMonth{
+			CreatedAt: m.CreatedAt, Month: m.Month, CarriedIn: m.CarriedIn, Income: m.Income,
+			Assigned: m.Assigned, Overspent: m.Overspent, ToBeBudgeted: m.ToBeBudgeted, Closed: m.Closed,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var assignments []models.EnvelopeAssignment
+	if err := r.db.Where("user_id = ?", userID).Order("month, budget_id").Find(&assignments).Error; err != nil {
+		return err
+	}
+	for _, a := range assignments {
+		err := emit(&models.BackupEnvelopeAssignment{CreatedAt: a.CreatedAt, BudgetID: a.BudgetID, Month: a.Month, Assigned: a.Assigned})
+		if err != nil {
+			return err
+		}
+	}
+
+	var balances []models.EnvelopeBalance
+	if err := r.db.Where("user_id = ?", userID).Order("month, budget_id").Find(&balances).Error; err != nil {
+		return err
+	}
+	for _, e := range balances {
+		err := emit(&models.BackupEnvelopeBalance{
+			CreatedAt: e.CreatedAt, BudgetID: e.BudgetID, CategoryID: e.CategoryID, Month: e.Month,
+			CarriedIn: e.CarriedIn, Assigned: e.Assigned, Spent: e.Spent, Available: e.Available,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var moves []models.EnvelopeMove
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&moves).Error; err != nil {
+		return err
+	}
+	for _, m := range moves {
+		err := emit(&models.BackupEnvelopeMove{
+			CreatedAt: m.CreatedAt, Month: m.Month, FromBudgetID: m.FromBudgetID, ToBudgetID: m.ToBudgetID,
+			Amount: m.Amount, Note: m.Note,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var recurring []models.RecurringTransaction
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&recurring).Error; err != nil {
+		return err
+	}
+	exportedRecurring := make(map[uint]bool)
+	for _, rt := range recurring {
+		exportedRecurring[rt.ID] = true
+		err := emit(&models.BackupRecurringTransaction{
+			ID: rt.ID, CreatedAt: rt.CreatedAt, WalletID: rt.WalletID, CategoryID: rt.CategoryID,
+			Amount: rt.Amount, Type: rt.Type, Description: rt.Description, Frequency: rt.Frequency, RRule: rt.RRule,
+			BacklogPolicy: rt.BacklogPolicy, TargetWalletID: rt.TargetWalletID,
+			AmountType: rt.AmountType, RequireConfirmation: rt.RequireConfirmation,
+			EstimateMethod: rt.EstimateMethod, EstimateWindow: rt.EstimateWindow,
+			StartDate: rt.StartDate, NextRunDate: rt.NextRunDate, IsActive: rt.IsActive, LastRunDate: rt.LastRunDate,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var occurrences []models.RecurringOccurrence
+	err = r.db.Where("user_id = ? AND recurring_id IN (SELECT id FROM recurring_transactions WHERE user_id = ? AND deleted_at IS NULL)", userID, userID).
+		FindInBatches(&occurrences, 1000, func(tx *gorm.DB, _ int) error {
+			for _, o := range occurrences {
+				err := emit(&models.BackupRecurringOccurrence{
+					CreatedAt: o.CreatedAt, RecurringID: o.RecurringID, OccurrenceDate: o.OccurrenceDate,
+					Status: o.Status, SnoozedUntil: o.SnoozedUntil, Amount: o.Amount, Estimated: o.Estimated,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	var debts []models.Debt
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&debts).Error; err != nil {
+		return err
+	}
+	for _, d := range debts {
+		err := emit(&models.BackupDebt{
+			ID: d.ID, CreatedAt: d.CreatedAt, Type: d.Type, PersonName: d.PersonName, Amount: d.Amount,
+			Description: d.Description, DueDate: d.DueDate, Status: d.Status,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var badges []models.BackupBadge
+	err = r.db.Table("user_badges").
+		Select("badges.name, user_badges.earned_at").
+		Joins("JOIN badges ON badges.id = user_badges.badge_id").
+		Where("user_badges.user_id = ?", userID).
+		Scan(&badges).Error
+	if err != nil {
+		return err
+	}
+	for i := range badges {
+		if err := emit(&badges[i]); err != nil {
+			return err
+		}
+	}
+
+	var profiles []models.ImportProfile
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&profiles).Error; err != nil {
+		return err
+	}
+	for _, p := range profiles {
+		err := emit(&models.BackupImportProfile{
+			ID: p.ID, CreatedAt: p.CreatedAt, Name: p.Name, Bank: p.Bank, Delimiter: p.Delimiter,
+			Encoding: p.Encoding, DateFormat: p.DateFormat, DecimalSeparator: p.DecimalSeparator,
+			HasHeader: p.HasHeader, SkipRows: p.SkipRows, DateColumn: p.DateColumn,
+			DescriptionColumn: p.DescriptionColumn, AmountColumn: p.AmountColumn, DebitColumn: p.DebitColumn,
+			CreditColumn: p.CreditColumn, TypeColumn: p.TypeColumn, CategoryColumn: p.CategoryColumn,
+			NotesColumn: p.NotesColumn, CurrencyColumn: p.CurrencyColumn,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Transactions last, they are by far the most records
+	var batch []models.Transaction
+	return r.db.Where("user_id = ?", userID).FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+		for _, t := range batch {
+			transaction := &models.BackupTransaction{
+				ID: t.ID, CreatedAt: t.CreatedAt, CategoryID: t.CategoryID, WalletID: t.WalletID,
+				Amount: t.Amount, OriginalAmount: t.OriginalAmount, Currency: t.Currency, ExchangeRate: t.ExchangeRate,
+				Type: t.Type, Description: t.Description, Date: t.Date, Notes: t.Notes, ProofURL: t.ProofURL,
+				RefundOfID: t.RefundOfID, ExternalID: t.ExternalID,
+			}
+			// The link to a deleted recurring transaction is left out
+			if t.RecurringID != nil && exportedRecurring[*t.RecurringID] {
+				transaction.RecurringID, transaction.OccurrenceDate = t.RecurringID, t.OccurrenceDate
+			}
+			if err := emit(transaction); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
+
+// ValidateBackup checks that every reference inside the backup points to a
+// record that is also in the backup, so a restore can't fail halfway.
+func ValidateBackup(b *models.Backup) error {
+	wallets := make(map[uint]bool)
+	for _, w := range b.Wallets {
+		wallets[w.ID] = true
+	}
+	categories := make(map[uint]bool)
+	for _, c := range b.Categories {
+		categories[c.ID] = true
+	}
+	goals := make(map[uint]bool)
+	for _, g := range b.Goals {
+		goals[g.ID] = true
+	}
+	transactions := make(map[uint]bool)
+	for _, t := range b.Transactions {
+		transactions[t.ID] = true
+	}
+	recurring := make(map[uint]bool)
+	for _, rt := range b.RecurringTransactions {
+		recurring[rt.ID] = true
+	}
+	budgets := make(map[uint]bool)
+	for _, bu := range b.Budgets {
+		budgets[bu.ID] = true
+	}
+
+	for _, t := range b.Transactions {
+		if !wallets[t.WalletID] {
+			return fmt.Errorf("transaction %d refers to missing wallet %d", t.ID, t.WalletID)
+		}
+		if !categories[t.CategoryID] {
+			return fmt.Errorf("transaction %d refers to missing category %d", t.ID, t.CategoryID)
+		}
+		if t.RefundOfID != nil && !transactions[*t.RefundOfID] {
+			return fmt.Errorf("refund %d refers to missing transaction %d", t.ID, *t.RefundOfID)
+		}
+		if t.RecurringID != nil && !recurring[*t.RecurringID] {
+			return fmt.Errorf("transaction %d refers to missing recurring transaction %d", t.ID, *t.RecurringID)
+		}
+	}
+	for _, bu := range b.Budgets {
+		if !categories[bu.CategoryID] {
+			return fmt.Errorf("budget %d refers to missing category %d", bu.ID, bu.CategoryID)
+		}
+	}
+	for _, p := range b.BudgetPeriods {
+		if !budgets[p.BudgetID] {
+			return fmt.Errorf("budget period refers to missing budget %d", p.BudgetID)
+		}
+	}
+	for _, a := range b.EnvelopeAssignments {
+		if !budgets[a.BudgetID] {
+			return fmt.Errorf("envelope assignment refers to missing budget %d", a.BudgetID)
+		}
+	}
+	for _, e := range b.EnvelopeBalances {
+		if !budgets[e.BudgetID] || !categories[e.CategoryID] {
+			return fmt.Errorf("envelope balance of %s refers to a missing budget or category", e.Month.Format("2006-01"))
+		}
+	}
+	for _, m := range b.EnvelopeMoves {
+		if (m.FromBudgetID != nil && !budgets[*m.FromBudgetID]) || (m.ToBudgetID != nil && !budgets[*m.ToBudgetID]) {
+			return fmt.Errorf("envelope move of %s refers to a missing budget", m.Month.Format("2006-01"))
+		}
+	}
+	for _, rt := range b.RecurringTransactions {
+		if !wallets[rt.WalletID] || !categories[rt.CategoryID] || (rt.TargetWalletID != nil && !wallets[*rt.TargetWalletID]) {
+			return fmt.Errorf("recurring transaction %d refers to a missing wallet or category", rt.ID)
+		}
+	}
+	for _, o := range b.RecurringOccurrences {
+		if !recurring[o.RecurringID] {
+			return fmt.Errorf("recurring occurrence refers to missing recurring transaction %d", o.RecurringID)
+		}
+	}
+	for _, i := range b.GoalItems {
+		if !goals[i.GoalID] {
+			return fmt.Errorf("goal item %d refers to missing goal %d", i.ID, i.GoalID)
+		}
+	}
+	for _, m := range b.GoalMembers {
+		if !goals[m.GoalID] {
+			return fmt.Errorf("goal member refers to missing goal %d", m.GoalID)
+		}
+	}
+	for _, c := range b.GoalContributions {
+		if !goals[c.GoalID] {
+			return fmt.Errorf("goal contribution %d refers to missing goal %d", c.ID, c.GoalID)
+		}
+	}
+	return nil
+}
+
+// Replace deletes the user's data and restores the backup in one database
+// transaction. Records get new primary keys; references are remapped.
+func (r *BackupRepository) Replace(userID uint, b *models.Backup) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteUserData(tx, userID); err != nil {
+			return err
+		}
+		return restoreBackup(tx, userID, b)
+	})
+}
+
+// deleteUserData removes everything Export covers, dependents first
+func deleteUserData(tx *gorm.DB, userID uint) error {
+	const ownedGoals = "goal_id IN (SELECT id FROM goals WHERE user_id = ?)"
+
+	steps := []func() error{
+		func() error { return tx.Where(ownedGoals, userID).Delete(&models.GoalItem{}).Error },
+		func() error { return tx.Where(ownedGoals, userID).Delete(&models.GoalTransaction{}).Error },
+		func() error { return tx.Where(ownedGoals, userID).Delete(&models.GoalMember{}).Error },
+		func() error { return tx.Where("user_id = ?", userID).Delete(&models.RecurringOccurrence{}).Error },
+		func() error { return tx.Where("user_id = ?", userID).Delete(&models.RecurringTransaction{}).Error },
+		// Refunds first, they reference other transactions
+		func() error {
+			return tx.Where("user_id = ? AND refund_of_id IS NOT NULL", userID).Delete(&models.Transaction{}).Error
+		},
+		func() error { return tx.Where("user_id = ?", userID).Delete(&models.Transaction{}).Error },
+		func() error { return tx.Where("user_id = ?", userID).Delete(&models.EnvelopeMove{}).Error },
+		func() error { return tx.Where("user_id = ?", userID).Delete(&models.EnvelopeBalance{}).Error },
+		func() error { return tx.Where("user_id = ?", userID).Delete(&models.EnvelopeAssignment{}).Error },
+		func() error { return tx.Where("user_id = ?", userID).Delete(&models.EnvelopeMonth{}).Error },
+		func() error { return tx.Where("user_id = ?", userID).Delete(&models.BudgetPeriod{}).Error },
+		func() error { return tx.Where("user_id = ?", userID).Delete(&models.Budget{}).Error },
+		func() error { return tx.Where("user_id = ?", userID).Delete(&models.Goal{}).Error },
+		func() error { return tx.Where("user_id = ?", userID).Delete(&models.Debt{}).Error },
+		func() error { return tx.Where("user_id = ?", userID).Delete(&models.ImportProfile{}).Error },
+		func() error { return tx.Where("user_id = ?", userID).Delete(&models.UserBadge{}).Error },
+		func() error { return tx.Where("user_id = ?", userID).Delete(&models.Wallet{}).Error },
+		func() error { return tx.Where("user_id = ?", userID).Delete(&models.Category{}).Error },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupIDMap translates backup IDs to the primary keys created on restore
+type backupIDMap struct {
+	wallets, categories, transactions, goals, recurring, budgets map[uint]uint
+}
+
+// wallet translates an optional wallet reference
+func (m backupIDMap) wallet(id *uint) *uint {
+	if id == nil {
+		return nil
+	}
+	mapped := m.wallets[*id]
+	return &mapped
+}
+
+// budget translates an optional budget reference
+func (m backupIDMap) budget(id *uint) *uint {
+	if id == nil {
+		return nil
+	}
+	mapped := m.budgets[*id]
+	return &mapped
+}
+
+// recurringRule translates an optional recurring transaction reference
+func (m backupIDMap) recurringRule(id *uint) *uint {
+	if id == nil {
+		return nil
+	}
+	mapped := m.recurring[*id]
+	return &mapped
+}
+
+func newBackupIDMap() backupIDMap {
+	return backupIDMap{
+		wallets:      make(map[uint]uint),
+		categories:   make(map[uint]uint),
+		transactions: make(map[uint]uint),
+		goals:        make(map[uint]uint),
+		recurring:    make(map[uint]uint),
+		budgets:      make(map[uint]uint),
+	}
+}
+
+// goalMemberResolver maps a backed-up goal member to a user. Other members
+// are matched by email and dropped if they no longer exist.
+func goalMemberResolver(tx *gorm.DB, userID uint) func(self bool, email string) (uint, bool) {
+	usersByEmail := make(map[string]uint)
+	return func(self bool, email string) (uint, bool) {
+		if self {
+			return userID, true
+		}
+		if id, ok := usersByEmail[email]; ok {
+			return id, id != 0
+		}
+		var user models.User
+		if email == "" || tx.Select("id").Where("email = ?", email).First(&user).Error != nil {
+			usersByEmail[email] = 0
+			return 0, false
+		}
+		usersByEmail[email] = user.ID
+		return user.ID, true
+	}
+}
+
+// eachBackupRecord passes the records of b to fn in the order ExportEach
+// produces them
+func eachBackupRecord(b *models.Backup, fn func(record interface{}) error) error {
+	if err := fn(&b.Profile); err != nil {
+		return err
+	}
+	for i := range b.Wallets {
+		if err := fn(&b.Wallets[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.Categories {
+		if err := fn(&b.Categories[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.Goals {
+		if err := fn(&b.Goals[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.GoalItems {
+		if err := fn(&b.GoalItems[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.GoalMembers {
+		if err := fn(&b.GoalMembers[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.GoalContributions {
+		if err := fn(&b.GoalContributions[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.Budgets {
+		if err := fn(&b.Budgets[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.BudgetPeriods {
+		if err := fn(&b.BudgetPeriods[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.EnvelopeMonths {
+		if err := fn(&b.EnvelopeMonths[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.EnvelopeAssignments {
+		if err := fn(&b.EnvelopeAssignments[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.EnvelopeBalances {
+		if err := fn(&b.EnvelopeBalances[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.EnvelopeMoves {
+		if err := fn(&b.EnvelopeMoves[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.RecurringTransactions {
+		if err := fn(&b.RecurringTransactions[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.RecurringOccurrences {
+		if err := fn(&b.RecurringOccurrences[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.Debts {
+		if err := fn(&b.Debts[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.Badges {
+		if err := fn(&b.Badges[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.ImportProfiles {
+		if err := fn(&b.ImportProfiles[i]); err != nil {
+			return err
+		}
+	}
+	for i := range b.Transactions {
+		if err := fn(&b.Transactions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreBackup inserts every record of the backup for the user
+func restoreBackup(tx *gorm.DB, userID uint, b *models.Backup) error {
+	restorer := newBackupRestorer(tx, userID, newBackupIDMap())
+	if err := eachBackupRecord(b, restorer.restore); err != nil {
+		return err
+	}
+
+	// Refunds are linked last, their original may come later
+	for _, refund := range restorer.refunds {
+		err := tx.Model(&models.Transaction{}).Where("id = ?", refund.NewID).
+			Update("refund_of_id", restorer.ids.transactions[*refund.RefundOfID]).Error
+		if err != nil {
+			return err
+		}
+	}
+	return linkOccurrenceTransactions(tx, userID)
+}
+
+// linkOccurrenceTransactions links restored posted occurrences to the
+// transaction they posted, found by rule and occurrence date. Transfers link
+// their expense leg, which is created first.
+func linkOccurrenceTransactions(tx *gorm.DB, userID uint) error {
+	return tx.Exec(`UPDATE recurring_occurrences SET transaction_id = (
+			SELECT MIN(transactions.id) FROM transactions
+			WHERE transactions.recurring_id = recurring_occurrences.recurring_id
+			AND transactions.occurrence_date = recurring_occurrences.occurrence_date
+			AND transactions.deleted_at IS NULL)
+		WHERE user_id = ? AND status = ? AND transaction_id IS NULL`, userID, "posted").Error
+}
+
+// backupRestorer inserts backup records one at a time, translating their
+// references through ids. Records must come in dependency order.
+type backupRestorer struct {
+	tx         *gorm.DB
+	userID     uint
+	ids        backupIDMap
+	memberUser func(self bool, email string) (uint, bool)
+
+	// refunds are restored refunds still to be linked to their original
+	refunds []models.ImportIDMap
+	// With track set, new ID mappings are collected in mapped instead of
+	// ids.transactions so a streamed import can persist them batch by batch
+	track  bool
+	mapped []models.ImportIDMap
+}
+
+func newBackupRestorer(tx *gorm.DB, userID uint, ids backupIDMap) *backupRestorer {
+	return &backupRestorer{tx: tx, userID: userID, ids: ids, memberUser: goalMemberResolver(tx, userID)}
+}
+
+func (r *backupRestorer) mapID(entity string, oldID, newID uint, ids map[uint]uint) {
+	if r.track {
+		r.mapped = append(r.mapped, models.ImportIDMap{Entity: entity, OldID: oldID, NewID: newID})
+		if entity == "transaction" {
+			return
+		}
+	}
+	ids[oldID] = newID
+}
+
+func (r *backupRestorer) restore(record interface{}) error {
+	tx, userID, ids := r.tx, r.userID, r.ids
+
+	switch v := record.(type) {
+	case *models.BackupProfile:
+		return tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"current_streak":        v.CurrentStreak,
+			"longest_streak":        v.LongestStreak,
+			"last_transaction_date": v.LastTransactionDate,
+			"level":                 v.Level,
+			"xp":                    v.XP,
+		}).Error
+
+	case *models.BackupWallet:
+		wallet := models.Wallet{
+			CreatedAt: v.CreatedAt, UserID: userID, Name: v.Name, Icon: v.Icon, Color: v.Color,
+			Balance: v.Balance, IsDefault: v.IsDefault, Description: v.Description,
+		}
+		if err := tx.Create(&wallet).Error; err != nil {
+			return fmt.Errorf("wallet %q: %w", v.Name, err)
+		}
+		if v.Deleted {
+			if err := tx.Delete(&wallet).Error; err != nil {
+				return err
+			}
+		}
+		r.mapID("wallet", v.ID, wallet.ID, ids.wallets)
+
+	case *models.BackupCategory:
+		category := models.Category{
+			CreatedAt: v.CreatedAt, UserID: userID, Name: v.Name, Icon: v.Icon, Color: v.Color,
+			Type: v.Type, IsDefault: v.IsDefault, IsEssential: v.IsEssential,
+		}
+		if err := tx.Create(&category).Error; err != nil {
+			return fmt.Errorf("category %q: %w", v.Name, err)
+		}
+		// gorm skips false for columns with a true default
+		if !v.IsEssential {
+			if err := tx.Model(&category).Update("is_essential", false).Error; err != nil {
+				return err
+			}
+		}
+		if v.Deleted {
+			if err := tx.Delete(&category).Error; err != nil {
+				return err
+			}
+		}
+		r.mapID("category", v.ID, category.ID, ids.categories)
+
+	case *models.BackupTransaction:
+		transaction := models.Transaction{
+			CreatedAt: v.CreatedAt, UserID: userID,
+			CategoryID: ids.categories[v.CategoryID], WalletID: ids.wallets[v.WalletID],
+			Amount: v.Amount, OriginalAmount: v.OriginalAmount, Currency: v.Currency, ExchangeRate: v.ExchangeRate,
+			Type: v.Type, Description: v.Description, Date: v.Date, Notes: v.Notes, ProofURL: v.ProofURL,
+			ExternalID: v.ExternalID, RecurringID: ids.recurringRule(v.RecurringID), OccurrenceDate: v.OccurrenceDate,
+		}
+		if err := tx.Create(&transaction).Error; err != nil {
+			return fmt.Errorf("transaction %d: %w", v.ID, err)
+		}
+		r.mapID("transaction", v.ID, transaction.ID, ids.transactions)
+		if v.RefundOfID != nil {
+			if r.track {
+				r.mapped[len(r.mapped)-1].RefundOfID = v.RefundOfID
+			} else {
+				r.refunds = append(r.refunds, models.ImportIDMap{NewID: transaction.ID, RefundOfID: v.RefundOfID})
+			}
+		}
+
+	case *models.BackupBudget:
+		budget := models.Budget{
+			CreatedAt: v.CreatedAt, UserID: userID, CategoryID: ids.categories[v.CategoryID],
+			Amount: v.Amount, Period: v.Period, StartDate: v.StartDate,
+			RolloverPolicy: v.RolloverPolicy, RolloverCap: v.RolloverCap, Mode: v.Mode,
+		}
+		if err := tx.Create(&budget).Error; err != nil {
+			return fmt.Errorf("budget %d: %w", v.ID, err)
+		}
+		if v.Deleted {
+			if err := tx.Delete(&budget).Error; err != nil {
+				return err
+			}
+		}
+		r.mapID("budget", v.ID, budget.ID, ids.budgets)
+
+	case *models.BackupBudgetPeriod:
+		period := models.BudgetPeriod{
+			CreatedAt: v.CreatedAt, BudgetID: ids.budgets[v.BudgetID], UserID: userID,
+			PeriodStart: v.PeriodStart, PeriodEnd: v.PeriodEnd, Amount: v.Amount,
+			RolloverPolicy: v.RolloverPolicy, RolloverCap: v.RolloverCap, CarriedIn: v.CarriedIn,
+			EffectiveAmount: v.EffectiveAmount, Spent: v.Spent, CarriedOut: v.CarriedOut,
+		}
+		if err := tx.Create(&period).Error; err != nil {
+			return fmt.Errorf("budget period %d: %w", v.BudgetID, err)
+		}
+
+	case *models.BackupEnvelopeMonth:
+		month := models.EnvelopeMonth{
+			CreatedAt: v.CreatedAt, UserID: userID, Month: v.Month, CarriedIn: v.CarriedIn, Income: v.Income,
+			Assigned: v.Assigned, Overspent: v.Overspent, ToBeBudgeted: v.ToBeBudgeted, Closed: v.Closed,
+		}
+		if err := tx.Create(&month).Error; err != nil {
+			return fmt.Errorf("envelope month %s: %w", v.Month.Format("2006-01"), err)
+		}
+
+	case *models.BackupEnvelopeAssignment:
+		assignment := models.EnvelopeAssignment{
+			CreatedAt: v.CreatedAt, UserID: userID, BudgetID: ids.budgets[v.BudgetID], Month: v.Month, Assigned: v.Assigned,
+		}
+		if err := tx.Create(&assignment).Error; err != nil {
+			return fmt.Errorf("envelope assignment %s: %w", v.Month.Format("2006-01"), err)
+		}
+
+	case *models.BackupEnvelopeBalance:
+		balance := models.EnvelopeBalance{
+			CreatedAt: v.CreatedAt, UserID: userID, BudgetID: ids.budgets[v.BudgetID],
+			CategoryID: ids.categories[v.CategoryID], Month: v.Month, CarriedIn: v.CarriedIn,
+			Assigned: v.Assigned, Spent: v.Spent, Available: v.Available,
+		}
+		if err := tx.Omit("Category").Create(&balance).Error; err != nil {
+			return fmt.Errorf("envelope balance %s: %w", v.Month.Format("2006-01"), err)
+		}
+
+	case *models.BackupEnvelopeMove:
+		move := models.EnvelopeMove{
+			CreatedAt: v.CreatedAt, UserID: userID, Month: v.Month,
+			FromBudgetID: ids.budget(v.FromBudgetID), ToBudgetID: ids.budget(v.ToBudgetID), Amount: v.Amount, Note: v.Note,
+		}
+		if err := tx.Create(&move).Error; err != nil {
+			return fmt.Errorf("envelope move %s: %w", v.Month.Format("2006-01"), err)
+		}
+
+	case *models.BackupGoal:
+		goal := models.Goal{
+			CreatedAt: v.CreatedAt, UserID: userID, Name: v.Name, TargetAmount: v.TargetAmount,
+			CurrentAmount: v.CurrentAmount, Deadline: v.Deadline, Icon: v.Icon, Color: v.Color,
+			Description: v.Description,
+		}
+		if err := tx.Create(&goal).Error; err != nil {
+			return fmt.Errorf("goal %q: %w", v.Name, err)
+		}
+		r.mapID("goal", v.ID, goal.ID, ids.goals)
+
+	case *models.BackupGoalItem:
+		item := models.GoalItem{
+			CreatedAt: v.CreatedAt, GoalID: ids.goals[v.GoalID], Name: v.Name, EstimatedType: v.EstimatedType,
+			EstimatedPrice: v.EstimatedPrice, ActualPrice: v.ActualPrice, IsPurchased: v.IsPurchased, Note: v.Note,
+		}
+		if err := tx.Create(&item).Error; err != nil {
+			return fmt.Errorf("goal item %q: %w", v.Name, err)
+		}
+
+	case *models.BackupGoalMember:
+		memberID, ok := r.memberUser(v.Self, v.Email)
+		if !ok {
+			return nil
+		}
+		member := models.GoalMember{GoalID: ids.goals[v.GoalID], UserID: memberID, Role: v.Role, JoinedAt: v.JoinedAt}
+		if err := tx.Create(&member).Error; err != nil {
+			return fmt.Errorf("goal member: %w", err)
+		}
+
+	case *models.BackupGoalContribution:
+		contributorID, ok := r.memberUser(v.Self, v.Email)
+		if !ok {
+			// Keep the amount on the goal, credited to the restoring user
+			contributorID = userID
+		}
+		contribution := models.GoalTransaction{
+			CreatedAt: v.CreatedAt, GoalID: ids.goals[v.GoalID], UserID: contributorID,
+			Amount: v.Amount, Date: v.Date, Notes: v.Notes,
+		}
+		if err := tx.Create(&contribution).Error; err != nil {
+			return fmt.Errorf("goal contribution %d: %w", v.ID, err)
+		}
+
+	case *models.BackupRecurringTransaction:
+		recurring := models.RecurringTransaction{
+			CreatedAt: v.CreatedAt, UserID: userID,
+			WalletID: ids.wallets[v.WalletID], CategoryID: ids.categories[v.CategoryID],
+			Amount: v.Amount, Type: v.Type, Description: v.Description, Frequency: v.Frequency, RRule: v.RRule,
+			BacklogPolicy: v.BacklogPolicy, TargetWalletID: ids.wallet(v.TargetWalletID), AmountType: v.AmountType, RequireConfirmation: v.RequireConfirmation,
+			EstimateMethod: v.EstimateMethod, EstimateWindow: v.EstimateWindow,
+			StartDate: v.StartDate, NextRunDate: v.NextRunDate, IsActive: v.IsActive, LastRunDate: v.LastRunDate,
+		}
+		if err := tx.Create(&recurring).Error; err != nil {
+			return fmt.Errorf("recurring transaction %d: %w", v.ID, err)
+		}
+		r.mapID("recurring", v.ID, recurring.ID, ids.recurring)
+		if !v.IsActive {
+			if err := tx.Model(&recurring).Update("is_active", false).Error; err != nil {
+				return err
+			}
+		}
+
+	case *models.BackupRecurringOccurrence:
+		recurringID := ids.recurring[v.RecurringID]
+		occurrence := models.RecurringOccurrence{
+			CreatedAt: v.CreatedAt, Key: models.RecurringOccurrenceKey(recurringID, v.OccurrenceDate),
+			RecurringID: recurringID, UserID: userID, OccurrenceDate: v.OccurrenceDate,
+			Status: v.Status, SnoozedUntil: v.SnoozedUntil, Amount: v.Amount, Estimated: v.Estimated,
+		}
+		if err := tx.Create(&occurrence).Error; err != nil {
+			return fmt.Errorf("recurring occurrence %s: %w", occurrence.Key, err)
+		}
+
+	case *models.BackupDebt:
+		debt := models.Debt{
+			UserID: userID, Type: v.Type, PersonName: v.PersonName, Amount: v.Amount,
+			Description: v.Description, DueDate: v.DueDate, Status: v.Status,
+		}
+		debt.CreatedAt = v.CreatedAt
+		if err := tx.Create(&debt).Error; err != nil {
+			return fmt.Errorf("debt %d: %w", v.ID, err)
+		}
+
+	case *models.BackupBadge:
+		var found models.Badge
+		if tx.Where("name = ?", v.Name).First(&found).Error != nil {
+			return nil // Badge no longer exists
+		}
+		userBadge := models.UserBadge{UserID: userID, BadgeID: found.ID, EarnedAt: v.EarnedAt}
+		if err := tx.Create(&userBadge).Error; err != nil {
+			return fmt.Errorf("badge %q: %w", v.Name, err)
+		}
+
+	case *models.BackupImportProfile:
+		profile := models.ImportProfile{
+			CreatedAt: v.CreatedAt, UserID: userID, Name: v.Name, Bank: v.Bank, Delimiter: v.Delimiter,
+			Encoding: v.Encoding, DateFormat: v.DateFormat, DecimalSeparator: v.DecimalSeparator,
+			HasHeader: v.HasHeader, SkipRows: v.SkipRows, DateColumn: v.DateColumn,
+			DescriptionColumn: v.DescriptionColumn, AmountColumn: v.AmountColumn, DebitColumn: v.DebitColumn,
+			CreditColumn: v.CreditColumn, TypeColumn: v.TypeColumn, CategoryColumn: v.CategoryColumn,
+			NotesColumn: v.NotesColumn, CurrencyColumn: v.CurrencyColumn,
+		}
+		if err := tx.Create(&profile).Error; err != nil {
+			return fmt.Errorf("import profile %q: %w", v.Name, err)
+		}
+		if !v.HasHeader {
+			if err := tx.Model(&profile).Update("has_header", false).Error; err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown backup record %T", record)
+	}
+	return nil
+}
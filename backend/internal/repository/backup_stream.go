@@ -0,0 +1,431 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/money-management/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// maxBackupLine bounds one line of a streamed backup
+const maxBackupLine = 1 << 20
+
+// minStreamBackupVersion is the oldest streamed backup that can be read. The
+// versions since only added fields whose zero values keep the old meaning;
+// raise it when an upgrade has to change records.
+const minStreamBackupVersion = 2
+
+// backupRecordTypes are the record types of a streamed backup
+var backupRecordTypes = map[string]func() interface{}{
+	"profile":               func() interface{} { return &models.BackupProfile{} },
+	"wallet":                func() interface{} { return &models.BackupWallet{} },
+	"category":              func() interface{} { return &models.BackupCategory{} },
+	"transaction":           func() interface{} { return &models.BackupTransaction{} },
+	"budget":                func() interface{} { return &models.BackupBudget{} },
+	"budget_period":         func() interface{} { return &models.BackupBudgetPeriod{} },
+	"envelope_month":        func() interface{} { return &models.BackupEnvelopeMonth{} },
+	"envelope_assignment":   func() interface{} { return &models.BackupEnvelopeAssignment{} },
+	"envelope_balance":      func() interface{} { return &models.BackupEnvelopeBalance{} },
+	"envelope_move":         func() interface{} { return &models.BackupEnvelopeMove{} },
+	"goal":                  func() interface{} { return &models.BackupGoal{} },
+	"goal_item":             func() interface{} { return &models.BackupGoalItem{} },
+	"goal_member":           func() interface{} { return &models.BackupGoalMember{} },
+	"goal_contribution":     func() interface{} { return &models.BackupGoalContribution{} },
+	"recurring_transaction": func() interface{} { return &models.BackupRecurringTransaction{} },
+	"recurring_occurrence":  func() interface{} { return &models.BackupRecurringOccurrence{} },
+	"debt":                  func() interface{} { return &models.BackupDebt{} },
+	"badge":                 func() interface{} { return &models.BackupBadge{} },
+	"import_profile":        func() interface{} { return &models.BackupImportProfile{} },
+}
+
+var backupRecordNames = func() map[reflect.Type]string {
+	names := make(map[reflect.Type]string)
+	for name, newRecord := range backupRecordTypes {
+		names[reflect.TypeOf(newRecord())] = name
+	}
+	return names
+}()
+
+// ExportStream writes the user's backup as NDJSON, one record per line,
+// without holding more than one batch of records in memory
+func (r *BackupRepository) ExportStream(userID uint, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	write := func(recordType string, data interface{}) error {
+		return enc.Encode(struct {
+			Type string      `json:"type"`
+			Data interface{} `json:"data"`
+		}{recordType, data})
+	}
+
+	if err := write("header", models.BackupStreamHeader{Version: models.BackupVersion, ExportDate: time.Now()}); err != nil {
+		return err
+	}
+	records := 0
+	err := r.ExportEach(userID, func(record interface{}) error {
+		records++
+		return write(backupRecordNames[reflect.TypeOf(record)], record)
+	})
+	if err != nil {
+		return err
+	}
+	return write("end", models.BackupStreamEnd{Records: records})
+}
+
+// ReadBackupStream decodes a streamed backup and passes each record to fn
+// as a pointer to its Backup* type. It fails on unknown record types, on
+// backups of another version and when the end record is missing or doesn't
+// match, which means the file was cut off.
+func ReadBackupStream(rd io.Reader, fn func(record interface{}) error) error {
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 64*1024), maxBackupLine)
+
+	line, records, ended := 0, 0, false
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if ended {
+			return fmt.Errorf("line %d: data after end record", line)
+		}
+
+		var raw models.BackupStreamRecord
+		if err := json.Unmarshal(scanner.Bytes(), &raw); err != nil {
+			return fmt.Errorf("line %d: invalid JSON", line)
+		}
+
+		switch raw.Type {
+		case "header":
+			if line != 1 {
+				return fmt.Errorf("line %d: unexpected header", line)
+			}
+			var header models.BackupStreamHeader
+			if err := json.Unmarshal(raw.Data, &header); err != nil {
+				return fmt.Errorf("line %d: invalid header", line)
+			}
+			if header.Version > models.BackupVersion {
+				return fmt.Errorf("backup version %d is newer than supported version %d, please update the app", header.Version, models.BackupVersion)
+			}
+			if header.Version < minStreamBackupVersion {
+				return fmt.Errorf("streamed backups of version %d are not supported", header.Version)
+			}
+			continue
+		case "end":
+			var end models.BackupStreamEnd
+			if err := json.Unmarshal(raw.Data, &end); err != nil {
+				return fmt.Errorf("line %d: invalid end record", line)
+			}
+			if end.Records != records {
+				return fmt.Errorf("end record counts %d records, file has %d", end.Records, records)
+			}
+			ended = true
+			continue
+		}
+
+		if line == 1 {
+			return errors.New("missing header")
+		}
+		newRecord, ok := backupRecordTypes[raw.Type]
+		if !ok {
+			return fmt.Errorf("line %d: unknown record type %q", line, raw.Type)
+		}
+		record := newRecord()
+		if err := json.Unmarshal(raw.Data, record); err != nil {
+			return fmt.Errorf("line %d: invalid %s", line, raw.Type)
+		}
+		records++
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("line %d is too long", line+1)
+		}
+		return err
+	}
+	if line == 0 {
+		return errors.New("empty file")
+	}
+	if !ended {
+		return errors.New("file is incomplete, the end record is missing")
+	}
+	return nil
+}
+
+// DecodeBackupStream reads a whole streamed backup into memory, for restores
+// that go through the regular import
+func DecodeBackupStream(rd io.Reader) (*models.Backup, error) {
+	b := newBackup()
+	err := ReadBackupStream(rd, func(record interface{}) error {
+		addBackupRecord(b, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ValidateBackupStream reads a whole streamed backup and checks that records
+// only refer to wallets, categories, goals, budgets and recurring
+// transactions that came before them, like ValidateBackup does for a
+// decoded backup. It returns the record count.
+// Refunds may point anywhere in the file and are linked when the import ends.
+func ValidateBackupStream(rd io.Reader) (int, error) {
+	wallets := make(map[uint]bool)
+	categories := make(map[uint]bool)
+	goals := make(map[uint]bool)
+	recurring := make(map[uint]bool)
+	budgets := make(map[uint]bool)
+
+	records := 0
+	err := ReadBackupStream(rd, func(record interface{}) error {
+		records++
+		switch v := record.(type) {
+		case *models.BackupWallet:
+			wallets[v.ID] = true
+		case *models.BackupCategory:
+			categories[v.ID] = true
+		case *models.BackupGoal:
+			goals[v.ID] = true
+		case *models.BackupTransaction:
+			if !wallets[v.WalletID] {
+				return fmt.Errorf("transaction %d refers to missing wallet %d", v.ID, v.WalletID)
+			}
+			if !categories[v.CategoryID] {
+				return fmt.Errorf("transaction %d refers to missing category %d", v.ID, v.CategoryID)
+			}
+			if v.RecurringID != nil && !recurring[*v.RecurringID] {
+				return fmt.Errorf("transaction %d refers to missing recurring transaction %d", v.ID, *v.RecurringID)
+			}
+		case *models.BackupBudget:
+			if !categories[v.CategoryID] {
+				return fmt.Errorf("budget %d refers to missing category %d", v.ID, v.CategoryID)
+			}
+			budgets[v.ID] = true
+		case *models.BackupBudgetPeriod:
+			if !budgets[v.BudgetID] {
+				return fmt.Errorf("budget period refers to missing budget %d", v.BudgetID)
+			}
+		case *models.BackupEnvelopeAssignment:
+			if !budgets[v.BudgetID] {
+				return fmt.Errorf("envelope assignment refers to missing budget %d", v.BudgetID)
+			}
+		case *models.BackupEnvelopeBalance:
+			if !budgets[v.BudgetID] || !categories[v.CategoryID] {
+				return fmt.Errorf("envelope balance of %s refers to a missing budget or category", v.Month.Format("2006-01"))
+			}
+		case *models.BackupEnvelopeMove:
+			if (v.FromBudgetID != nil && !budgets[*v.FromBudgetID]) || (v.ToBudgetID != nil && !budgets[*v.ToBudgetID]) {
+				return fmt.Errorf("envelope move of %s refers to a missing budget", v.Month.Format("2006-01"))
+			}
+		case *models.BackupRecurringTransaction:
+			if !wallets[v.WalletID] || !categories[v.CategoryID] || (v.TargetWalletID != nil && !wallets[*v.TargetWalletID]) {
+				return fmt.Errorf("recurring transaction %d refers to a missing wallet or category", v.ID)
+			}
+			recurring[v.ID] = true
+		case *models.BackupRecurringOccurrence:
+			if !recurring[v.RecurringID] {
+				return fmt.Errorf("recurring occurrence refers to missing recurring transaction %d", v.RecurringID)
+			}
+		case *models.BackupGoalItem:
+			if !goals[v.GoalID] {
+				return fmt.Errorf("goal item %d refers to missing goal %d", v.ID, v.GoalID)
+			}
+		case *models.BackupGoalMember:
+			if !goals[v.GoalID] {
+				return fmt.Errorf("goal member refers to missing goal %d", v.GoalID)
+			}
+		case *models.BackupGoalContribution:
+			if !goals[v.GoalID] {
+				return fmt.Errorf("goal contribution %d refers to missing goal %d", v.ID, v.GoalID)
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+func (r *BackupRepository) CreateImportJob(job *models.ImportJob) error {
+	return r.db.Create(job).Error
+}
+
+func (r *BackupRepository) FindImportJob(id uint) (*models.ImportJob, error) {
+	var job models.ImportJob
+	err := r.db.First(&job, id).Error
+	return &job, err
+}
+
+// HasActiveImportJob reports whether one of the user's import jobs is
+// pending or running
+func (r *BackupRepository) HasActiveImportJob(userID uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.ImportJob{}).
+		Where("user_id = ? AND status IN ?", userID, []string{"pending", "running"}).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// ClaimFailedImportJob sets a failed job back to pending. It reports false
+// when the job wasn't failed, e.g. because it was already resumed.
+func (r *BackupRepository) ClaimFailedImportJob(job *models.ImportJob) (bool, error) {
+	result := r.db.Model(&models.ImportJob{}).
+		Where("id = ? AND status = ?", job.ID, "failed").
+		Update("status", "pending")
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	job.Status = "pending"
+	return true, nil
+}
+
+func (r *BackupRepository) FailImportJob(job *models.ImportJob, cause error) error {
+	job.Status = "failed"
+	job.Error = cause.Error()
+	return r.db.Model(job).Updates(map[string]interface{}{"status": job.Status, "error": job.Error}).Error
+}
+
+// FailInterruptedImportJobs marks jobs that were running when the server
+// stopped as failed so they can be resumed
+func (r *BackupRepository) FailInterruptedImportJobs() error {
+	return r.db.Model(&models.ImportJob{}).
+		Where("status IN ?", []string{"pending", "running"}).
+		Updates(map[string]interface{}{"status": "failed", "error": "Interrupted by a server restart"}).Error
+}
+
+// RunImportJob restores a streamed backup, replacing the user's data. Each
+// batch of records is committed with the job's progress and the IDs it
+// created, so after a failure the job continues after the last committed
+// batch when run again with the same file.
+func (r *BackupRepository) RunImportJob(job *models.ImportJob, rd io.Reader, batchSize int) error {
+	job.Status = "running"
+	job.Error = ""
+	if err := r.db.Model(job).Updates(map[string]interface{}{"status": job.Status, "error": job.Error}).Error; err != nil {
+		return err
+	}
+
+	if err := r.runImportJob(job, rd, batchSize); err != nil {
+		r.FailImportJob(job, err)
+		return err
+	}
+	return nil
+}
+
+func (r *BackupRepository) runImportJob(job *models.ImportJob, rd io.Reader, batchSize int) error {
+	// Wallets, categories, goals, budgets and recurring transactions created
+	// by earlier runs; transactions stay in the database, only refunds need them
+	ids := newBackupIDMap()
+	var mapped []models.ImportIDMap
+	if err := r.db.Where("job_id = ? AND entity <> ?", job.ID, "transaction").Find(&mapped).Error; err != nil {
+		return err
+	}
+	for _, m := range mapped {
+		switch m.Entity {
+		case "wallet":
+			ids.wallets[m.OldID] = m.NewID
+		case "category":
+			ids.categories[m.OldID] = m.NewID
+		case "goal":
+			ids.goals[m.OldID] = m.NewID
+		case "recurring":
+			ids.recurring[m.OldID] = m.NewID
+		case "budget":
+			ids.budgets[m.OldID] = m.NewID
+		}
+	}
+
+	if !job.Cleared {
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			if err := deleteUserData(tx, job.UserID); err != nil {
+				return err
+			}
+			return tx.Model(job).Update("cleared", true).Error
+		})
+		if err != nil {
+			return err
+		}
+		job.Cleared = true
+	}
+
+	var pending []interface{}
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		processed := job.ProcessedRecords + len(pending)
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			restorer := newBackupRestorer(tx, job.UserID, ids)
+			restorer.track = true
+			for _, record := range pending {
+				if err := restorer.restore(record); err != nil {
+					return err
+				}
+			}
+			for i := range restorer.mapped {
+				restorer.mapped[i].JobID = job.ID
+			}
+			if len(restorer.mapped) > 0 {
+				if err := tx.CreateInBatches(restorer.mapped, 500).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Model(job).Update("processed_records", processed).Error
+		})
+		if err != nil {
+			return err
+		}
+		job.ProcessedRecords = processed
+		pending = pending[:0]
+		return nil
+	}
+
+	seen := 0
+	err := ReadBackupStream(rd, func(record interface{}) error {
+		seen++
+		if seen <= job.ProcessedRecords {
+			return nil // Committed by an earlier run
+		}
+		pending = append(pending, record)
+		if len(pending) < batchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`UPDATE transactions SET refund_of_id = original.new_id
+			FROM import_id_maps refund
+			JOIN import_id_maps original ON original.job_id = refund.job_id AND original.entity = 'transaction' AND original.old_id = refund.refund_of_id
+			WHERE refund.job_id = ? AND refund.entity = 'transaction' AND refund.refund_of_id IS NOT NULL
+			AND transactions.id = refund.new_id`, job.ID).Error
+		if err != nil {
+			return err
+		}
+		if err := linkOccurrenceTransactions(tx, job.UserID); err != nil {
+			return err
+		}
+		if err := tx.Where("job_id = ?", job.ID).Delete(&models.ImportIDMap{}).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		job.Status = "completed"
+		job.CompletedAt = &now
+		return tx.Model(job).Updates(map[string]interface{}{"status": job.Status, "completed_at": now}).Error
+	})
+}
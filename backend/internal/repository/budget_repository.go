@@ -1,10 +1,13 @@
 package repository
 
 import (
+	"math"
+	"sort"
 	"time"
 
 	"github.com/money-management/backend/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type BudgetRepository struct {
@@ -51,26 +54,310 @@ func (r *BudgetRepository) Delete(id uint) error {
 	return r.db.Delete(&models.Budget{}, id).Error
 }
 
-func (r *BudgetRepository) GetBudgetsWithSpending(userID uint, startDate, endDate time.Time) ([]models.Budget, error) {
+// GetBudgetsWithSpending returns the user's budgets with what was spent in
+// each one's own window containing at, e.g. the current week for a weekly
+// budget or the 25th to the 24th for a month starting on payday, and the
+// amount rolled over into it. Envelopes report their balance in the month
+// of at instead: what they carried in and were assigned, and what is
+// available.
+func (r *BudgetRepository) GetBudgetsWithSpending(userID uint, at time.Time) ([]models.Budget, error) {
 	budgets, err := r.FindByUserID(userID)
 	if err != nil {
 		return nil, err
 	}
 
+	var envelopes map[uint]models.EnvelopeBalance
 	for i := range budgets {
-		var spent float64
-		r.db.Model(&models.Transaction{}).
-			Where("user_id = ? AND category_id = ? AND type = ? AND date >= ? AND date <= ?",
-				userID, budgets[i].CategoryID, "expense", startDate, endDate).
-			Select("COALESCE(SUM(amount), 0)").
-			Scan(&spent)
+		if budgets[i].Mode == "envelope" {
+			if envelopes == nil {
+				month, err := NewEnvelopeRepository(r.db).GetMonth(userID, at)
+				if err != nil {
+					return nil, err
+				}
+				envelopes = make(map[uint]models.EnvelopeBalance)
+				for _, e := range month.Envelopes {
+					envelopes[e.BudgetID] = e
+				}
+			}
+			setEnvelopeSpending(&budgets[i], envelopes[budgets[i].ID], at)
+			continue
+		}
 
-		budgets[i].Spent = spent
-		budgets[i].Remaining = budgets[i].Amount - spent
-		if budgets[i].Amount > 0 {
-			budgets[i].Percentage = (spent / budgets[i].Amount) * 100
+		periods, err := r.settlePeriods(&budgets[i], at)
+		if err != nil {
+			return nil, err
 		}
+		current := periods[len(periods)-1]
+
+		budgets[i].PeriodStart = current.PeriodStart
+		budgets[i].PeriodEnd = current.PeriodEnd
+		budgets[i].CarriedIn = current.CarriedIn
+		budgets[i].EffectiveAmount = current.EffectiveAmount
+		budgets[i].Spent = current.Spent
+		budgets[i].Remaining = current.Remaining
+		budgets[i].Percentage = current.Percentage
 	}
 
 	return budgets, nil
 }
+
+// setEnvelopeSpending fills the computed fields of an envelope budget from
+// its balance in the month of at
+func setEnvelopeSpending(budget *models.Budget, balance models.EnvelopeBalance, at time.Time) {
+	budget.PeriodStart = MonthStart(at)
+	budget.PeriodEnd = budget.PeriodStart.AddDate(0, 1, 0).Add(-time.Second)
+	budget.CarriedIn = balance.CarriedIn
+	budget.EffectiveAmount = balance.CarriedIn + balance.Assigned
+	budget.Spent = balance.Spent
+	budget.Remaining = balance.Available
+	budget.Percentage = 0
+	if budget.EffectiveAmount > 0 {
+		budget.Percentage = (budget.Spent / budget.EffectiveAmount) * 100
+	}
+}
+
+// GetPeriods returns up to count windows of budget, the one containing at
+// first and then going back to the budget's start
+func (r *BudgetRepository) GetPeriods(budget *models.Budget, at time.Time, count int) ([]models.BudgetPeriod, error) {
+	periods, err := r.settlePeriods(budget, at)
+	if err != nil {
+		return nil, err
+	}
+
+	newest := []models.BudgetPeriod{}
+	for i := len(periods) - 1; i >= 0 && len(newest) < count; i-- {
+		newest = append(newest, periods[i])
+	}
+	return newest, nil
+}
+
+// ResetPeriods drops the stored windows of a budget, e.g. after its period
+// or start date changed and they no longer line up
+func (r *BudgetRepository) ResetPeriods(budgetID uint) error {
+	return r.db.Where("budget_id = ?", budgetID).Delete(&models.BudgetPeriod{}).Error
+}
+
+// maxBudgetPeriods limits how far back a rollover chain is followed, ten
+// years of weekly windows
+const maxBudgetPeriods = 520
+
+// settlePeriods works out the windows of budget from its first one, the one
+// with its start date or creation, to the one containing at, oldest first.
+// Each window's result carries into the next as its rollover policy says.
+// The windows are stored; ones that have ended keep the amount and rollover
+// settings they were first stored with, while spending is always counted
+// afresh so late entries still land in their window. A window before the
+// budget began is returned alone, without rollover, and isn't stored.
+func (r *BudgetRepository) settlePeriods(budget *models.Budget, at time.Time) ([]models.BudgetPeriod, error) {
+	origin := budget.StartDate
+	if origin.IsZero() {
+		origin = budget.CreatedAt
+	}
+	firstStart, _ := BudgetWindow(budget, origin.In(at.Location()))
+
+	// Windows from the one containing at back to the first
+	var windows []models.BudgetPeriod
+	for point := at; len(windows) < maxBudgetPeriods; {
+		start, end := BudgetWindow(budget, point)
+		if start.Before(firstStart) && len(windows) > 0 {
+			break
+		}
+		windows = append(windows, models.BudgetPeriod{BudgetID: budget.ID, UserID: budget.UserID, PeriodStart: start, PeriodEnd: end})
+		if start.Before(firstStart) {
+			break
+		}
+		point = start.Add(-time.Second)
+	}
+	for i, j := 0, len(windows)-1; i < j; i, j = i+1, j-1 {
+		windows[i], windows[j] = windows[j], windows[i]
+	}
+	current := windows[len(windows)-1]
+	standalone := current.PeriodStart.Before(firstStart)
+
+	spent, err := r.spentByWindow(budget, windows)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make(map[int64]models.BudgetPeriod)
+	if !standalone {
+		var existing []models.BudgetPeriod
+		err := r.db.Where("budget_id = ? AND period_start >= ? AND period_start <= ?", budget.ID, windows[0].PeriodStart, current.PeriodStart).
+			Find(&existing).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range existing {
+			stored[p.PeriodStart.Unix()] = p
+		}
+	}
+
+	now := time.Now()
+	carry := 0.0
+	for i := range windows {
+		p := &windows[i]
+		before, found := stored[p.PeriodStart.Unix()]
+		if found {
+			p.ID, p.CreatedAt = before.ID, before.CreatedAt
+		}
+		if found && p.PeriodEnd.Before(now) {
+			p.Amount, p.RolloverPolicy, p.RolloverCap = before.Amount, before.RolloverPolicy, before.RolloverCap
+		} else {
+			p.Amount, p.RolloverPolicy, p.RolloverCap = budget.Amount, budget.RolloverPolicy, budget.RolloverCap
+		}
+
+		p.CarriedIn = carry
+		p.EffectiveAmount = p.Amount + carry
+		p.Spent = spent[i]
+		p.Remaining = p.EffectiveAmount - p.Spent
+		if p.EffectiveAmount > 0 {
+			p.Percentage = (p.Spent / p.EffectiveAmount) * 100
+		}
+		p.CarriedOut = rollover(p.Remaining, p.RolloverPolicy, p.RolloverCap)
+		carry = p.CarriedOut
+
+		if standalone || (found && samePeriod(&before, p)) {
+			continue
+		}
+		if err := r.savePeriod(p); err != nil {
+			return nil, err
+		}
+	}
+
+	return windows, nil
+}
+
+// spentByWindow sums the budget category's expenses less refunds in each
+// of windows, which follow each other without gaps
+func (r *BudgetRepository) spentByWindow(budget *models.Budget, windows []models.BudgetPeriod) ([]float64, error) {
+	var rows []struct {
+		Date   time.Time
+		Type   string
+		Amount float64
+	}
+	err := r.db.Model(&models.Transaction{}).
+		Select("date, type, amount").
+		Where("user_id = ? AND category_id = ? AND type IN ? AND date >= ? AND date <= ?",
+			budget.UserID, budget.CategoryID, []string{"expense", "refund"}, windows[0].PeriodStart, windows[len(windows)-1].PeriodEnd).
+		Order("date, id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	spent := make([]float64, len(windows))
+	for _, row := range rows {
+		// The last window starting at or before the transaction
+		i := sort.Search(len(windows), func(i int) bool { return windows[i].PeriodStart.After(row.Date) }) - 1
+		if i < 0 {
+			continue
+		}
+		if row.Type == "refund" {
+			spent[i] -= row.Amount
+		} else {
+			spent[i] += row.Amount
+		}
+	}
+	return spent, nil
+}
+
+// savePeriod stores a window, updating it when another request stored the
+// same one meanwhile
+func (r *BudgetRepository) savePeriod(period *models.BudgetPeriod) error {
+	if period.ID != 0 {
+		return r.db.Save(period).Error
+	}
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "budget_id"}, {Name: "period_start"}},
+		DoUpdates: clause.AssignmentColumns([]string{"updated_at", "period_end", "amount", "rollover_policy", "rollover_cap", "carried_in", "effective_amount", "spent", "carried_out"}),
+	}).Create(period).Error
+}
+
+func samePeriod(a, b *models.BudgetPeriod) bool {
+	sameCap := (a.RolloverCap == nil) == (b.RolloverCap == nil) &&
+		(a.RolloverCap == nil || *a.RolloverCap == *b.RolloverCap)
+	return sameCap && a.PeriodEnd.Equal(b.PeriodEnd) && a.Amount == b.Amount && a.RolloverPolicy == b.RolloverPolicy &&
+		a.CarriedIn == b.CarriedIn && a.EffectiveAmount == b.EffectiveAmount && a.Spent == b.Spent && a.CarriedOut == b.CarriedOut
+}
+
+// rollover is what a window's remaining amount carries into the next under
+// policy, at most limit either way, rounded to whole cents
+func rollover(remaining float64, policy string, limit *float64) float64 {
+	carry := 0.0
+	switch policy {
+	case "unspent":
+		carry = math.Max(remaining, 0)
+	case "overspent":
+		carry = math.Min(remaining, 0)
+	case "both":
+		carry = remaining
+	}
+	if limit != nil {
+		carry = math.Max(-*limit, math.Min(*limit, carry))
+	}
+	return math.Round(carry*100) / 100
+}
+
+// BudgetWindow returns the window of budget's period that contains at, from
+// its first moment to its last second. Windows are anchored at StartDate:
+// monthly ones begin on its day of the month, or the month's last day when
+// shorter, weekly ones on its weekday and yearly ones on its date. Without a
+// start date they follow the calendar, with weeks starting on Monday.
+func BudgetWindow(budget *models.Budget, at time.Time) (time.Time, time.Time) {
+	loc := at.Location()
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, loc)
+	anchor := budget.StartDate.In(loc)
+
+	var start, next time.Time
+	switch budget.Period {
+	case "weekly":
+		if budget.StartDate.IsZero() {
+			anchor = time.Date(2024, 1, 1, 0, 0, 0, 0, loc) // A Monday
+		}
+		days := civilDays(anchor, day)
+		weeks := days / 7
+		if days%7 < 0 {
+			weeks--
+		}
+		start = time.Date(anchor.Year(), anchor.Month(), anchor.Day()+weeks*7, 0, 0, 0, 0, loc)
+		next = start.AddDate(0, 0, 7)
+	case "yearly":
+		month, dom := time.January, 1
+		if !budget.StartDate.IsZero() {
+			month, dom = anchor.Month(), anchor.Day()
+		}
+		start = clampedDate(day.Year(), month, dom, loc)
+		if start.After(day) {
+			start = clampedDate(day.Year()-1, month, dom, loc)
+		}
+		next = clampedDate(start.Year()+1, month, dom, loc)
+	default:
+		dom := 1
+		if !budget.StartDate.IsZero() {
+			dom = anchor.Day()
+		}
+		start = clampedDate(day.Year(), day.Month(), dom, loc)
+		if start.After(day) {
+			start = clampedDate(day.Year(), day.Month()-1, dom, loc)
+		}
+		next = clampedDate(start.Year(), start.Month()+1, dom, loc)
+	}
+	return start, next.Add(-time.Second)
+}
+
+// clampedDate is the given day of a month, or its last day when the month is
+// shorter, e.g. the 30th of February is the 28th or 29th
+func clampedDate(year int, month time.Month, day int, loc *time.Location) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return time.Date(first.Year(), first.Month(), day, 0, 0, 0, 0, loc)
+}
+
+// civilDays counts the calendar days from a to b, unaffected by DST changes
+func civilDays(a, b time.Time) int {
+	au := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	bu := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(bu.Sub(au).Hours() / 24)
+}
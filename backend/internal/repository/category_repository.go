@@ -17,6 +17,18 @@ func (r *CategoryRepository) Create(category *models.Category) error {
 	return r.db.Create(category).Error
 }
 
+// CreateNonEssential creates a category marked as a want. gorm skips false
+// for columns with a true default, so it is written after the insert.
+func (r *CategoryRepository) CreateNonEssential(category *models.Category) error {
+	category.IsEssential = false
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(category).Error; err != nil {
+			return err
+		}
+		return tx.Model(category).Update("is_essential", false).Error
+	})
+}
+
 func (r *CategoryRepository) FindByID(id uint) (*models.Category, error) {
 	var category models.Category
 	err := r.db.First(&category, id).Error
@@ -66,3 +78,33 @@ func (r *CategoryRepository) CreateDefaultCategories(userID uint) error {
 	}
 	return nil
 }
+
+// FindOrCreateTransfer returns the user's transfer category, creating it
+// when missing
+func (r *CategoryRepository) FindOrCreateTransfer(userID uint) (*models.Category, error) {
+	return findOrCreateTransferCategory(r.db, userID)
+}
+
+func findOrCreateTransferCategory(db *gorm.DB, userID uint) (*models.Category, error) {
+	var category models.Category
+	err := db.Where("user_id = ? AND name IN ?", userID, []string{"Transfer", "Transfer Out", "Transfer In"}).
+		Order("id").First(&category).Error
+	if err == nil {
+		return &category, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	category = models.Category{
+		UserID: userID,
+		Name:   "Transfer",
+		Icon:   "🔄",
+		Color:  "#808080",
+		Type:   "expense",
+	}
+	if err := db.Create(&category).Error; err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
@@ -26,6 +26,11 @@ func InitDB(cfg *config.Config) error {
 		&models.Category{},
 		&models.Transaction{},
 		&models.Budget{},
+		&models.BudgetPeriod{},
+		&models.EnvelopeMonth{},
+		&models.EnvelopeAssignment{},
+		&models.EnvelopeBalance{},
+		&models.EnvelopeMove{},
 		&models.Goal{},
 		&models.GoalMember{},
 		&models.GoalTransaction{},
@@ -33,6 +38,12 @@ func InitDB(cfg *config.Config) error {
 		&models.GoalItem{},
 		&models.Badge{},
 		&models.UserBadge{},
+		&models.ImportProfile{},
+		&models.ImportJob{},
+		&models.ImportIDMap{},
+		&models.CalendarFeed{},
+		&models.RecurringOccurrence{},
+		&models.RecurringRun{},
 	)
 	if err != nil {
 		return err
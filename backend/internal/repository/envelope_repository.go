@@ -0,0 +1,334 @@
+package repository
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/money-management/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxEnvelopeMonths limits how many open months are worked out one after
+// the other, since the last one rolled over
+const maxEnvelopeMonths = 120
+
+var (
+	// ErrEnvelopeMonthClosed rejects changes to a month already rolled over
+	ErrEnvelopeMonthClosed = errors.New("month is already rolled over")
+	// ErrEnvelopeInsufficient rejects moving more out of an envelope than it holds
+	ErrEnvelopeInsufficient = errors.New("envelope doesn't have that much available")
+)
+
+type EnvelopeRepository struct {
+	db *gorm.DB
+}
+
+func NewEnvelopeRepository(db *gorm.DB) *EnvelopeRepository {
+	return &EnvelopeRepository{db: db}
+}
+
+// MonthStart returns the first day of the month of t, the key envelope
+// months are stored under
+func MonthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// FindEnvelopes returns the user's envelope budgets with their categories
+func (r *EnvelopeRepository) FindEnvelopes(userID uint) ([]models.Budget, error) {
+	var budgets []models.Budget
+	err := r.db.Preload("Category").
+		Where("user_id = ? AND mode = ?", userID, "envelope").
+		Order("id").
+		Find(&budgets).Error
+	return budgets, err
+}
+
+// GetMonth returns the pool and envelopes of month: as stored when it was
+// rolled over, otherwise worked out from the last month that was
+func (r *EnvelopeRepository) GetMonth(userID uint, month time.Time) (*models.EnvelopeMonth, error) {
+	months, err := r.openMonths(r.db, userID, MonthStart(month))
+	if err != nil {
+		return nil, err
+	}
+	return &months[len(months)-1], nil
+}
+
+// Move moves amount in month from one envelope to another, or between an
+// envelope and the pool when from or to is nil, and records it. An envelope
+// can't give more than it has available; the pool can go negative, showing
+// that more was assigned than came in.
+func (r *EnvelopeRepository) Move(userID uint, month time.Time, from, to *uint, amount float64, note string) (*models.EnvelopeMove, error) {
+	month = MonthStart(month)
+	move := &models.EnvelopeMove{UserID: userID, Month: month, FromBudgetID: from, ToBudgetID: to, Amount: amount, Note: note}
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Serializes the user's changes, so two moves can't both spend the
+		// same available money
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.User{}, userID).Error; err != nil {
+			return err
+		}
+
+		months, err := r.openMonths(tx, userID, month)
+		if err != nil {
+			return err
+		}
+		current := months[len(months)-1]
+		if current.Closed {
+			return ErrEnvelopeMonthClosed
+		}
+		// Nor may a month before one rolled over change
+		var later int64
+		if err := tx.Model(&models.EnvelopeMonth{}).Where("user_id = ? AND closed = ? AND month > ?", userID, true, month).Count(&later).Error; err != nil {
+			return err
+		}
+		if later > 0 {
+			return ErrEnvelopeMonthClosed
+		}
+
+		if from != nil {
+			for _, e := range current.Envelopes {
+				if e.BudgetID == *from && e.Available < amount {
+					return ErrEnvelopeInsufficient
+				}
+			}
+			if err := addAssigned(tx, userID, *from, month, -amount); err != nil {
+				return err
+			}
+		}
+		if to != nil {
+			if err := addAssigned(tx, userID, *to, month, amount); err != nil {
+				return err
+			}
+		}
+		return tx.Create(move).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return move, nil
+}
+
+// FindMoves returns the money moved in month, newest first
+func (r *EnvelopeRepository) FindMoves(userID uint, month time.Time) ([]models.EnvelopeMove, error) {
+	var moves []models.EnvelopeMove
+	err := r.db.Where("user_id = ? AND month = ?", userID, MonthStart(month)).
+		Order("created_at desc").
+		Find(&moves).Error
+	return moves, err
+}
+
+// Rollover closes month and any open month before it, storing the pool and
+// envelope balances they ended with. What they carry into the next month is
+// fixed from then on.
+func (r *EnvelopeRepository) Rollover(userID uint, month time.Time) (*models.EnvelopeMonth, error) {
+	month = MonthStart(month)
+	var closed models.EnvelopeMonth
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.User{}, userID).Error; err != nil {
+			return err
+		}
+
+		months, err := r.openMonths(tx, userID, month)
+		if err != nil {
+			return err
+		}
+		if months[len(months)-1].Closed {
+			return ErrEnvelopeMonthClosed
+		}
+
+		for i := range months {
+			m := &months[i]
+			if m.Closed {
+				continue
+			}
+			m.Closed = true
+			if err := tx.Create(m).Error; err != nil {
+				return err
+			}
+			for j := range m.Envelopes {
+				if err := tx.Omit("Category").Create(&m.Envelopes[j]).Error; err != nil {
+					return err
+				}
+			}
+		}
+		closed = months[len(months)-1]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &closed, nil
+}
+
+// openMonths works out the months from the one after the last rolled over,
+// or from the first with envelope activity, through month, oldest first. A
+// month already rolled over is returned alone as stored.
+func (r *EnvelopeRepository) openMonths(db *gorm.DB, userID uint, month time.Time) ([]models.EnvelopeMonth, error) {
+	var last models.EnvelopeMonth
+	err := db.Where("user_id = ? AND closed = ? AND month <= ?", userID, true, month).
+		Order("month desc").
+		First(&last).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	found := err == nil
+	if found {
+		last.Month = last.Month.In(month.Location())
+		if err := db.Preload("Category").Where("user_id = ? AND month = ?", userID, last.Month).Order("budget_id").Find(&last.Envelopes).Error; err != nil {
+			return nil, err
+		}
+		if last.Month.Equal(month) {
+			return []models.EnvelopeMonth{last}, nil
+		}
+	}
+
+	envelopes, err := r.FindEnvelopes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	start := month
+	if found {
+		start = last.Month.AddDate(0, 1, 0)
+	} else if first, ok, err := firstEnvelopeMonth(db, userID, envelopes); err != nil {
+		return nil, err
+	} else if ok && first.Before(month) {
+		start = first
+	}
+	if limit := month.AddDate(0, -maxEnvelopeMonths+1, 0); start.Before(limit) {
+		start = limit
+	}
+
+	prev := last
+	var months []models.EnvelopeMonth
+	for m := start; !m.After(month); m = m.AddDate(0, 1, 0) {
+		current, err := workOutMonth(db, userID, m, envelopes, &prev)
+		if err != nil {
+			return nil, err
+		}
+		months = append(months, *current)
+		prev = *current
+	}
+	return months, nil
+}
+
+// firstEnvelopeMonth is the earliest month with an envelope or an
+// assignment in it
+func firstEnvelopeMonth(db *gorm.DB, userID uint, envelopes []models.Budget) (time.Time, bool, error) {
+	var first time.Time
+	ok := false
+	for _, e := range envelopes {
+		if created := MonthStart(e.CreatedAt.In(time.Local)); !ok || created.Before(first) {
+			first, ok = created, true
+		}
+	}
+
+	var assignment models.EnvelopeAssignment
+	err := db.Where("user_id = ?", userID).Order("month").First(&assignment).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return first, ok, err
+	}
+	if err == nil && (!ok || assignment.Month.Before(first)) {
+		first, ok = MonthStart(assignment.Month.In(time.Local)), true
+	}
+	return first, ok, nil
+}
+
+// workOutMonth computes month from the one before it: the pool gets the
+// month's income and what was left unassigned, less last month's
+// overspending, and each envelope carries over what it had available
+func workOutMonth(db *gorm.DB, userID uint, month time.Time, envelopes []models.Budget, prev *models.EnvelopeMonth) (*models.EnvelopeMonth, error) {
+	end := month.AddDate(0, 1, 0).Add(-time.Second)
+	current := &models.EnvelopeMonth{UserID: userID, Month: month, CarriedIn: prev.ToBeBudgeted, Envelopes: []models.EnvelopeBalance{}}
+
+	active := make(map[uint]bool)
+	for _, e := range envelopes {
+		active[e.ID] = true
+	}
+	carried := make(map[uint]float64)
+	for _, e := range prev.Envelopes {
+		switch {
+		case e.Available < 0:
+			current.Overspent -= e.Available
+		case active[e.BudgetID]:
+			carried[e.BudgetID] = e.Available
+		default:
+			// The envelope was removed, its money goes back to the pool
+			current.CarriedIn += e.Available
+		}
+	}
+
+	err := db.Table("transactions").
+		Joins("JOIN categories ON categories.id = transactions.category_id").
+		Where("transactions.user_id = ? AND transactions.type = ? AND transactions.date >= ? AND transactions.date <= ?", userID, "income", month, end).
+		Where("LOWER(categories.name) NOT IN ?", []string{"transfer", "transfer out", "transfer in"}).
+		Select("COALESCE(SUM(transactions.amount), 0)").
+		Scan(&current.Income).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var assignments []models.EnvelopeAssignment
+	if err := db.Where("user_id = ? AND month = ?", userID, month).Find(&assignments).Error; err != nil {
+		return nil, err
+	}
+	assigned := make(map[uint]float64)
+	for _, a := range assignments {
+		assigned[a.BudgetID] = a.Assigned
+	}
+
+	var spending []struct {
+		CategoryID uint
+		Spent      float64
+	}
+	err = db.Model(&models.Transaction{}).
+		Select("category_id, "+netExpenseSum+" AS spent").
+		Where("user_id = ? AND type IN ? AND date >= ? AND date <= ?", userID, []string{"expense", "refund"}, month, end).
+		Group("category_id").
+		Scan(&spending).Error
+	if err != nil {
+		return nil, err
+	}
+	spent := make(map[uint]float64)
+	for _, s := range spending {
+		spent[s.CategoryID] = s.Spent
+	}
+
+	for _, e := range envelopes {
+		balance := models.EnvelopeBalance{
+			UserID:     userID,
+			BudgetID:   e.ID,
+			CategoryID: e.CategoryID,
+			Month:      month,
+			CarriedIn:  carried[e.ID],
+			Assigned:   assigned[e.ID],
+			Spent:      spent[e.CategoryID],
+			Category:   e.Category,
+		}
+		balance.Available = roundCents(balance.CarriedIn + balance.Assigned - balance.Spent)
+		current.Assigned += balance.Assigned
+		current.Envelopes = append(current.Envelopes, balance)
+	}
+
+	current.ToBeBudgeted = roundCents(current.CarriedIn + current.Income - current.Assigned - current.Overspent)
+	return current, nil
+}
+
+// addAssigned adds amount to what is assigned to an envelope in month
+func addAssigned(tx *gorm.DB, userID, budgetID uint, month time.Time, amount float64) error {
+	assignment := models.EnvelopeAssignment{UserID: userID, BudgetID: budgetID, Month: month, Assigned: amount}
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "budget_id"}, {Name: "month"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"assigned":   gorm.Expr("envelope_assignments.assigned + ?", amount),
+			"updated_at": time.Now(),
+		}),
+	}).Create(&assignment).Error
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"github.com/money-management/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+type ImportProfileRepository struct {
+	db *gorm.DB
+}
+
+func NewImportProfileRepository(db *gorm.DB) *ImportProfileRepository {
+	return &ImportProfileRepository{db: db}
+}
+
+func (r *ImportProfileRepository) Create(profile *models.ImportProfile) error {
+	if err := r.db.Create(profile).Error; err != nil {
+		return err
+	}
+	// gorm skips false for columns with a true default
+	if !profile.HasHeader {
+		return r.db.Model(profile).Update("has_header", false).Error
+	}
+	return nil
+}
+
+func (r *ImportProfileRepository) FindByID(id uint) (*models.ImportProfile, error) {
+	var profile models.ImportProfile
+	err := r.db.First(&profile, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func (r *ImportProfileRepository) FindByUserID(userID uint) ([]models.ImportProfile, error) {
+	var profiles []models.ImportProfile
+	err := r.db.Where("user_id = ?", userID).Order("bank asc, name asc").Find(&profiles).Error
+	return profiles, err
+}
+
+func (r *ImportProfileRepository) Update(profile *models.ImportProfile) error {
+	return r.db.Save(profile).Error
+}
+
+func (r *ImportProfileRepository) Delete(id uint) error {
+	return r.db.Delete(&models.ImportProfile{}, id).Error
+}
@@ -1,10 +1,15 @@
 package repository
 
 import (
+	"errors"
+	"math"
+	"sort"
 	"time"
 
 	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/pkg/rrule"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type RecurringRepository struct {
@@ -24,6 +29,7 @@ func (r *RecurringRepository) FindByUserID(userID uint) ([]models.RecurringTrans
 	err := r.db.Where("user_id = ?", userID).
 		Preload("Category").
 		Preload("Wallet").
+		Preload("TargetWallet").
 		Order("next_run_date asc").
 		Find(&recurrings).Error
 	return recurrings, err
@@ -42,8 +48,62 @@ func (r *RecurringRepository) Update(recurring *models.RecurringTransaction) err
 	return r.db.Save(recurring).Error
 }
 
+// UpdateGenerated applies the amount, type, wallet, category and
+// description of recurring to the transactions it already posted, moving
+// the wallet balances along, in one database transaction
+func (r *RecurringRepository) UpdateGenerated(recurring *models.RecurringTransaction) (int, error) {
+	count := 0
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var transactions []models.Transaction
+		if err := tx.Where("recurring_id = ? AND user_id = ?", recurring.ID, recurring.UserID).Find(&transactions).Error; err != nil {
+			return err
+		}
+
+		for _, t := range transactions {
+			// Undo the old effect on the balance, then apply the new one
+			if err := adjustBalance(tx, t.WalletID, t.Amount, t.Type != "income"); err != nil {
+				return err
+			}
+			if err := adjustBalance(tx, recurring.WalletID, recurring.Amount, recurring.Type == "income"); err != nil {
+				return err
+			}
+			err := tx.Model(&t).Updates(map[string]interface{}{
+				"amount":      recurring.Amount,
+				"type":        recurring.Type,
+				"wallet_id":   recurring.WalletID,
+				"category_id": recurring.CategoryID,
+				"description": recurring.Description + " (Otomatis)",
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		count = len(transactions)
+		return nil
+	})
+	return count, err
+}
+
+// FindHistory returns every handled occurrence of a recurring transaction
+// with its transaction, newest first
+func (r *RecurringRepository) FindHistory(recurringID uint) ([]models.RecurringOccurrence, error) {
+	var occurrences []models.RecurringOccurrence
+	err := r.db.Where("recurring_id = ?", recurringID).
+		Preload("Transaction").
+		Order("occurrence_date desc").
+		Find(&occurrences).Error
+	return occurrences, err
+}
+
+// Delete removes a recurring transaction and the occurrences still queued
+// for it. Handled occurrences stay as the history of its transactions.
 func (r *RecurringRepository) Delete(id uint) error {
-	return r.db.Delete(&models.RecurringTransaction{}, id).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("recurring_id = ? AND status IN ?", id, queuedStatuses).Delete(&models.RecurringOccurrence{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.RecurringTransaction{}, id).Error
+	})
 }
 
 // FindPending returns active recurring transactions that are due (next_run_date <= now)
@@ -53,3 +113,450 @@ func (r *RecurringRepository) FindPending(userID uint, date time.Time) ([]models
 		Find(&recurrings).Error
 	return recurrings, err
 }
+
+// FindAllPending returns the active recurring transactions of every user
+// that are due
+func (r *RecurringRepository) FindAllPending(date time.Time) ([]models.RecurringTransaction, error) {
+	var recurrings []models.RecurringTransaction
+	err := r.db.Where("is_active = ? AND next_run_date <= ?", true, date).
+		Order("next_run_date asc").
+		Find(&recurrings).Error
+	return recurrings, err
+}
+
+// RecordOccurrence handles the occurrence of recurring due on its
+// NextRunDate: it records the occurrence under its idempotency key with
+// status, advances the next run date and, for a posted occurrence, creates
+// the transaction on the scheduled date and updates the wallet balance, all
+// in one database transaction. It returns false without posting anything
+// when the occurrence was already handled, so concurrent callers can never
+// post it twice.
+func (r *RecurringRepository) RecordOccurrence(recurring *models.RecurringTransaction, status string, now time.Time) (bool, error) {
+	return r.recordOccurrence(recurring, status, now, recurring.NextRunDate)
+}
+
+// PostNow posts the next occurrence of recurring ahead of its date, dated
+// now, and advances the rule past it
+func (r *RecurringRepository) PostNow(recurring *models.RecurringTransaction, now time.Time) (bool, error) {
+	return r.recordOccurrence(recurring, "posted", now, now)
+}
+
+func (r *RecurringRepository) recordOccurrence(recurring *models.RecurringTransaction, status string, now, postedOn time.Time) (bool, error) {
+	recorded := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		date := recurring.NextRunDate
+		occurrence := models.RecurringOccurrence{
+			Key:            models.RecurringOccurrenceKey(recurring.ID, date),
+			RecurringID:    recurring.ID,
+			UserID:         recurring.UserID,
+			OccurrenceDate: date,
+			Status:         status,
+		}
+		// Waits for a concurrent insert of the same key and then does nothing
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&occurrence)
+		if result.Error != nil {
+			return result.Error
+		}
+		handled := result.RowsAffected == 0
+
+		// Advance only from the run date we read, in case the rule changed
+		// meanwhile. A rule past its end date or count is deactivated. An
+		// occurrence handled before is advanced past too, so a rule whose
+		// date was set back to it can't get stuck.
+		updates := map[string]interface{}{"last_run_date": now}
+		next, ok := NextOccurrence(recurring, date)
+		if ok {
+			updates["next_run_date"] = next
+		} else {
+			updates["is_active"] = false
+		}
+		result = tx.Model(&models.RecurringTransaction{}).
+			Where("id = ? AND next_run_date = ?", recurring.ID, date).
+			Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if handled {
+			return nil
+		}
+		if result.RowsAffected == 0 {
+			return errRecurringChanged
+		}
+
+		if status == "posted" {
+			if err := postOccurrence(tx, recurring, &occurrence, postedOn, nil); err != nil {
+				return err
+			}
+		}
+
+		recurring.LastRunDate = &now
+		if ok {
+			recurring.NextRunDate = next
+		} else {
+			recurring.IsActive = false
+		}
+		recorded = true
+		return nil
+	})
+	if errors.Is(err, errRecurringChanged) {
+		return false, nil
+	}
+	return recorded, err
+}
+
+// queuedStatuses are the occurrences waiting for the user: missed ones
+// queued for review and due ones of rules that need confirmation
+var queuedStatuses = []string{"review", "pending"}
+
+// FindQueuedOccurrences returns the user's occurrences with status, review
+// or pending, oldest first. Snoozed ones are left out until their snooze
+// ends unless includeSnoozed is set, and so are those of deleted rules.
+func (r *RecurringRepository) FindQueuedOccurrences(userID uint, status string, includeSnoozed bool, now time.Time) ([]models.RecurringOccurrence, error) {
+	var occurrences []models.RecurringOccurrence
+	query := r.db.Where("user_id = ? AND status = ?", userID, status).
+		Where("recurring_id IN (SELECT id FROM recurring_transactions WHERE deleted_at IS NULL)")
+	if !includeSnoozed {
+		query = query.Where("snoozed_until IS NULL OR snoozed_until <= ?", now)
+	}
+	err := query.Preload("Recurring").
+		Order("occurrence_date asc").
+		Find(&occurrences).Error
+	return occurrences, err
+}
+
+func (r *RecurringRepository) FindOccurrenceByID(id uint) (*models.RecurringOccurrence, error) {
+	var occurrence models.RecurringOccurrence
+	err := r.db.First(&occurrence, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &occurrence, nil
+}
+
+// OccurrenceEdit changes what a confirmed occurrence posts
+type OccurrenceEdit struct {
+	Amount *float64
+	Date   *time.Time
+}
+
+// ResolveOccurrence posts or skips a queued occurrence. A posted one gets
+// its transaction on the scheduled date with the rule's current amount,
+// unless edit says otherwise. It returns false when the occurrence was no
+// longer queued, e.g. because it was resolved concurrently, and
+// ErrOccurrenceOrphaned when posting it needs a deleted rule or wallet.
+func (r *RecurringRepository) ResolveOccurrence(occurrence *models.RecurringOccurrence, post bool, edit *OccurrenceEdit) (bool, error) {
+	status := "skipped"
+	if post {
+		status = "posted"
+	}
+
+	resolved := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.RecurringOccurrence{}).
+			Where("id = ? AND status IN ?", occurrence.ID, queuedStatuses).
+			Updates(map[string]interface{}{"status": status, "snoozed_until": nil})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		occurrence.Status = status
+		occurrence.SnoozedUntil = nil
+
+		if post {
+			var recurring models.RecurringTransaction
+			if err := tx.First(&recurring, occurrence.RecurringID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return ErrOccurrenceOrphaned
+				}
+				return err
+			}
+			wallets := []uint{recurring.WalletID}
+			if recurring.TargetWalletID != nil {
+				wallets = append(wallets, *recurring.TargetWalletID)
+			}
+			var found int64
+			if err := tx.Model(&models.Wallet{}).Where("id IN ?", wallets).Count(&found).Error; err != nil {
+				return err
+			}
+			if int(found) != len(wallets) {
+				return ErrOccurrenceOrphaned
+			}
+			if edit == nil {
+				edit = &OccurrenceEdit{}
+			}
+			postedOn := occurrence.OccurrenceDate
+			if edit.Date != nil {
+				postedOn = *edit.Date
+			}
+			if err := postOccurrence(tx, &recurring, occurrence, postedOn, edit); err != nil {
+				return err
+			}
+		}
+
+		resolved = true
+		return nil
+	})
+	return resolved, err
+}
+
+// SnoozeOccurrence hides a queued occurrence until a date. It returns false
+// when the occurrence is no longer queued.
+func (r *RecurringRepository) SnoozeOccurrence(occurrence *models.RecurringOccurrence, until time.Time) (bool, error) {
+	result := r.db.Model(&models.RecurringOccurrence{}).
+		Where("id = ? AND status IN ?", occurrence.ID, queuedStatuses).
+		Update("snoozed_until", until)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	occurrence.SnoozedUntil = &until
+	return true, nil
+}
+
+// postOccurrence creates the transaction of an occurrence dated postedOn,
+// normally its scheduled date, linked back to the rule and occurrence, and
+// updates the wallet balance. Transfers book both legs the same way as a
+// manual transfer. A percentage amount that comes to nothing, e.g. on an
+// empty wallet, marks the occurrence skipped instead. Occurrences the user
+// confirmed come with an edit, possibly empty, and aren't marked automatic.
+func postOccurrence(tx *gorm.DB, recurring *models.RecurringTransaction, occurrence *models.RecurringOccurrence, postedOn time.Time, edit *OccurrenceEdit) error {
+	amount, err := occurrenceAmount(tx, recurring)
+	if err != nil {
+		return err
+	}
+	estimated := recurring.AmountType == "estimated"
+	description := recurring.Description + " (Otomatis)"
+	if edit != nil {
+		description = recurring.Description
+		if edit.Amount != nil {
+			amount = *edit.Amount
+			estimated = false
+		}
+	}
+	if amount <= 0 {
+		occurrence.Status = "skipped"
+		return tx.Model(occurrence).Update("status", occurrence.Status).Error
+	}
+
+	date := occurrence.OccurrenceDate
+	link := func(t *models.Transaction) {
+		t.RecurringID = &recurring.ID
+		t.OccurrenceDate = &date
+	}
+
+	var transaction *models.Transaction
+	if recurring.Type == "transfer" {
+		var source, target models.Wallet
+		if err := tx.First(&source, recurring.WalletID).Error; err != nil {
+			return err
+		}
+		if recurring.TargetWalletID == nil {
+			return errors.New("transfer has no target wallet")
+		}
+		if err := tx.First(&target, *recurring.TargetWalletID).Error; err != nil {
+			return err
+		}
+		if transaction, _, err = createTransfer(tx, &source, &target, amount, postedOn, description, link); err != nil {
+			return err
+		}
+	} else {
+		transaction = &models.Transaction{
+			UserID:      recurring.UserID,
+			WalletID:    recurring.WalletID,
+			CategoryID:  recurring.CategoryID,
+			Amount:      amount,
+			Type:        recurring.Type,
+			Description: description,
+			Date:        postedOn,
+		}
+		link(transaction)
+		if err := tx.Create(transaction).Error; err != nil {
+			return err
+		}
+		if err := adjustBalance(tx, recurring.WalletID, amount, recurring.Type == "income"); err != nil {
+			return err
+		}
+	}
+
+	occurrence.Amount = amount
+	occurrence.TransactionID = &transaction.ID
+	occurrence.Estimated = estimated
+	return tx.Model(occurrence).Updates(map[string]interface{}{"amount": amount, "transaction_id": transaction.ID, "estimated": estimated}).Error
+}
+
+// SetOccurrenceAmount records the actual amount of a posted occurrence, e.g.
+// once the bill it was estimated for arrives. Its transactions, both legs of
+// a transfer, and the wallet balances move along, and the amount feeds the
+// next estimate. It returns false when the occurrence isn't posted.
+func (r *RecurringRepository) SetOccurrenceAmount(occurrence *models.RecurringOccurrence, amount float64) (bool, error) {
+	updated := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.RecurringOccurrence{}).
+			Where("id = ? AND status = ?", occurrence.ID, "posted").
+			Updates(map[string]interface{}{"amount": amount, "estimated": false})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		var transactions []models.Transaction
+		if err := tx.Where("recurring_id = ? AND occurrence_date = ?", occurrence.RecurringID, occurrence.OccurrenceDate).Find(&transactions).Error; err != nil {
+			return err
+		}
+		for _, t := range transactions {
+			// Undo the old effect on the balance, then apply the new one
+			if err := adjustBalance(tx, t.WalletID, t.Amount, t.Type != "income"); err != nil {
+				return err
+			}
+			if err := adjustBalance(tx, t.WalletID, amount, t.Type == "income"); err != nil {
+				return err
+			}
+			if err := tx.Model(&t).Update("amount", amount).Error; err != nil {
+				return err
+			}
+		}
+
+		occurrence.Amount = amount
+		occurrence.Estimated = false
+		updated = true
+		return nil
+	})
+	return updated, err
+}
+
+// occurrenceAmount returns the amount to post: the fixed amount, the
+// estimate, or the percentage of the wallet's current balance, rounded to
+// whole cents. The wallet row stays locked until the posting commits.
+func occurrenceAmount(tx *gorm.DB, recurring *models.RecurringTransaction) (float64, error) {
+	switch recurring.AmountType {
+	case "estimated":
+		return EstimateAmount(tx, recurring)
+	case "percent_balance":
+	default:
+		return recurring.Amount, nil
+	}
+
+	var wallet models.Wallet
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&wallet, recurring.WalletID).Error; err != nil {
+		return 0, err
+	}
+	return math.Round(wallet.Balance*recurring.Amount) / 100, nil
+}
+
+// EstimateAmount returns what the next occurrence of an estimated rule is
+// expected to come to: the average, median or last of the actual amounts of
+// its most recent posted occurrences, rounded to whole cents. Occurrences
+// posted with an estimate nobody corrected don't count, so a guess never
+// feeds itself. Without any actual amounts yet it is the rule's amount.
+func EstimateAmount(db *gorm.DB, recurring *models.RecurringTransaction) (float64, error) {
+	window := recurring.EstimateWindow
+	if window <= 0 {
+		window = 3
+	}
+
+	var amounts []float64
+	err := db.Model(&models.RecurringOccurrence{}).
+		Where("recurring_id = ? AND status = ? AND estimated = ? AND amount > 0", recurring.ID, "posted", false).
+		Order("occurrence_date desc").
+		Limit(window).
+		Pluck("amount", &amounts).Error
+	if err != nil {
+		return 0, err
+	}
+	if len(amounts) == 0 {
+		return recurring.Amount, nil
+	}
+
+	var estimate float64
+	switch recurring.EstimateMethod {
+	case "last":
+		estimate = amounts[0]
+	case "median":
+		sort.Float64s(amounts)
+		mid := len(amounts) / 2
+		estimate = amounts[mid]
+		if len(amounts)%2 == 0 {
+			estimate = (amounts[mid-1] + amounts[mid]) / 2
+		}
+	default:
+		for _, a := range amounts {
+			estimate += a
+		}
+		estimate /= float64(len(amounts))
+	}
+	return math.Round(estimate*100) / 100, nil
+}
+
+// FillEstimate sets EstimatedAmount on an estimated rule
+func (r *RecurringRepository) FillEstimate(recurring *models.RecurringTransaction) error {
+	if recurring.AmountType != "estimated" {
+		return nil
+	}
+	amount, err := EstimateAmount(r.db, recurring)
+	if err != nil {
+		return err
+	}
+	recurring.EstimatedAmount = &amount
+	return nil
+}
+
+// CreateRun starts a run log entry
+func (r *RecurringRepository) CreateRun(run *models.RecurringRun) error {
+	return r.db.Create(run).Error
+}
+
+// FinishRun saves the counters of a run and marks it finished
+func (r *RecurringRepository) FinishRun(run *models.RecurringRun) error {
+	now := time.Now()
+	run.FinishedAt = &now
+	return r.db.Save(run).Error
+}
+
+// adjustBalance adds amount to the wallet balance, or takes it off
+func adjustBalance(tx *gorm.DB, walletID uint, amount float64, add bool) error {
+	if !add {
+		amount = -amount
+	}
+	return tx.Model(&models.Wallet{}).Where("id = ?", walletID).
+		UpdateColumn("balance", gorm.Expr("balance + ?", amount)).Error
+}
+
+// ErrOccurrenceOrphaned refuses to post an occurrence whose recurring
+// transaction or wallet was deleted
+var ErrOccurrenceOrphaned = errors.New("recurring transaction or wallet was deleted")
+
+// errRecurringChanged rolls back an occurrence whose rule was advanced or
+// edited after it was read
+var errRecurringChanged = errors.New("recurring transaction changed")
+
+// RecurrenceRule returns the schedule of recurring: its RRule or, for older
+// rules, one repeating every Frequency. An invalid one falls back to monthly.
+func RecurrenceRule(recurring *models.RecurringTransaction) rrule.Rule {
+	if recurring.RRule != "" {
+		if rule, err := rrule.Parse(recurring.RRule); err == nil {
+			return rule
+		}
+	}
+	rule, err := rrule.FromFrequency(recurring.Frequency)
+	if err != nil {
+		rule, _ = rrule.FromFrequency(rrule.Monthly)
+	}
+	return rule
+}
+
+// NextOccurrence returns the first occurrence of recurring after date, or
+// false when the rule has ended. Occurrences are counted from StartDate, so
+// a rule on the 31st comes back to the 31st after a short month.
+func NextOccurrence(recurring *models.RecurringTransaction, date time.Time) (time.Time, bool) {
+	return RecurrenceRule(recurring).After(recurring.StartDate, date)
+}
+
+// Occurrences returns the occurrences of recurring from from to to, both
+// inclusive, leaving out those before its next run date, which were handled
+// already
+func Occurrences(recurring *models.RecurringTransaction, from, to time.Time) []time.Time {
+	if recurring.NextRunDate.After(from) {
+		from = recurring.NextRunDate
+	}
+	return RecurrenceRule(recurring).Between(recurring.StartDate, from, to)
+}
@@ -7,6 +7,10 @@ import (
 	"gorm.io/gorm"
 )
 
+// netExpenseSum sums expenses minus the refunds booked against them, so a
+// refunded purchase no longer counts towards its category's spending.
+const netExpenseSum = "COALESCE(SUM(CASE WHEN transactions.type = 'refund' THEN -transactions.amount ELSE transactions.amount END), 0)"
+
 type TransactionRepository struct {
 	db *gorm.DB
 }
@@ -19,6 +23,78 @@ func (r *TransactionRepository) Create(transaction *models.Transaction) error {
 	return r.db.Create(transaction).Error
 }
 
+// CreateBatch inserts imported transactions in a single database transaction
+// and applies their net effect to each affected wallet's balance.
+func (r *TransactionRepository) CreateBatch(transactions []models.Transaction) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(&transactions, 500).Error; err != nil {
+			return err
+		}
+
+		net := make(map[uint]float64)
+		for _, t := range transactions {
+			if t.Type == "income" || t.Type == "refund" {
+				net[t.WalletID] += t.Amount
+			} else {
+				net[t.WalletID] -= t.Amount
+			}
+		}
+
+		for walletID, amount := range net {
+			if err := tx.Model(&models.Wallet{}).Where("id = ?", walletID).
+				UpdateColumn("balance", gorm.Expr("balance + ?", amount)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FindExistingExternalIDs returns which of the given bank transaction IDs
+// were already imported into the wallet.
+func (r *TransactionRepository) FindExistingExternalIDs(walletID uint, externalIDs []string) (map[string]bool, error) {
+	existing := make(map[string]bool)
+	if len(externalIDs) == 0 {
+		return existing, nil
+	}
+
+	var found []string
+	err := r.db.Model(&models.Transaction{}).
+		Where("wallet_id = ? AND external_id IN ?", walletID, externalIDs).
+		Pluck("external_id", &found).Error
+	for _, id := range found {
+		existing[id] = true
+	}
+	return existing, err
+}
+
+// DescriptionCategory is how often a description was booked to a category
+type DescriptionCategory struct {
+	Description string
+	Type        string
+	CategoryID  uint
+	Uses        int
+}
+
+// GetDescriptionCategories summarises the user's recent transactions by
+// description and category, most used first. It feeds category suggestions
+// for imported statement lines.
+func (r *TransactionRepository) GetDescriptionCategories(userID uint, since time.Time) ([]DescriptionCategory, error) {
+	var results []DescriptionCategory
+	err := r.db.Model(&models.Transaction{}).
+		Select("description, type, category_id, COUNT(*) as uses").
+		Where("user_id = ? AND date >= ? AND type IN ? AND description <> ''", userID, since, []string{"income", "expense"}).
+		Group("description, type, category_id").
+		Order("uses desc").
+		Limit(5000).
+		Scan(&results).Error
+	return results, err
+}
+
 func (r *TransactionRepository) FindByID(id uint) (*models.Transaction, error) {
 	var transaction models.Transaction
 	err := r.db.Preload("Category").First(&transaction, id).Error
@@ -32,6 +108,89 @@ func (r *TransactionRepository) Search(userID uint, startDate, endDate *time.Tim
 	var transactions []models.Transaction
 	var total int64
 
+	query := r.searchQuery(userID, startDate, endDate, categoryID, transactionType, search)
+
+	query.Count(&total)
+
+	err := query.Preload("Category").
+		Order("date desc").
+		Limit(limit).
+		Offset(offset).
+		Find(&transactions).Error
+
+	return transactions, total, err
+}
+
+// SearchInBatches walks all transactions matching the Search filters oldest
+// first, handing them to fn batch by batch so exports never hold the whole
+// result. Pages are keyed on (date, id), which stays stable while reading.
+func (r *TransactionRepository) SearchInBatches(userID uint, startDate, endDate *time.Time, categoryID *uint, transactionType, search string, batchSize int, fn func([]models.Transaction) error) error {
+	var lastDate time.Time
+	var lastID uint
+
+	for first := true; ; first = false {
+		query := r.searchQuery(userID, startDate, endDate, categoryID, transactionType, search)
+		if !first {
+			query = query.Where("(date > ? OR (date = ? AND id > ?))", lastDate, lastDate, lastID)
+		}
+
+		var batch []models.Transaction
+		err := query.Preload("Category").Preload("Wallet").
+			Order("date asc, id asc").
+			Limit(batchSize).
+			Find(&batch).Error
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+		if len(batch) < batchSize {
+			return nil
+		}
+
+		last := batch[len(batch)-1]
+		lastDate, lastID = last.Date, last.ID
+	}
+}
+
+// WalletBalancesAt returns the balance every wallet of the user had just
+// before from, its current balance less the net of the transactions since.
+// A nil from gives the balance before any transaction.
+func (r *TransactionRepository) WalletBalancesAt(userID uint, from *time.Time) (map[uint]float64, error) {
+	join := "LEFT JOIN transactions ON transactions.wallet_id = wallets.id AND transactions.deleted_at IS NULL"
+	var args []interface{}
+	if from != nil {
+		join += " AND transactions.date >= ?"
+		args = append(args, *from)
+	}
+
+	var rows []struct {
+		ID      uint
+		Balance float64
+		Later   float64
+	}
+	err := r.db.Unscoped().Table("wallets").
+		Select("wallets.id, wallets.balance, COALESCE(SUM(CASE WHEN transactions.type IN ('income', 'refund') THEN transactions.amount ELSE -transactions.amount END), 0) AS later").
+		Joins(join, args...).
+		Where("wallets.user_id = ?", userID).
+		Group("wallets.id, wallets.balance").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make(map[uint]float64)
+	for _, row := range rows {
+		balances[row.ID] = row.Balance - row.Later
+	}
+	return balances, nil
+}
+
+func (r *TransactionRepository) searchQuery(userID uint, startDate, endDate *time.Time, categoryID *uint, transactionType, search string) *gorm.DB {
 	query := r.db.Model(&models.Transaction{}).Where("user_id = ?", userID)
 
 	if startDate != nil {
@@ -51,16 +210,7 @@ func (r *TransactionRepository) Search(userID uint, startDate, endDate *time.Tim
 		// Category name search requires join. For now search description/notes.
 		query = query.Where("description LIKE ? OR notes LIKE ?", "%"+search+"%", "%"+search+"%")
 	}
-
-	query.Count(&total)
-
-	err := query.Preload("Category").
-		Order("date desc").
-		Limit(limit).
-		Offset(offset).
-		Find(&transactions).Error
-
-	return transactions, total, err
+	return query
 }
 
 func (r *TransactionRepository) FindByUserID(userID uint, limit, offset int) ([]models.Transaction, int64, error) {
@@ -77,6 +227,34 @@ func (r *TransactionRepository) FindByUserIDAndDateRange(userID uint, startDate,
 	return transactions, err
 }
 
+// FindByWalletAndDateRange returns a wallet's transactions oldest first.
+// Nil bounds are open.
+func (r *TransactionRepository) FindByWalletAndDateRange(walletID uint, startDate, endDate *time.Time) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+	query := r.db.Preload("Category").Where("wallet_id = ?", walletID)
+	if startDate != nil {
+		query = query.Where("date >= ?", *startDate)
+	}
+	if endDate != nil {
+		query = query.Where("date <= ?", *endDate)
+	}
+	err := query.Order("date asc, id asc").Find(&transactions).Error
+	return transactions, err
+}
+
+// FindByUserIDSince returns the user's transactions from startDate (nil for
+// all) oldest first. Categories are preloaded even when deleted.
+func (r *TransactionRepository) FindByUserIDSince(userID uint, startDate *time.Time) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+	query := r.db.Preload("Category", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Where("user_id = ?", userID)
+	if startDate != nil {
+		query = query.Where("date >= ?", *startDate)
+	}
+	err := query.Order("date asc, id asc").Find(&transactions).Error
+	return transactions, err
+}
+
 func (r *TransactionRepository) FindByUserIDAndCategory(userID, categoryID uint) ([]models.Transaction, error) {
 	var transactions []models.Transaction
 	err := r.db.Preload("Category").
@@ -86,14 +264,47 @@ func (r *TransactionRepository) FindByUserIDAndCategory(userID, categoryID uint)
 	return transactions, err
 }
 
+// Update saves a transaction. A new amount on one posted by a recurring rule
+// is recorded as the actual amount of its occurrence, for the rule's next
+// estimate.
 func (r *TransactionRepository) Update(transaction *models.Transaction) error {
-	return r.db.Save(transaction).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(transaction).Error; err != nil {
+			return err
+		}
+		if transaction.RecurringID == nil || transaction.OccurrenceDate == nil {
+			return nil
+		}
+		return tx.Model(&models.RecurringOccurrence{}).
+			Where("recurring_id = ? AND occurrence_date = ? AND status = ? AND amount <> ?",
+				*transaction.RecurringID, *transaction.OccurrenceDate, "posted", transaction.Amount).
+			Updates(map[string]interface{}{"amount": transaction.Amount, "estimated": false}).Error
+	})
 }
 
 func (r *TransactionRepository) Delete(id uint) error {
 	return r.db.Unscoped().Delete(&models.Transaction{}, id).Error
 }
 
+// GetRefundedAmount returns how much of an expense has already been refunded,
+// ignoring the refund with excludeID (used when editing an existing refund).
+func (r *TransactionRepository) GetRefundedAmount(originalID, excludeID uint) (float64, error) {
+	var total float64
+	err := r.db.Model(&models.Transaction{}).
+		Where("refund_of_id = ? AND type = ? AND id <> ?", originalID, "refund", excludeID).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&total).Error
+	return total, err
+}
+
+func (r *TransactionRepository) CountRefunds(originalID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.Transaction{}).
+		Where("refund_of_id = ? AND type = ?", originalID, "refund").
+		Count(&count).Error
+	return count, err
+}
+
 func (r *TransactionRepository) GetSummary(userID uint, startDate, endDate time.Time) (*models.TransactionSummary, error) {
 	var summary models.TransactionSummary
 
@@ -108,12 +319,12 @@ func (r *TransactionRepository) GetSummary(userID uint, startDate, endDate time.
 		Select("COALESCE(SUM(transactions.amount), 0)").
 		Scan(&summary.TotalIncome)
 
-	// Get total expense
+	// Get total expense (net of refunds)
 	r.db.Table("transactions").
 		Joins("JOIN categories ON categories.id = transactions.category_id").
-		Where("transactions.user_id = ? AND transactions.type = ? AND transactions.date >= ? AND transactions.date <= ?", userID, "expense", startDate, endDate).
+		Where("transactions.user_id = ? AND transactions.type IN ? AND transactions.date >= ? AND transactions.date <= ?", userID, []string{"expense", "refund"}, startDate, endDate).
 		Where("LOWER(categories.name) NOT IN ?", excludeNames).
-		Select("COALESCE(SUM(transactions.amount), 0)").
+		Select(netExpenseSum).
 		Scan(&summary.TotalExpense)
 
 	// Get transaction count
@@ -140,9 +351,9 @@ func (r *TransactionRepository) GetCategorySpending(userID uint, startDate, endD
 
 	r.db.Table("transactions").
 		Joins("JOIN categories ON categories.id = transactions.category_id").
-		Where("transactions.user_id = ? AND transactions.type = ? AND transactions.date >= ? AND transactions.date <= ?", userID, "expense", startDate, endDate).
+		Where("transactions.user_id = ? AND transactions.type IN ? AND transactions.date >= ? AND transactions.date <= ?", userID, []string{"expense", "refund"}, startDate, endDate).
 		Where("LOWER(categories.name) NOT IN ?", excludeNames).
-		Select("transactions.category_id, SUM(transactions.amount) as total").
+		Select("transactions.category_id, " + netExpenseSum + " as total").
 		Group("transactions.category_id").
 		Scan(&results)
 
@@ -186,9 +397,12 @@ func (r *TransactionRepository) GetDailyTrends(userID uint, startDate, endDate t
 		if _, ok := trendMap[dateStr]; !ok {
 			trendMap[dateStr] = &DailyTrend{Date: dateStr}
 		}
-		if tx.Type == "income" {
+		switch tx.Type {
+		case "income":
 			trendMap[dateStr].Income += tx.Amount
-		} else {
+		case "refund":
+			trendMap[dateStr].Expense -= tx.Amount
+		default:
 			trendMap[dateStr].Expense += tx.Amount
 		}
 	}
@@ -214,3 +428,76 @@ func (r *TransactionRepository) GetDailyTrends(userID uint, startDate, endDate t
 
 	return sortedTrends, nil
 }
+
+// CreateTransfer books a transfer between two wallets as an expense from the
+// source and an income to the target in the transfer category, moving both
+// balances, in one database transaction
+func (r *TransactionRepository) CreateTransfer(source, target *models.Wallet, amount float64, date time.Time, notes string) (expense, income *models.Transaction, err error) {
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		expense, income, err = createTransfer(tx, source, target, amount, date, notes, nil)
+		return err
+	})
+	return expense, income, err
+}
+
+// createTransfer books both legs of a transfer; link, when set, is applied
+// to each leg before it is created
+func createTransfer(tx *gorm.DB, source, target *models.Wallet, amount float64, date time.Time, notes string, link func(*models.Transaction)) (*models.Transaction, *models.Transaction, error) {
+	category, err := findOrCreateTransferCategory(tx, source.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expense := &models.Transaction{
+		UserID:      source.UserID,
+		WalletID:    source.ID,
+		CategoryID:  category.ID,
+		Amount:      amount,
+		Type:        "expense",
+		Description: "Transfer ke " + target.Name,
+		Date:        date,
+		Notes:       notes,
+	}
+	income := &models.Transaction{
+		UserID:      target.UserID,
+		WalletID:    target.ID,
+		CategoryID:  category.ID,
+		Amount:      amount,
+		Type:        "income",
+		Description: "Transfer dari " + source.Name,
+		Date:        date,
+		Notes:       notes,
+	}
+
+	for _, t := range []*models.Transaction{expense, income} {
+		if link != nil {
+			link(t)
+		}
+		if err := tx.Create(t).Error; err != nil {
+			return nil, nil, err
+		}
+		delta := t.Amount
+		if t.Type == "expense" {
+			delta = -delta
+		}
+		if err := tx.Model(&models.Wallet{}).Where("id = ?", t.WalletID).
+			UpdateColumn("balance", gorm.Expr("balance + ?", delta)).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	return expense, income, nil
+}
+
+// FindRecurringCandidates returns the user's income and expenses since a
+// date that weren't posted by a recurring rule, leaving out transfers,
+// oldest first
+func (r *TransactionRepository) FindRecurringCandidates(userID uint, since time.Time) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+	err := r.db.Joins("JOIN categories ON categories.id = transactions.category_id").
+		Where("transactions.user_id = ? AND transactions.date >= ? AND transactions.recurring_id IS NULL", userID, since).
+		Where("transactions.type IN ?", []string{"income", "expense"}).
+		Where("LOWER(categories.name) NOT IN ?", []string{"transfer", "transfer out", "transfer in"}).
+		Order("transactions.date asc").
+		Find(&transactions).Error
+	return transactions, err
+}
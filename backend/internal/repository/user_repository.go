@@ -56,3 +56,10 @@ func (r *UserRepository) FindByName(name string) (*models.User, error) {
 func (r *UserRepository) Update(user *models.User) error {
 	return r.db.Save(user).Error
 }
+
+// FindAllIDs returns the IDs of all users, for jobs that run per user
+func (r *UserRepository) FindAllIDs() ([]uint, error) {
+	var ids []uint
+	err := r.db.Model(&models.User{}).Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
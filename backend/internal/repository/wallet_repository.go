@@ -32,6 +32,24 @@ func (r *WalletRepository) FindByUserID(userID uint) ([]models.Wallet, error) {
 	return wallets, err
 }
 
+// FindByUserIDWithDeleted also returns deleted wallets, which old
+// transactions may still belong to
+func (r *WalletRepository) FindByUserIDWithDeleted(userID uint) ([]models.Wallet, error) {
+	var wallets []models.Wallet
+	err := r.db.Unscoped().Where("user_id = ?", userID).Order("id").Find(&wallets).Error
+	return wallets, err
+}
+
+// CountRecurring counts the recurring transactions, paused ones included,
+// that post from or transfer to the wallet
+func (r *WalletRepository) CountRecurring(walletID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.RecurringTransaction{}).
+		Where("wallet_id = ? OR target_wallet_id = ?", walletID, walletID).
+		Count(&count).Error
+	return count, err
+}
+
 func (r *WalletRepository) FindDefaultByUserID(userID uint) (*models.Wallet, error) {
 	var wallet models.Wallet
 	err := r.db.Where("user_id = ? AND is_default = ?", userID, true).First(&wallet).Error
@@ -102,7 +120,7 @@ func (r *WalletRepository) RecalculateBalance(walletID uint) error {
 	var totalExpense float64
 
 	r.db.Model(&models.Transaction{}).
-		Where("wallet_id = ? AND type = ?", walletID, "income").
+		Where("wallet_id = ? AND type IN ?", walletID, []string{"income", "refund"}).
 		Select("COALESCE(SUM(amount), 0)").
 		Scan(&totalIncome)
 
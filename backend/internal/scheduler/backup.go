@@ -0,0 +1,132 @@
+// Package scheduler runs background jobs inside the server process.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/money-management/backend/internal/repository"
+	"github.com/money-management/backend/pkg/config"
+	"github.com/money-management/backend/pkg/snapshot"
+)
+
+// backupKind names the snapshots taken by the scheduler, retention only
+// prunes these
+const backupKind = "scheduled"
+
+// BackupScheduler snapshots the data of every user on a schedule and prunes
+// old scheduled snapshots with a retention policy
+type BackupScheduler struct {
+	userRepo   *repository.UserRepository
+	backupRepo *repository.BackupRepository
+	snapshots  *snapshot.Store
+	schedule   string
+	hour       int
+	minute     int
+	retention  snapshot.Retention
+}
+
+func NewBackupScheduler(userRepo *repository.UserRepository, backupRepo *repository.BackupRepository, snapshots *snapshot.Store, cfg *config.Config) (*BackupScheduler, error) {
+	switch cfg.BackupSchedule {
+	case "off", "hourly", "daily", "weekly":
+	default:
+		return nil, fmt.Errorf("invalid backup schedule %q, use off, hourly, daily or weekly", cfg.BackupSchedule)
+	}
+	at, err := time.Parse("15:04", cfg.BackupTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup time %q, use HH:MM", cfg.BackupTime)
+	}
+
+	return &BackupScheduler{
+		userRepo:   userRepo,
+		backupRepo: backupRepo,
+		snapshots:  snapshots,
+		schedule:   cfg.BackupSchedule,
+		hour:       at.Hour(),
+		minute:     at.Minute(),
+		retention: snapshot.Retention{
+			Daily:   cfg.BackupKeepDaily,
+			Weekly:  cfg.BackupKeepWeekly,
+			Monthly: cfg.BackupKeepMonthly,
+		},
+	}, nil
+}
+
+// Start runs backups in the background until ctx is cancelled
+func (s *BackupScheduler) Start(ctx context.Context) {
+	if s.schedule == "off" {
+		log.Println("Scheduled backups are off")
+		return
+	}
+
+	go func() {
+		for {
+			next := s.next(time.Now())
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				s.Run()
+			}
+		}
+	}()
+}
+
+// next returns the first run after now. Daily and weekly backups run at the
+// configured local time, weekly ones on Sunday; hourly ones at the
+// configured minute of every hour.
+func (s *BackupScheduler) next(now time.Time) time.Time {
+	switch s.schedule {
+	case "hourly":
+		next := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), s.minute, 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.Add(time.Hour)
+		}
+		return next
+	case "weekly":
+		next := time.Date(now.Year(), now.Month(), now.Day(), s.hour, s.minute, 0, 0, now.Location())
+		next = next.AddDate(0, 0, (7-int(next.Weekday()))%7)
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 7)
+		}
+		return next
+	default:
+		next := time.Date(now.Year(), now.Month(), now.Day(), s.hour, s.minute, 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		return next
+	}
+}
+
+// Run backs up every user once. A failure for one user is logged and
+// doesn't stop the others.
+func (s *BackupScheduler) Run() {
+	userIDs, err := s.userRepo.FindAllIDs()
+	if err != nil {
+		log.Printf("Scheduled backup: failed to list users: %v", err)
+		return
+	}
+
+	for _, userID := range userIDs {
+		if err := s.backup(userID); err != nil {
+			log.Printf("Scheduled backup for user %d failed: %v", userID, err)
+		}
+	}
+}
+
+func (s *BackupScheduler) backup(userID uint) error {
+	_, err := s.snapshots.SaveFunc(userID, backupKind, "ndjson", func(w io.Writer) error {
+		return s.backupRepo.ExportStream(userID, w)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.snapshots.Prune(userID, backupKind, s.retention)
+	return err
+}
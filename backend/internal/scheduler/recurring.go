@@ -0,0 +1,155 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/money-management/backend/internal/models"
+	"github.com/money-management/backend/internal/repository"
+	"github.com/money-management/backend/pkg/config"
+)
+
+// maxOccurrencesPerRun bounds the work of one rule in one run, e.g. a daily
+// rule started years ago; the next run carries on
+const maxOccurrencesPerRun = 400
+
+// RecurringScheduler posts the due recurring transactions of every user on
+// an interval, whether or not anybody has the app open. Each occurrence is
+// posted under an idempotency key, so overlapping runs in this process or in
+// other replicas never post it twice.
+type RecurringScheduler struct {
+	recurringRepo *repository.RecurringRepository
+	interval      time.Duration
+	mu            sync.Mutex // Serializes the runs of this process
+}
+
+func NewRecurringScheduler(recurringRepo *repository.RecurringRepository, cfg *config.Config) *RecurringScheduler {
+	interval := time.Duration(cfg.RecurringIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	return &RecurringScheduler{
+		recurringRepo: recurringRepo,
+		interval:      interval,
+	}
+}
+
+// Start runs once right away, to catch up after downtime, and then on every
+// interval until ctx is cancelled
+func (s *RecurringScheduler) Start(ctx context.Context) {
+	go func() {
+		s.Run()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.Run()
+			}
+		}
+	}()
+}
+
+// Run posts the due recurring transactions of every user
+func (s *RecurringScheduler) Run() *models.RecurringRun {
+	return s.run("schedule", nil)
+}
+
+// RunForUser posts the due recurring transactions of one user, e.g. right
+// after they created one that starts today
+func (s *RecurringScheduler) RunForUser(userID uint) *models.RecurringRun {
+	return s.run("user", &userID)
+}
+
+func (s *RecurringScheduler) run(trigger string, userID *uint) *models.RecurringRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	run := &models.RecurringRun{StartedAt: now, Trigger: trigger, UserID: userID}
+	if err := s.recurringRepo.CreateRun(run); err != nil {
+		log.Printf("Recurring run: failed to create run log: %v", err)
+	}
+
+	var pending []models.RecurringTransaction
+	var err error
+	if userID != nil {
+		pending, err = s.recurringRepo.FindPending(*userID, now)
+	} else {
+		pending, err = s.recurringRepo.FindAllPending(now)
+	}
+	if err != nil {
+		log.Printf("Recurring run: failed to find due transactions: %v", err)
+		run.Error = err.Error()
+		s.finish(run)
+		return run
+	}
+
+	run.Due = len(pending)
+	for i := range pending {
+		if err := s.catchUp(&pending[i], now, run); err != nil {
+			// Left due from the failed occurrence on, the next run retries it
+			log.Printf("Recurring run: failed to post recurring transaction %d: %v", pending[i].ID, err)
+			run.Failed++
+			run.Error = err.Error()
+		}
+	}
+
+	s.finish(run)
+	if run.Posted > 0 || run.Missed > 0 || run.Queued > 0 || run.Failed > 0 {
+		log.Printf("Recurring run %d: %d due, %d posted, %d skipped, %d missed, %d queued, %d failed",
+			run.ID, run.Due, run.Posted, run.Skipped, run.Missed, run.Queued, run.Failed)
+	}
+	return run
+}
+
+// catchUp handles every occurrence of recurring that is due, each on its
+// scheduled date, in order. The most recent one is posted, or queued for
+// confirmation when the rule asks for it; older, missed ones are handled
+// the same way, skipped or queued for review by the rule's backlog policy.
+func (s *RecurringScheduler) catchUp(recurring *models.RecurringTransaction, now time.Time, run *models.RecurringRun) error {
+	for n := 0; n < maxOccurrencesPerRun && recurring.IsActive && !recurring.NextRunDate.After(now); n++ {
+		status := "posted"
+		if recurring.RequireConfirmation {
+			status = "pending"
+		}
+		if next, ok := repository.NextOccurrence(recurring, recurring.NextRunDate); ok && !next.After(now) {
+			switch recurring.BacklogPolicy {
+			case "latest":
+				status = "skipped"
+			case "review":
+				status = "review"
+			}
+		}
+
+		recorded, err := s.recurringRepo.RecordOccurrence(recurring, status, now)
+		if err != nil {
+			return err
+		}
+		if !recorded {
+			// Another run got there first and carries on from here
+			run.Skipped++
+			return nil
+		}
+		switch status {
+		case "posted":
+			run.Posted++
+		case "skipped":
+			run.Missed++
+		case "review", "pending":
+			run.Queued++
+		}
+	}
+	return nil
+}
+
+func (s *RecurringScheduler) finish(run *models.RecurringRun) {
+	if err := s.recurringRepo.FinishRun(run); err != nil {
+		log.Printf("Recurring run: failed to save run log: %v", err)
+	}
+}
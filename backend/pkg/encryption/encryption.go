@@ -0,0 +1,263 @@
+// Package encryption encrypts backup archives with a passphrase.
+//
+// The key is derived with Argon2id and the data is sealed with
+// XChaCha20-Poly1305 in chunks, so archives of any size can be streamed.
+// Every chunk is authenticated together with the header and its position,
+// and the last chunk is marked, so reordered, modified or truncated archives
+// are rejected. A verifier in the header tells a wrong passphrase apart from
+// a damaged archive before any data is decrypted.
+//
+// Layout:
+//
+//	magic "MMBACKUP" | version 1 | argon2 time (u32) | memory KiB (u32) | threads (u8)
+//	| salt (16) | nonce prefix (16) | verifier (32)
+//	then chunks: ciphertext length (u32) | ciphertext
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	magic   = "MMBACKUP"
+	version = 1
+
+	chunkSize = 64 * 1024
+
+	saltSize        = 16
+	noncePrefixSize = chacha20poly1305.NonceSizeX - 8 // The rest is the chunk counter
+	verifierSize    = sha256.Size
+	headerSize      = len(magic) + 1 + 4 + 4 + 1 + saltSize + noncePrefixSize + verifierSize
+
+	// Argon2id parameters for new archives (RFC 9106 second recommendation)
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+
+	// Limits for archives being opened, so a crafted header can't make the
+	// server spend minutes or gigabytes on key derivation
+	maxArgonTime    = 10
+	maxArgonMemory  = 256 * 1024
+	maxArgonThreads = 16
+)
+
+var (
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+	ErrCorrupted       = errors.New("encrypted backup is corrupted or has been tampered with")
+)
+
+// MagicSize is how many bytes IsEncrypted needs
+const MagicSize = len(magic)
+
+// IsEncrypted reports whether data starts like an encrypted archive
+func IsEncrypted(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, []byte(magic))
+}
+
+// NewWriter returns a writer that encrypts everything written to it into w.
+// Close must be called to write the final chunk; it does not close w.
+func NewWriter(w io.Writer, passphrase string) (io.WriteCloser, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase is empty")
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[len(magic)] = version
+	binary.BigEndian.PutUint32(header[len(magic)+1:], argonTime)
+	binary.BigEndian.PutUint32(header[len(magic)+5:], argonMemory)
+	header[len(magic)+9] = argonThreads
+	random := header[len(magic)+10 : headerSize-verifierSize] // Salt and nonce prefix
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+
+	aead, verifier, err := deriveKeys(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+	copy(header[headerSize-verifierSize:], verifier)
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: aead, header: header}, nil
+}
+
+// NewReader checks the header and the passphrase of an archive and returns
+// a reader for its plaintext. Reads fail with ErrCorrupted as soon as a
+// chunk doesn't authenticate or the archive ends before its last chunk, so
+// callers must read to the end before trusting any of the data.
+func NewReader(r io.Reader, passphrase string) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrCorrupted
+	}
+	if !IsEncrypted(header) || header[len(magic)] != version {
+		return nil, errors.New("unsupported encrypted backup format")
+	}
+
+	time := binary.BigEndian.Uint32(header[len(magic)+1:])
+	memory := binary.BigEndian.Uint32(header[len(magic)+5:])
+	threads := header[len(magic)+9]
+	if time == 0 || time > maxArgonTime || memory == 0 || memory > maxArgonMemory || threads == 0 || threads > maxArgonThreads {
+		return nil, ErrCorrupted
+	}
+
+	aead, verifier, err := deriveKeys(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(verifier, header[headerSize-verifierSize:]) {
+		return nil, ErrWrongPassphrase
+	}
+	return &reader{r: bufio.NewReader(r), aead: aead, header: header}, nil
+}
+
+// deriveKeys derives the AEAD key and the passphrase verifier from the
+// header's KDF parameters and salt
+func deriveKeys(passphrase string, header []byte) (cipher.AEAD, []byte, error) {
+	time := binary.BigEndian.Uint32(header[len(magic)+1:])
+	memory := binary.BigEndian.Uint32(header[len(magic)+5:])
+	threads := header[len(magic)+9]
+	salt := header[len(magic)+10 : len(magic)+10+saltSize]
+
+	keys := argon2.IDKey([]byte(passphrase), salt, time, memory, threads, chacha20poly1305.KeySize+32)
+	aead, err := chacha20poly1305.NewX(keys[:chacha20poly1305.KeySize])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mac := hmac.New(sha256.New, keys[chacha20poly1305.KeySize:])
+	mac.Write(header[:headerSize-verifierSize])
+	return aead, mac.Sum(nil), nil
+}
+
+// chunkNonce is the nonce prefix followed by the chunk number
+func chunkNonce(header []byte, counter uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	copy(nonce, header[len(magic)+10+saltSize:headerSize-verifierSize])
+	binary.BigEndian.PutUint64(nonce[noncePrefixSize:], counter)
+	return nonce
+}
+
+// chunkAAD binds a chunk to the header and marks the last one
+func chunkAAD(header []byte, final bool) []byte {
+	aad := make([]byte, len(header)+1)
+	copy(aad, header)
+	if final {
+		aad[len(header)] = 1
+	}
+	return aad
+}
+
+type writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	counter uint64
+	buf     []byte
+	closed  bool
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed encryption writer")
+	}
+	w.buf = append(w.buf, p...)
+	// Keep the tail buffered, the last chunk is only known on Close
+	for len(w.buf) > chunkSize {
+		if err := w.seal(w.buf[:chunkSize], false); err != nil {
+			return 0, err
+		}
+		w.buf = append(w.buf[:0], w.buf[chunkSize:]...)
+	}
+	return len(p), nil
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(w.buf, true)
+}
+
+func (w *writer) seal(plaintext []byte, final bool) error {
+	ciphertext := w.aead.Seal(nil, chunkNonce(w.header, w.counter), plaintext, chunkAAD(w.header, final))
+	w.counter++
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(ciphertext)))
+	if _, err := w.w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(ciphertext)
+	return err
+}
+
+type reader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	counter uint64
+	buf     []byte // Decrypted, not yet read
+	done    bool   // The final chunk was read
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *reader) next() error {
+	var length [4]byte
+	if _, err := io.ReadFull(r.r, length[:]); err != nil {
+		return ErrCorrupted // Ended before the final chunk
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size < uint32(r.aead.Overhead()) || size > chunkSize+uint32(r.aead.Overhead()) {
+		return ErrCorrupted
+	}
+	ciphertext := make([]byte, size)
+	if _, err := io.ReadFull(r.r, ciphertext); err != nil {
+		return ErrCorrupted
+	}
+
+	nonce := chunkNonce(r.header, r.counter)
+	plaintext, err := r.aead.Open(nil, nonce, ciphertext, chunkAAD(r.header, false))
+	if err != nil {
+		plaintext, err = r.aead.Open(nil, nonce, ciphertext, chunkAAD(r.header, true))
+		if err != nil {
+			return ErrCorrupted
+		}
+		r.done = true
+		// Nothing may follow the final chunk
+		if _, err := r.r.ReadByte(); err != io.EOF {
+			return ErrCorrupted
+		}
+	}
+	r.counter++
+	r.buf = plaintext
+	return nil
+}
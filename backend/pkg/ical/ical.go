@@ -0,0 +1,115 @@
+// Package ical writes iCalendar (RFC 5545) feeds of all-day events.
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Calendar is a feed that calendar apps can subscribe to
+type Calendar struct {
+	Name    string
+	Refresh time.Duration // How often clients should fetch the feed again
+	Events  []Event
+}
+
+// Event is an all-day event
+type Event struct {
+	UID          string // Stable across fetches, so clients update instead of duplicating
+	Date         time.Time
+	Summary      string
+	Description  string
+	Categories   []string
+	LastModified time.Time
+	// AlarmBefore reminds this long before the start of the day; zero
+	// means no reminder
+	AlarmBefore time.Duration
+}
+
+// Write writes the calendar with CRLF line endings and folded lines
+func (c *Calendar) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeFolded(bw, name+":"+value)
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//money-management//Calendar Feed//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		line("X-WR-CALNAME", escape(c.Name))
+	}
+	if c.Refresh > 0 {
+		refresh := fmt.Sprintf("PT%dM", int(c.Refresh.Minutes()))
+		line("REFRESH-INTERVAL;VALUE=DURATION", refresh)
+		line("X-PUBLISHED-TTL", refresh)
+	}
+
+	for _, e := range c.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", escape(e.UID))
+		line("DTSTAMP", stamp)
+		line("DTSTART;VALUE=DATE", e.Date.Format("20060102"))
+		line("DTEND;VALUE=DATE", e.Date.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY", escape(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION", escape(e.Description))
+		}
+		if len(e.Categories) > 0 {
+			escaped := make([]string, len(e.Categories))
+			for i, category := range e.Categories {
+				escaped[i] = escape(category)
+			}
+			line("CATEGORIES", strings.Join(escaped, ","))
+		}
+		if !e.LastModified.IsZero() {
+			line("LAST-MODIFIED", e.LastModified.UTC().Format("20060102T150405Z"))
+		}
+		line("TRANSP", "TRANSPARENT")
+		if e.AlarmBefore > 0 {
+			line("BEGIN", "VALARM")
+			line("ACTION", "DISPLAY")
+			line("DESCRIPTION", escape(e.Summary))
+			line("TRIGGER", fmt.Sprintf("-PT%dM", int(e.AlarmBefore.Minutes())))
+			line("END", "VALARM")
+		}
+		line("END", "VEVENT")
+	}
+
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+// escape escapes a TEXT value
+func escape(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, ";", "\\;")
+	s = strings.ReplaceAll(s, ",", "\\,")
+	s = strings.ReplaceAll(s, "\r\n", "\\n")
+	return strings.ReplaceAll(s, "\n", "\\n")
+}
+
+// writeFolded writes a content line, folding it into lines of at most 75
+// octets without splitting UTF-8 characters
+func writeFolded(w *bufio.Writer, s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.WriteString(s[:cut])
+		w.WriteString("\r\n ")
+		s = s[cut:]
+		limit = 74 // The leading space counts
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
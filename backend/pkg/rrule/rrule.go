@@ -0,0 +1,517 @@
+// Package rrule parses and expands recurrence rules in the style of RFC 5545
+// RRULE, e.g. "FREQ=MONTHLY;BYMONTHDAY=25;ADJUST=PREV".
+//
+// Supported parts are FREQ (DAILY, WEEKLY, MONTHLY, YEARLY), INTERVAL,
+// BYDAY (with ordinals such as 1MO or -1FR in monthly and yearly rules),
+// BYMONTHDAY (negative counts from the end of the month), BYMONTH, BYSETPOS,
+// COUNT and UNTIL. Two things differ from the RFC to suit bills:
+//
+//   - A month day past the end of a short month falls on its last day, so
+//     the 31st is Feb 28 rather than skipped.
+//   - ADJUST=PREV or ADJUST=NEXT moves an occurrence on a weekend to the
+//     previous or next business day.
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequencies
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+	Yearly  = "YEARLY"
+)
+
+// Business day adjustments
+const (
+	AdjustNone = ""
+	AdjustPrev = "PREV"
+	AdjustNext = "NEXT"
+)
+
+// maxEmptyPeriods stops rules that can never match, e.g. BYMONTH=2 with
+// BYDAY=1MO;BYMONTHDAY=30
+const maxEmptyPeriods = 1000
+
+// WeekdayNum is a weekday with an optional ordinal, e.g. -1FR is the last
+// Friday. N is 0 for every such weekday of the period.
+type WeekdayNum struct {
+	N       int
+	Weekday time.Weekday
+}
+
+// Rule is a parsed recurrence rule. Occurrences are anchored at a start
+// date, which also supplies the time of day and any part the rule leaves
+// out, e.g. the month day of a plain monthly rule.
+type Rule struct {
+	Freq       string
+	Interval   int
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []int
+	BySetPos   []int
+	Count      int        // 0 = no limit
+	Until      *time.Time // Inclusive
+	Adjust     string
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// FromFrequency returns the rule of a plain frequency: daily, weekly,
+// monthly or yearly
+func FromFrequency(frequency string) (Rule, error) {
+	freq := strings.ToUpper(strings.TrimSpace(frequency))
+	switch freq {
+	case Daily, Weekly, Monthly, Yearly:
+		return Rule{Freq: freq, Interval: 1}, nil
+	}
+	return Rule{}, fmt.Errorf("invalid frequency %q", frequency)
+}
+
+// Parse parses a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH". A leading
+// "RRULE:" is allowed.
+func Parse(s string) (Rule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	rule := Rule{Interval: 1}
+	if s == "" {
+		return rule, errors.New("empty rule")
+	}
+
+	for _, part := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return rule, fmt.Errorf("invalid rule part %q", part)
+		}
+		var err error
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(value)
+			switch rule.Freq {
+			case Daily, Weekly, Monthly, Yearly:
+			default:
+				return rule, fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(value)
+			if err != nil || rule.Interval < 1 {
+				return rule, fmt.Errorf("invalid INTERVAL %q", value)
+			}
+		case "BYDAY":
+			for _, v := range strings.Split(value, ",") {
+				v = strings.ToUpper(strings.TrimSpace(v))
+				if len(v) < 2 {
+					return rule, fmt.Errorf("invalid BYDAY %q", v)
+				}
+				weekday, ok := weekdayNames[v[len(v)-2:]]
+				if !ok {
+					return rule, fmt.Errorf("invalid BYDAY %q", v)
+				}
+				n := 0
+				if ordinal := v[:len(v)-2]; ordinal != "" {
+					n, err = strconv.Atoi(ordinal)
+					if err != nil || n == 0 || n < -53 || n > 53 {
+						return rule, fmt.Errorf("invalid BYDAY %q", v)
+					}
+				}
+				rule.ByDay = append(rule.ByDay, WeekdayNum{N: n, Weekday: weekday})
+			}
+		case "BYMONTHDAY":
+			rule.ByMonthDay, err = parseInts(value, -31, 31)
+		case "BYMONTH":
+			rule.ByMonth, err = parseInts(value, 1, 12)
+		case "BYSETPOS":
+			rule.BySetPos, err = parseInts(value, -366, 366)
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(value)
+			if err == nil && rule.Count < 1 {
+				err = errors.New("must be positive")
+			}
+		case "UNTIL":
+			until, perr := parseUntil(value)
+			if perr != nil {
+				return rule, fmt.Errorf("invalid UNTIL %q", value)
+			}
+			rule.Until = &until
+		case "ADJUST":
+			rule.Adjust = strings.ToUpper(value)
+			if rule.Adjust != AdjustPrev && rule.Adjust != AdjustNext {
+				return rule, fmt.Errorf("invalid ADJUST %q, use PREV or NEXT", value)
+			}
+		default:
+			return rule, fmt.Errorf("unsupported rule part %q", name)
+		}
+		if err != nil {
+			return rule, fmt.Errorf("invalid %s %q", strings.ToUpper(name), value)
+		}
+	}
+
+	if rule.Freq == "" {
+		return rule, errors.New("FREQ is required")
+	}
+	if rule.Count > 0 && rule.Until != nil {
+		return rule, errors.New("COUNT and UNTIL can't be combined")
+	}
+	for _, d := range rule.ByDay {
+		if d.N != 0 && rule.Freq != Monthly && rule.Freq != Yearly {
+			return rule, errors.New("BYDAY ordinals need FREQ=MONTHLY or YEARLY")
+		}
+	}
+	return rule, nil
+}
+
+func parseInts(value string, min, max int) ([]int, error) {
+	var out []int
+	for _, v := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n == 0 || n < min || n > max {
+			return nil, fmt.Errorf("invalid value %q", v)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			if len(value) == 8 || len(value) == 10 {
+				// A date covers the whole day
+				t = t.Add(24*time.Hour - time.Nanosecond)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid date")
+}
+
+// String returns the rule in canonical form, parts in a fixed order
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByMonth) > 0 {
+		parts = append(parts, "BYMONTH="+joinInts(r.ByMonth))
+	}
+	if len(r.ByMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+joinInts(r.ByMonthDay))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, d := range r.ByDay {
+			days[i] = strings.ToUpper(d.Weekday.String()[:2])
+			if d.N != 0 {
+				days[i] = strconv.Itoa(d.N) + days[i]
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.BySetPos) > 0 {
+		parts = append(parts, "BYSETPOS="+joinInts(r.BySetPos))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	if r.Adjust != AdjustNone {
+		parts = append(parts, "ADJUST="+r.Adjust)
+	}
+	return strings.Join(parts, ";")
+}
+
+func joinInts(values []int) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ",")
+}
+
+// Iterator walks the occurrences of a rule in order
+type Iterator struct {
+	rule    Rule
+	start   time.Time
+	period  int
+	pending []time.Time
+	emitted int
+	last    time.Time
+	done    bool
+}
+
+// Iter returns an iterator over the occurrences from start on
+func (r Rule) Iter(start time.Time) *Iterator {
+	if r.Interval < 1 {
+		r.Interval = 1
+	}
+	return &Iterator{rule: r, start: start}
+}
+
+// Next returns the next occurrence, or false when the rule has ended
+func (it *Iterator) Next() (time.Time, bool) {
+	for empty := 0; len(it.pending) == 0; empty++ {
+		if it.done || empty >= maxEmptyPeriods {
+			it.done = true
+			return time.Time{}, false
+		}
+		it.pending = it.expand(it.period)
+		it.period++
+	}
+
+	t := it.pending[0]
+	it.pending = it.pending[1:]
+	if (it.rule.Until != nil && t.After(*it.rule.Until)) || (it.rule.Count > 0 && it.emitted >= it.rule.Count) {
+		it.done = true
+		it.pending = nil
+		return time.Time{}, false
+	}
+	it.emitted++
+	it.last = t
+	return t, true
+}
+
+// After returns the first occurrence after t
+func (r Rule) After(start, t time.Time) (time.Time, bool) {
+	it := r.Iter(start)
+	for {
+		next, ok := it.Next()
+		if !ok || next.After(t) {
+			return next, ok
+		}
+	}
+}
+
+// Between returns the occurrences from from to to, both inclusive
+func (r Rule) Between(start, from, to time.Time) []time.Time {
+	var dates []time.Time
+	it := r.Iter(start)
+	for {
+		next, ok := it.Next()
+		if !ok || next.After(to) {
+			return dates
+		}
+		if !next.Before(from) {
+			dates = append(dates, next)
+		}
+	}
+}
+
+// expand returns the occurrences of the nth period after the start, sorted,
+// without those before the start or repeating the previous one
+func (it *Iterator) expand(n int) []time.Time {
+	r, start := it.rule, it.start
+	var days []time.Time
+
+	switch r.Freq {
+	case Daily:
+		day := start.AddDate(0, 0, n*r.Interval)
+		if r.matchesWeekday(day) && r.matchesMonthDay(day) && r.matchesMonth(day) {
+			days = append(days, day)
+		}
+	case Weekly:
+		// Weeks start on Monday
+		offset := (int(start.Weekday()) + 6) % 7
+		monday := start.AddDate(0, 0, n*r.Interval*7-offset)
+		for i := 0; i < 7; i++ {
+			day := monday.AddDate(0, 0, i)
+			if len(r.ByDay) == 0 && day.Weekday() != start.Weekday() {
+				continue
+			}
+			if r.matchesWeekday(day) && r.matchesMonth(day) {
+				days = append(days, day)
+			}
+		}
+	case Monthly:
+		first := it.date(start.Year(), start.Month()+time.Month(n*r.Interval), 1)
+		if r.matchesMonth(first) {
+			days = r.monthDays(first, start)
+		}
+	case Yearly:
+		year := start.Year() + n*r.Interval
+		months := r.ByMonth
+		if len(months) == 0 {
+			months = []int{int(start.Month())}
+		}
+		for _, m := range months {
+			days = append(days, r.monthDays(it.date(year, time.Month(m), 1), start)...)
+		}
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	days = dedupe(days)
+	days = r.setPos(days)
+
+	out := make([]time.Time, 0, len(days))
+	for _, day := range days {
+		if day.Before(it.dayOf(start)) {
+			continue
+		}
+		day = r.adjust(day)
+		if !it.last.IsZero() && !day.After(it.last) {
+			continue
+		}
+		if len(out) > 0 && !day.After(out[len(out)-1]) {
+			continue
+		}
+		out = append(out, day)
+	}
+	return out
+}
+
+// monthDays returns the matching days of the month starting at first
+func (r Rule) monthDays(first, start time.Time) []time.Time {
+	last := first.AddDate(0, 1, -1).Day()
+	var days []time.Time
+
+	if len(r.ByMonthDay) > 0 {
+		for _, d := range r.ByMonthDay {
+			day := d
+			if d < 0 {
+				day = last + d + 1
+				if day < 1 {
+					continue
+				}
+			} else if day > last {
+				day = last
+			}
+			date := first.AddDate(0, 0, day-1)
+			if r.matchesWeekday(date) {
+				days = append(days, date)
+			}
+		}
+		return days
+	}
+
+	if len(r.ByDay) > 0 {
+		for _, wd := range r.ByDay {
+			var matches []time.Time
+			for day := 1; day <= last; day++ {
+				if date := first.AddDate(0, 0, day-1); date.Weekday() == wd.Weekday {
+					matches = append(matches, date)
+				}
+			}
+			switch {
+			case wd.N == 0:
+				days = append(days, matches...)
+			case wd.N > 0 && wd.N <= len(matches):
+				days = append(days, matches[wd.N-1])
+			case wd.N < 0 && -wd.N <= len(matches):
+				days = append(days, matches[len(matches)+wd.N])
+			}
+		}
+		return days
+	}
+
+	// The start's day of the month, clamped to short months
+	day := start.Day()
+	if day > last {
+		day = last
+	}
+	return []time.Time{first.AddDate(0, 0, day-1)}
+}
+
+// matchesWeekday reports whether day is one of the BYDAY weekdays, ignoring
+// ordinals, which monthDays handles
+func (r Rule) matchesWeekday(day time.Time) bool {
+	if len(r.ByDay) == 0 || r.Freq == Monthly || r.Freq == Yearly {
+		return true
+	}
+	for _, wd := range r.ByDay {
+		if wd.Weekday == day.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchesMonthDay(day time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	last := day.AddDate(0, 1, -day.Day()).Day()
+	for _, d := range r.ByMonthDay {
+		if d == day.Day() || last+d+1 == day.Day() || (d > last && day.Day() == last) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchesMonth(day time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, m := range r.ByMonth {
+		if time.Month(m) == day.Month() {
+			return true
+		}
+	}
+	return false
+}
+
+// setPos keeps the BYSETPOS positions of the period's sorted days
+func (r Rule) setPos(days []time.Time) []time.Time {
+	if len(r.BySetPos) == 0 {
+		return days
+	}
+	var out []time.Time
+	for _, pos := range r.BySetPos {
+		i := pos - 1
+		if pos < 0 {
+			i = len(days) + pos
+		}
+		if i >= 0 && i < len(days) {
+			out = append(out, days[i])
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Before(out[j]) })
+	return dedupe(out)
+}
+
+// adjust moves a weekend day to the previous or next business day
+func (r Rule) adjust(day time.Time) time.Time {
+	switch r.Adjust {
+	case AdjustPrev:
+		for day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			day = day.AddDate(0, 0, -1)
+		}
+	case AdjustNext:
+		for day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			day = day.AddDate(0, 0, 1)
+		}
+	}
+	return day
+}
+
+// date builds a day at the start's time of day; months out of range wrap
+func (it *Iterator) date(year int, month time.Month, day int) time.Time {
+	s := it.start
+	return time.Date(year, month, day, s.Hour(), s.Minute(), s.Second(), s.Nanosecond(), s.Location())
+}
+
+// dayOf returns the start of t's day, so occurrences on the start date count
+// even when the rule adjusts them
+func (it *Iterator) dayOf(t time.Time) time.Time {
+	return it.date(t.Year(), t.Month(), t.Day())
+}
+
+func dedupe(days []time.Time) []time.Time {
+	out := days[:0]
+	for i, day := range days {
+		if i == 0 || !day.Equal(days[i-1]) {
+			out = append(out, day)
+		}
+	}
+	return out
+}
@@ -0,0 +1,73 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Retention is a grandfather-father-son policy: the newest snapshot of each
+// of the last Daily days, Weekly ISO weeks and Monthly months is kept.
+type Retention struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// Prune deletes the user's snapshots of the given kind that the retention
+// policy doesn't keep and returns their names. Other kinds are left alone.
+func (s *Store) Prune(userID uint, kind string, retention Retention) ([]string, error) {
+	snapshots, err := s.List(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var ofKind []Snapshot
+	for _, snap := range snapshots {
+		if snap.Kind == kind {
+			ofKind = append(ofKind, snap)
+		}
+	}
+
+	keep := retention.Keep(ofKind)
+	var removed []string
+	for _, snap := range ofKind {
+		if keep[snap.Name] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.userDir(userID), snap.Name)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, snap.Name)
+	}
+	return removed, nil
+}
+
+// Keep returns the names of the snapshots to keep. Snapshots must be sorted
+// newest first, as List returns them.
+func (r Retention) Keep(snapshots []Snapshot) map[string]bool {
+	keep := make(map[string]bool)
+	bucket := func(limit int, period func(t time.Time) string) {
+		seen := make(map[string]bool)
+		for _, snap := range snapshots {
+			if len(seen) >= limit {
+				return
+			}
+			p := period(snap.CreatedAt)
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			keep[snap.Name] = true
+		}
+	}
+
+	bucket(r.Daily, func(t time.Time) string { return t.Format("2006-01-02") })
+	bucket(r.Weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	bucket(r.Monthly, func(t time.Time) string { return t.Format("2006-01") })
+	return keep
+}
@@ -0,0 +1,141 @@
+// Package snapshot keeps backup files of each user on disk, one directory
+// per user.
+package snapshot
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// timeFormat is the creation time in snapshot names
+const timeFormat = "20060102T150405.000Z"
+
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+type Snapshot struct {
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"` // Why it was taken, e.g. pre-import
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size"`
+}
+
+// Save writes v as JSON to a new snapshot named after kind and the current time
+func (s *Store) Save(userID uint, kind string, v interface{}) (*Snapshot, error) {
+	return s.SaveFunc(userID, kind, "json", func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(v)
+	})
+}
+
+// SaveFunc creates a new snapshot file with the given extension and lets
+// write fill it, for snapshots too large to build in memory
+func (s *Store) SaveFunc(userID uint, kind, ext string, write func(w io.Writer) error) (*Snapshot, error) {
+	dir := s.userDir(userID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	name := fmt.Sprintf("%s-%s.%s", kind, now.Format(timeFormat), ext)
+	path := filepath.Join(dir, name)
+
+	// Write to a temporary file first so a crash never leaves half a snapshot
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	buf := bufio.NewWriter(tmp)
+	if err := write(buf); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := buf.Flush(); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{Name: name, Kind: kind, CreatedAt: now, Size: info.Size()}, nil
+}
+
+func (s *Store) userDir(userID uint) string {
+	return filepath.Join(s.dir, fmt.Sprint(userID))
+}
+
+// List returns the user's snapshots, newest first
+func (s *Store) List(userID uint) ([]Snapshot, error) {
+	entries, err := os.ReadDir(s.userDir(userID))
+	if os.IsNotExist(err) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []Snapshot{}
+	for _, entry := range entries {
+		snap, ok := parseName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snap.Size = info.Size()
+		snapshots = append(snapshots, snap)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+// Open opens one of the user's snapshots by name
+func (s *Store) Open(userID uint, name string) (io.ReadCloser, error) {
+	if _, ok := parseName(name); !ok || filepath.Base(name) != name {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(filepath.Join(s.userDir(userID), name))
+}
+
+// parseName reads kind and creation time from a snapshot name like
+// "scheduled-20240105T020000.000Z.ndjson"
+func parseName(name string) (Snapshot, bool) {
+	base := name
+	if ext := filepath.Ext(name); ext != "" {
+		base = strings.TrimSuffix(name, ext)
+	}
+	i := strings.LastIndex(base, "-")
+	if i <= 0 || strings.HasPrefix(name, ".") {
+		return Snapshot{}, false
+	}
+	createdAt, err := time.Parse(timeFormat, base[i+1:])
+	if err != nil {
+		return Snapshot{}, false
+	}
+	return Snapshot{Name: name, Kind: base[:i], CreatedAt: createdAt}, true
+}
@@ -246,7 +246,9 @@ export default function CalendarPage() {
                                                 : 'text-red-600'
                                                 }`}>
                                                 {event.type === 'income' || event.type === 'debt_receivable' ? '+' : '-'}
-                                                {formatCurrency(event.amount)}
+                                                {event.amount_type === 'percent_balance'
+                                                    ? `${event.amount}%`
+                                                    : formatCurrency(event.amount)}
                                             </div>
                                         </div>
                                     ))}
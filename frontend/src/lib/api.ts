@@ -1,5 +1,5 @@
 import axios from 'axios';
-import { AuthResponse, Category, Wallet, Transaction, Budget, DashboardSummary, TransactionListResponse, Goal, User, RecurringTransaction, GoalItem, FinancialScoreResponse, GamificationStatus, MonthlyReport, Debt } from '@/types/definitions';
+import { AuthResponse, Category, Wallet, Transaction, Budget, BudgetPeriod, EnvelopeMonth, EnvelopeMove, DashboardSummary, TransactionListResponse, Goal, User, RecurringTransaction, GoalItem, FinancialScoreResponse, GamificationStatus, MonthlyReport, Debt } from '@/types/definitions';
 
 const API_BASE_URL = process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080/api';
 console.log('🔌 API Base URL:', API_BASE_URL); // Debugging line
@@ -162,6 +162,34 @@ export const budgetsApi = {
     delete: async (id: number): Promise<void> => {
         await api.delete(`/budgets/${id}`);
     },
+    periods: async (id: number, count?: number): Promise<BudgetPeriod[]> => {
+        const { data } = await api.get(`/budgets/${id}/periods`, { params: count ? { count } : {} });
+        return data;
+    },
+};
+
+// Envelopes API, months are YYYY-MM
+export const envelopesApi = {
+    getMonth: async (month?: string): Promise<EnvelopeMonth> => {
+        const { data } = await api.get('/envelopes', { params: month ? { month } : {} });
+        return data;
+    },
+    assign: async (payload: { month?: string; budget_id: number; amount: number }): Promise<EnvelopeMove> => {
+        const { data } = await api.post('/envelopes/assign', payload);
+        return data;
+    },
+    move: async (payload: { month?: string; from_budget_id?: number; to_budget_id?: number; amount: number; note?: string }): Promise<EnvelopeMove> => {
+        const { data } = await api.post('/envelopes/move', payload);
+        return data;
+    },
+    moves: async (month?: string): Promise<EnvelopeMove[]> => {
+        const { data } = await api.get('/envelopes/moves', { params: month ? { month } : {} });
+        return data;
+    },
+    rollover: async (month?: string): Promise<EnvelopeMonth> => {
+        const { data } = await api.post('/envelopes/rollover', month ? { month } : {});
+        return data;
+    },
 };
 
 // Goals API
@@ -100,6 +100,14 @@ export interface Budget {
     category_id: number;
     amount: number;
     period: 'weekly' | 'monthly' | 'yearly';
+    start_date?: string;
+    rollover_policy?: 'none' | 'unspent' | 'overspent' | 'both';
+    rollover_cap?: number | null;
+    mode?: 'cap' | 'envelope';
+    period_start: string;
+    period_end: string;
+    carried_in: number;
+    effective_amount: number;
     spent: number;
     remaining: number;
     percentage: number;
@@ -107,6 +115,54 @@ export interface Budget {
     created_at: string;
 }
 
+export interface BudgetPeriod {
+    id: number;
+    budget_id: number;
+    start: string;
+    end: string;
+    amount: number;
+    rollover_policy: 'none' | 'unspent' | 'overspent' | 'both';
+    rollover_cap?: number | null;
+    carried_in: number;
+    effective_amount: number;
+    spent: number;
+    carried_out: number;
+    remaining: number;
+    percentage: number;
+}
+
+export interface EnvelopeBalance {
+    budget_id: number;
+    category_id: number;
+    month: string;
+    carried_in: number;
+    assigned: number;
+    spent: number;
+    available: number;
+    category?: Category;
+}
+
+export interface EnvelopeMonth {
+    month: string;
+    carried_in: number;
+    income: number;
+    assigned: number;
+    overspent: number;
+    to_be_budgeted: number;
+    closed: boolean;
+    envelopes: EnvelopeBalance[];
+}
+
+export interface EnvelopeMove {
+    id: number;
+    created_at: string;
+    month: string;
+    from_budget_id?: number | null;
+    to_budget_id?: number | null;
+    amount: number;
+    note: string;
+}
+
 export interface DashboardSummary {
     total_income: number;
     total_expense: number;
@@ -120,6 +176,7 @@ export interface DashboardSummary {
     expense_change_pct: number;
     monthly_income: number;
     monthly_expense: number;
+    pending_recurring?: RecurringOccurrence[];
 }
 
 export interface CategorySpending {
@@ -137,9 +194,14 @@ export interface BudgetProgress {
     category_id: number;
     category_name: string;
     budget_amount: number;
+    carried_in: number;
+    effective_amount: number;
     spent_amount: number;
     remaining: number;
     percentage: number;
+    period: 'weekly' | 'monthly' | 'yearly';
+    period_start: string;
+    period_end: string;
 }
 
 export interface DailyTrend {
@@ -192,12 +254,20 @@ export interface RecurringTransaction {
     id: number;
     user_id: number;
     wallet_id: number;
+    target_wallet_id?: number;
     category_id: number;
     amount: number;
-    type: 'income' | 'expense';
+    amount_type?: 'fixed' | 'percent_balance' | 'estimated';
+    estimate_method?: 'average' | 'median' | 'last';
+    estimate_window?: number;
+    estimated_amount?: number;
+    type: 'income' | 'expense' | 'transfer';
     description: string;
     frequency: 'daily' | 'weekly' | 'monthly' | 'yearly';
     start_date: string;
+    rrule?: string;
+    backlog_policy?: 'all' | 'latest' | 'review';
+    require_confirmation?: boolean;
     next_run_date: string;
     is_active: boolean;
     last_run_date?: string;
@@ -205,6 +275,19 @@ export interface RecurringTransaction {
     category?: Category;
 }
 
+export interface RecurringOccurrence {
+    id: number;
+    key: string;
+    recurring_id: number;
+    occurrence_date: string;
+    status: 'posted' | 'skipped' | 'review' | 'pending';
+    snoozed_until?: string;
+    amount: number;
+    transaction_id?: number;
+    estimated: boolean;
+    recurring?: RecurringTransaction;
+}
+
 export interface GoalItem {
     id: number;
     goal_id: number;
@@ -277,6 +360,8 @@ export interface CalendarEvent {
     source: 'recurring' | 'debt';
     source_id: number;
     category_icon?: string;
+    estimated?: boolean;
+    amount_type?: 'fixed' | 'percent_balance' | 'estimated'; // percent_balance: amount is a % of the wallet balance
 }
 
 export interface CalendarResponse {